@@ -0,0 +1,237 @@
+// Package agent keeps the keys of unlocked cmdb files in memory, so that
+// commands neither ask for the password again nor derive the key from it.
+//
+// The agent listens on a unix socket in a directory only the user can enter.
+// Keys are held by an ID chosen by the client, for cmdb files their file ID
+// ("file:" and the ID of their binding) or, for files in field mode and files
+// written before bindings, the ID of their KDF. They are forgotten once unused
+// for their TTL, or when the agent is told to lock.
+package agent
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// SocketEnv overrides the path of the agent socket.
+	SocketEnv = "CMDB_AGENT_SOCK"
+	// DefaultTTL is how long an unused key is kept.
+	DefaultTTL = 10 * time.Minute
+)
+
+const (
+	opGet    = "get"
+	opPut    = "put"
+	opLock   = "lock"
+	opStatus = "status"
+)
+
+type request struct {
+	Op  string `json:"op"`
+	ID  string `json:"id,omitempty"`
+	Key []byte `json:"key,omitempty"`
+	// TTL in seconds; 0 is the agent's default.
+	TTL int64 `json:"ttl,omitempty"`
+}
+
+type response struct {
+	Error   string `json:"error,omitempty"`
+	Key     []byte `json:"key,omitempty"`
+	Entries int    `json:"entries,omitempty"`
+}
+
+// SocketPath returns the path of the agent socket: $CMDB_AGENT_SOCK, or
+// ~/.config/cmdb/agent/agent.sock.
+func SocketPath() string {
+	if p := os.Getenv(SocketEnv); p != "" {
+		return p
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = os.TempDir()
+	}
+	return filepath.Join(home, ".config", "cmdb", "agent", "agent.sock")
+}
+
+// Agent holds keys in memory.
+type Agent struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*entry
+}
+
+type entry struct {
+	key  []byte
+	ttl  time.Duration
+	used time.Time
+}
+
+// New returns an agent keeping unused keys for ttl by default.
+func New(ttl time.Duration) *Agent {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	return &Agent{ttl: ttl, entries: make(map[string]*entry)}
+}
+
+// Listen creates the socket at path, readable by the user only. A stale
+// socket left by an agent that died is replaced. The directory of the socket
+// is created only the user can enter; one that exists must belong to the
+// user and be writable by no one else.
+func Listen(path string) (net.Listener, error) {
+	dir := filepath.Dir(path)
+	if info, err := os.Stat(dir); os.IsNotExist(err) {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, err
+		}
+		if err := os.Chmod(dir, 0700); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	} else if err := checkDir(dir, info); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path); err == nil {
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return nil, errors.New("an agent is already running at " + path)
+		}
+		os.Remove(path)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// Serve answers the requests of clients on ln until it is closed. Expired
+// keys are wiped as they expire.
+func (a *Agent) Serve(ln net.Listener) error {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				a.mu.Lock()
+				a.expire(now)
+				a.mu.Unlock()
+			}
+		}
+	}()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go a.serveConn(conn)
+	}
+}
+
+func (a *Agent) serveConn(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	scanner := bufio.NewScanner(conn)
+	enc := json.NewEncoder(conn)
+	for scanner.Scan() {
+		var req request
+		var resp response
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			resp.Error = "invalid request"
+		} else {
+			resp = a.handle(req, time.Now())
+		}
+		if err := enc.Encode(resp); err != nil {
+			return
+		}
+	}
+}
+
+func (a *Agent) handle(req request, now time.Time) response {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.expire(now)
+
+	switch req.Op {
+	case opGet:
+		e, ok := a.entries[req.ID]
+		if !ok {
+			return response{}
+		}
+		e.used = now
+		return response{Key: e.key}
+	case opPut:
+		if req.ID == "" || len(req.Key) == 0 {
+			return response{Error: "missing id or key"}
+		}
+		ttl := a.ttl
+		if req.TTL > 0 {
+			ttl = time.Duration(req.TTL) * time.Second
+		}
+		a.forget(req.ID)
+		a.entries[req.ID] = &entry{key: req.Key, ttl: ttl, used: now}
+		return response{}
+	case opLock:
+		if req.ID != "" {
+			a.forget(req.ID)
+		} else {
+			for id := range a.entries {
+				a.forget(id)
+			}
+		}
+		return response{}
+	case opStatus:
+		return response{Entries: len(a.entries)}
+	}
+	return response{Error: "unknown request " + req.Op}
+}
+
+// Wipe forgets every key.
+func (a *Agent) Wipe() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for id := range a.entries {
+		a.forget(id)
+	}
+}
+
+// expire forgets the keys unused for their TTL. a.mu must be held.
+func (a *Agent) expire(now time.Time) {
+	for id, e := range a.entries {
+		if now.Sub(e.used) > e.ttl {
+			a.forget(id)
+		}
+	}
+}
+
+// forget wipes and removes the key held for id. a.mu must be held.
+func (a *Agent) forget(id string) {
+	if e, ok := a.entries[id]; ok {
+		for i := range e.key {
+			e.key[i] = 0
+		}
+		delete(a.entries, id)
+	}
+}
@@ -0,0 +1,93 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAgent(t *testing.T) {
+	t.Setenv(SocketEnv, filepath.Join(t.TempDir(), "agent", "agent.sock"))
+	_, err := Get("a")
+	require.ErrorIs(t, err, ErrNotRunning)
+
+	ln, err := Listen(SocketPath())
+	require.Nil(t, err)
+	a := New(time.Minute)
+	done := make(chan error)
+	go func() { done <- a.Serve(ln) }()
+
+	_, err = Listen(SocketPath())
+	require.NotNil(t, err)
+
+	require.Nil(t, Put("a", []byte("key-a"), 0))
+	require.Nil(t, Put("b", []byte("key-b"), time.Hour))
+	key, err := Get("a")
+	require.Nil(t, err)
+	require.Equal(t, []byte("key-a"), key)
+	key, err = Get("c")
+	require.Nil(t, err)
+	require.Nil(t, key)
+	n, err := Status()
+	require.Nil(t, err)
+	require.Equal(t, 2, n)
+
+	require.Nil(t, Lock())
+	n, err = Status()
+	require.Nil(t, err)
+	require.Equal(t, 0, n)
+
+	ln.Close()
+	require.Nil(t, <-done)
+}
+
+func TestAgentTTL(t *testing.T) {
+	a := New(time.Minute)
+	now := time.Now()
+	a.handle(request{Op: opPut, ID: "a", Key: []byte("key-a")}, now)
+	a.handle(request{Op: opPut, ID: "b", Key: []byte("key-b"), TTL: 3600}, now)
+
+	// Using a key restarts its TTL.
+	resp := a.handle(request{Op: opGet, ID: "a"}, now.Add(50*time.Second))
+	require.Equal(t, []byte("key-a"), resp.Key)
+	resp = a.handle(request{Op: opGet, ID: "a"}, now.Add(100*time.Second))
+	require.Equal(t, []byte("key-a"), resp.Key)
+
+	resp = a.handle(request{Op: opGet, ID: "a"}, now.Add(200*time.Second))
+	require.Nil(t, resp.Key)
+	resp = a.handle(request{Op: opGet, ID: "b"}, now.Add(200*time.Second))
+	require.Equal(t, []byte("key-b"), resp.Key)
+}
+
+func TestListenDir(t *testing.T) {
+	// A directory it creates is made private.
+	dir := filepath.Join(t.TempDir(), "agent")
+	ln, err := Listen(filepath.Join(dir, "agent.sock"))
+	require.Nil(t, err)
+	ln.Close()
+	info, err := os.Stat(dir)
+	require.Nil(t, err)
+	if runtime.GOOS != "windows" {
+		require.Equal(t, os.FileMode(0700), info.Mode().Perm())
+	}
+
+	// An existing one is left as it is, if safe.
+	dir = t.TempDir()
+	require.Nil(t, os.Chmod(dir, 0755))
+	ln, err = Listen(filepath.Join(dir, "agent.sock"))
+	require.Nil(t, err)
+	ln.Close()
+	info, err = os.Stat(dir)
+	require.Nil(t, err)
+	require.Equal(t, os.FileMode(0755), info.Mode().Perm())
+
+	if runtime.GOOS != "windows" {
+		require.Nil(t, os.Chmod(dir, 0777))
+		_, err = Listen(filepath.Join(dir, "agent.sock"))
+		require.NotNil(t, err)
+	}
+}
@@ -0,0 +1,74 @@
+package agent
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"time"
+)
+
+// ErrNotRunning is returned when no agent listens on the socket.
+var ErrNotRunning = errors.New("agent is not running")
+
+// call sends req to the agent and returns its response.
+func call(req request) (response, error) {
+	var resp response
+	conn, err := net.DialTimeout("unix", SocketPath(), time.Second)
+	if err != nil {
+		return resp, ErrNotRunning
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return resp, err
+	}
+	if _, err := conn.Write(append(data, '\n')); err != nil {
+		return resp, err
+	}
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		return resp, err
+	}
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return resp, err
+	}
+	if resp.Error != "" {
+		return resp, errors.New(resp.Error)
+	}
+	return resp, nil
+}
+
+// Get returns the key held for id, or nil if the agent does not hold it.
+// Getting a key restarts its TTL.
+func Get(id string) ([]byte, error) {
+	resp, err := call(request{Op: opGet, ID: id})
+	return resp.Key, err
+}
+
+// Put hands the key for id to the agent, to be kept until unused for ttl,
+// or for the agent's default TTL if ttl is 0.
+func Put(id string, key []byte, ttl time.Duration) error {
+	_, err := call(request{Op: opPut, ID: id, Key: key, TTL: int64(ttl / time.Second)})
+	return err
+}
+
+// Lock makes the agent forget every key.
+func Lock() error {
+	_, err := call(request{Op: opLock})
+	return err
+}
+
+// Forget makes the agent forget the key held for id.
+func Forget(id string) error {
+	_, err := call(request{Op: opLock, ID: id})
+	return err
+}
+
+// Status returns the number of keys the agent holds.
+func Status() (int, error) {
+	resp, err := call(request{Op: opStatus})
+	return resp.Entries, err
+}
@@ -0,0 +1,17 @@
+//go:build !unix
+
+package agent
+
+import (
+	"fmt"
+	"os"
+)
+
+// checkDir only checks that dir is a directory: its owner and mode are not
+// those of unix here.
+func checkDir(dir string, info os.FileInfo) error {
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+//go:build unix
+
+package agent
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// checkDir refuses a socket directory others could replace the socket in.
+func checkDir(dir string, info os.FileInfo) error {
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok && int(st.Uid) != os.Getuid() {
+		return fmt.Errorf("%s belongs to another user, choose another %s", dir, SocketEnv)
+	}
+	if info.Mode().Perm()&0022 != 0 {
+		return fmt.Errorf("%s is writable by other users (mode %v), run chmod go-w on it or choose another %s", dir, info.Mode().Perm(), SocketEnv)
+	}
+	return nil
+}
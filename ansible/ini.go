@@ -0,0 +1,292 @@
+package ansible
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DetectFormat tells YAML inventories from INI ones.
+func DetectFormat(data []byte) string {
+	var m map[string]interface{}
+	if err := yaml.Unmarshal(data, &m); err == nil && len(m) > 0 {
+		for _, v := range m {
+			if _, ok := v.(map[string]interface{}); !ok && v != nil {
+				return FormatIni
+			}
+		}
+		return FormatYaml
+	}
+	return FormatIni
+}
+
+// parseIni reads an INI inventory:
+//
+//	web1 ansible_host=10.0.0.1
+//
+//	[web]
+//	web[1:3].example.com ansible_port=2222
+//
+//	[web:vars]
+//	ansible_user=deploy
+//
+//	[prod:children]
+//	web
+//
+// Variables given on host lines are typed like Python literals, those of
+// :vars sections are strings.
+func parseIni(data []byte) (*Inventory, error) {
+	inv := &Inventory{}
+	inv.Group(GroupAll)
+	group, kind := GroupUngrouped, "hosts"
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' {
+			end := strings.IndexByte(line, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated section %q", i+1, line)
+			}
+			group, kind = line[1:end], "hosts"
+			if j := strings.LastIndexByte(group, ':'); j >= 0 {
+				group, kind = group[:j], group[j+1:]
+			}
+			switch kind {
+			case "hosts", "vars", "children":
+			default:
+				return nil, fmt.Errorf("line %d: unknown section type %q", i+1, kind)
+			}
+			inv.Group(group)
+			if group != GroupAll {
+				inv.AddChild(GroupAll, group)
+			}
+			continue
+		}
+
+		switch kind {
+		case "vars":
+			k, v, ok := strings.Cut(line, "=")
+			if !ok {
+				return nil, fmt.Errorf("line %d: expected key=value", i+1)
+			}
+			fields, err := splitFields(v)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+			inv.Group(group).Vars[strings.TrimSpace(k)] = strings.Join(fields, " ")
+		case "children":
+			fields, err := splitFields(line)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+			inv.AddChild(group, fields[0])
+		default:
+			fields, err := splitFields(line)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+			names, err := expandHosts(fields[0])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+			vars := map[string]interface{}{}
+			for _, f := range fields[1:] {
+				k, v, ok := strings.Cut(f, "=")
+				if !ok {
+					return nil, fmt.Errorf("line %d: expected key=value, got %q", i+1, f)
+				}
+				vars[k] = literal(v)
+			}
+			for _, name := range names {
+				if host, port, ok := strings.Cut(name, ":"); ok && !strings.Contains(port, ":") {
+					if p, err := strconv.ParseInt(port, 10, 64); err == nil {
+						name = host
+						inv.Host(name).Vars["ansible_port"] = p
+					}
+				}
+				inv.AddHost(group, name)
+				for k, v := range vars {
+					inv.Host(name).Vars[k] = v
+				}
+			}
+		}
+	}
+	return inv, nil
+}
+
+// splitFields splits a line into whitespace separated fields like a shell:
+// quotes group text and are removed, and an unquoted # starts a comment.
+func splitFields(line string) ([]string, error) {
+	var fields []string
+	var b strings.Builder
+	inField := false
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && quote == '"' && i+1 < len(line) {
+				i++
+				b.WriteByte(line[i])
+			} else {
+				b.WriteByte(c)
+			}
+		case c == '\'' || c == '"':
+			quote, inField = c, true
+		case c == ' ' || c == '\t':
+			if inField {
+				fields = append(fields, b.String())
+				b.Reset()
+				inField = false
+			}
+		case c == '#' && !inField:
+			i = len(line)
+		default:
+			b.WriteByte(c)
+			inField = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in %q", line)
+	}
+	if inField {
+		fields = append(fields, b.String())
+	}
+	return fields, nil
+}
+
+// literal types a value given on a host line.
+func literal(s string) interface{} {
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil && strings.Contains(s, ".") {
+		return f
+	}
+	switch s {
+	case "True":
+		return true
+	case "False":
+		return false
+	}
+	return s
+}
+
+// expandHosts expands the ranges of a host pattern, e.g. web[01:03] or
+// db-[a:c], optionally with a stride as in web[1:9:2].
+func expandHosts(pattern string) ([]string, error) {
+	start := strings.IndexByte(pattern, '[')
+	if start < 0 {
+		return []string{pattern}, nil
+	}
+	end := strings.IndexByte(pattern[start:], ']')
+	if end < 0 {
+		return nil, fmt.Errorf("unterminated range in %q", pattern)
+	}
+	end += start
+	head, tail := pattern[:start], pattern[end+1:]
+	parts := strings.Split(pattern[start+1:end], ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		// Not a range, e.g. an IPv6 address.
+		return []string{pattern}, nil
+	}
+	stride := 1
+	if len(parts) == 3 {
+		var err error
+		if stride, err = strconv.Atoi(parts[2]); err != nil || stride < 1 {
+			return nil, fmt.Errorf("invalid range stride in %q", pattern)
+		}
+	}
+
+	var items []string
+	lo, errLo := strconv.Atoi(parts[0])
+	hi, errHi := strconv.Atoi(parts[1])
+	switch {
+	case errLo == nil && errHi == nil:
+		width := 0
+		if len(parts[0]) > 1 && parts[0][0] == '0' {
+			width = len(parts[0])
+		}
+		for i := lo; i <= hi; i += stride {
+			items = append(items, fmt.Sprintf("%0*d", width, i))
+		}
+	case len(parts[0]) == 1 && len(parts[1]) == 1:
+		for c := parts[0][0]; c <= parts[1][0]; c += byte(stride) {
+			items = append(items, string(c))
+			if int(c)+stride > 255 {
+				break
+			}
+		}
+	default:
+		return nil, fmt.Errorf("invalid range in %q", pattern)
+	}
+
+	var hosts []string
+	rest, err := expandHosts(tail)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		for _, r := range rest {
+			hosts = append(hosts, head+item+r)
+		}
+	}
+	return hosts, nil
+}
+
+// marshalIni writes the hosts with their variables first, followed by a
+// section per group.
+func (inv *Inventory) marshalIni() []byte {
+	var b bytes.Buffer
+	for _, h := range inv.Hosts {
+		b.WriteString(h.Name)
+		for _, k := range sortedVars(h.Vars) {
+			fmt.Fprintf(&b, " %s=%s", k, iniValue(h.Vars[k]))
+		}
+		b.WriteByte('\n')
+	}
+	for _, g := range inv.Groups {
+		if len(g.Hosts) > 0 && g.Name != GroupAll {
+			fmt.Fprintf(&b, "\n[%s]\n", g.Name)
+			for _, h := range g.Hosts {
+				b.WriteString(h + "\n")
+			}
+		}
+		if len(g.Children) > 0 && g.Name != GroupAll {
+			fmt.Fprintf(&b, "\n[%s:children]\n", g.Name)
+			for _, c := range g.Children {
+				b.WriteString(c + "\n")
+			}
+		}
+		if len(g.Vars) > 0 {
+			fmt.Fprintf(&b, "\n[%s:vars]\n", g.Name)
+			for _, k := range sortedVars(g.Vars) {
+				fmt.Fprintf(&b, "%s=%s\n", k, iniValue(g.Vars[k]))
+			}
+		}
+	}
+	return b.Bytes()
+}
+
+func iniValue(v interface{}) string {
+	switch n := v.(type) {
+	case string:
+		if n == "" || strings.ContainsAny(n, " \t#'\"\\=") {
+			return strconv.Quote(n)
+		}
+		return n
+	case bool:
+		if n {
+			return "True"
+		}
+		return "False"
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,237 @@
+// Package ansible reads and writes Ansible inventories in the INI and YAML
+// formats.
+package ansible
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Inventory formats.
+const (
+	FormatIni  = "ini"
+	FormatYaml = "yaml"
+)
+
+// Groups every inventory has.
+const (
+	GroupAll       = "all"
+	GroupUngrouped = "ungrouped"
+)
+
+// Inventory is a set of hosts and the groups they belong to.
+type Inventory struct {
+	Hosts  []*Host  // in order of first appearance
+	Groups []*Group // in order of first appearance
+}
+
+// Host is an inventory host and the variables set on it directly.
+type Host struct {
+	Name string
+	Vars map[string]interface{}
+}
+
+// Group is a named set of hosts and child groups.
+type Group struct {
+	Name     string
+	Hosts    []string
+	Children []string
+	Vars     map[string]interface{}
+}
+
+// Host returns the host called name, adding it if needed.
+func (inv *Inventory) Host(name string) *Host {
+	for _, h := range inv.Hosts {
+		if h.Name == name {
+			return h
+		}
+	}
+	h := &Host{Name: name, Vars: map[string]interface{}{}}
+	inv.Hosts = append(inv.Hosts, h)
+	return h
+}
+
+// Group returns the group called name, adding it if needed.
+func (inv *Inventory) Group(name string) *Group {
+	if g := inv.findGroup(name); g != nil {
+		return g
+	}
+	g := &Group{Name: name, Vars: map[string]interface{}{}}
+	inv.Groups = append(inv.Groups, g)
+	return g
+}
+
+func (inv *Inventory) findGroup(name string) *Group {
+	for _, g := range inv.Groups {
+		if g.Name == name {
+			return g
+		}
+	}
+	return nil
+}
+
+// AddHost adds host to group.
+func (inv *Inventory) AddHost(group, host string) {
+	inv.Host(host)
+	g := inv.Group(group)
+	if !contains(g.Hosts, host) {
+		g.Hosts = append(g.Hosts, host)
+	}
+}
+
+// AddChild makes child a child group of group.
+func (inv *Inventory) AddChild(group, child string) {
+	inv.Group(child)
+	g := inv.Group(group)
+	if !contains(g.Children, child) {
+		g.Children = append(g.Children, child)
+	}
+}
+
+// HostGroups returns the groups host belongs to, directly or through child
+// groups, sorted by name. all and ungrouped are left out.
+func (inv *Inventory) HostGroups(host string) []string {
+	var groups []string
+	for _, g := range inv.Groups {
+		if g.Name != GroupAll && g.Name != GroupUngrouped && inv.inGroup(g, host, 0) {
+			groups = append(groups, g.Name)
+		}
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+func (inv *Inventory) inGroup(g *Group, host string, depth int) bool {
+	if contains(g.Hosts, host) {
+		return true
+	}
+	if depth > len(inv.Groups) {
+		return false // a cycle of child groups
+	}
+	for _, c := range g.Children {
+		if child := inv.findGroup(c); child != nil && inv.inGroup(child, host, depth+1) {
+			return true
+		}
+	}
+	return false
+}
+
+// HostVars returns the variables of host with the precedence Ansible gives
+// them: those of all, then of the other groups from parents to children,
+// then the host's own.
+func (inv *Inventory) HostVars(host string) map[string]interface{} {
+	type ranked struct {
+		depth int
+		group *Group
+	}
+	var groups []ranked
+	for _, g := range inv.Groups {
+		if g.Name == GroupAll || !inv.inGroup(g, host, 0) {
+			continue
+		}
+		groups = append(groups, ranked{inv.depth(g.Name, 0), g})
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		if groups[i].depth != groups[j].depth {
+			return groups[i].depth < groups[j].depth
+		}
+		return groups[i].group.Name < groups[j].group.Name
+	})
+
+	vars := map[string]interface{}{}
+	if all := inv.findGroup(GroupAll); all != nil {
+		for k, v := range all.Vars {
+			vars[k] = v
+		}
+	}
+	for _, r := range groups {
+		for k, v := range r.group.Vars {
+			vars[k] = v
+		}
+	}
+	for _, h := range inv.Hosts {
+		if h.Name == host {
+			for k, v := range h.Vars {
+				vars[k] = v
+			}
+		}
+	}
+	return vars
+}
+
+// depth returns the number of ancestors of group below all.
+func (inv *Inventory) depth(group string, seen int) int {
+	if seen > len(inv.Groups) {
+		return seen
+	}
+	max := 0
+	for _, g := range inv.Groups {
+		if g.Name != GroupAll && contains(g.Children, group) {
+			if d := inv.depth(g.Name, seen+1) + 1; d > max {
+				max = d
+			}
+		}
+	}
+	return max
+}
+
+// Parse reads an inventory in format, or guesses the format when it is empty.
+func Parse(data []byte, format string) (*Inventory, error) {
+	if format == "" {
+		format = DetectFormat(data)
+	}
+	switch format {
+	case FormatIni:
+		return parseIni(data)
+	case FormatYaml:
+		return parseYaml(data)
+	}
+	return nil, fmt.Errorf("unknown inventory format %q, expected ini or yaml", format)
+}
+
+// Marshal writes the inventory in format.
+func (inv *Inventory) Marshal(format string) ([]byte, error) {
+	switch format {
+	case FormatIni, "":
+		return inv.marshalIni(), nil
+	case FormatYaml:
+		return inv.marshalYaml()
+	}
+	return nil, fmt.Errorf("unknown inventory format %q, expected ini or yaml", format)
+}
+
+// GroupName turns s into a valid group name: characters other than letters,
+// digits and underscores become underscores and a leading digit is prefixed
+// with one.
+func GroupName(s string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		}
+		return '_'
+	}, s)
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "_" + name
+	}
+	return name
+}
+
+func sortedVars(vars map[string]interface{}) []string {
+	keys := make([]string, 0, len(vars))
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package ansible
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const sampleIni = `
+# comment
+bastion ansible_host=10.0.0.1 ansible_port=2222
+
+[web]
+web[01:03].example.com ansible_user=deploy
+db.example.com:5432 note="two words" # trailing comment
+
+[web:vars]
+ansible_user=ops
+http_port=80
+
+[prod:children]
+web
+
+[prod:vars]
+ansible_user=root
+env=prod
+
+[all:vars]
+ansible_python_interpreter=/usr/bin/python3
+`
+
+func TestParseIni(t *testing.T) {
+	inv, err := Parse([]byte(sampleIni), "")
+	require.Nil(t, err)
+
+	var names []string
+	for _, h := range inv.Hosts {
+		names = append(names, h.Name)
+	}
+	require.Equal(t, []string{"bastion", "web01.example.com", "web02.example.com", "web03.example.com", "db.example.com"}, names)
+
+	require.Equal(t, []string{"prod", "web"}, inv.HostGroups("web02.example.com"))
+	require.Empty(t, inv.HostGroups("bastion"))
+
+	// Host vars win over web, which wins over its parent prod.
+	require.Equal(t, map[string]interface{}{
+		"ansible_python_interpreter": "/usr/bin/python3",
+		"ansible_user":               "deploy",
+		"http_port":                  "80",
+		"env":                        "prod",
+	}, inv.HostVars("web01.example.com"))
+	require.Equal(t, map[string]interface{}{
+		"ansible_python_interpreter": "/usr/bin/python3",
+		"ansible_user":               "ops",
+		"ansible_port":               int64(5432),
+		"http_port":                  "80",
+		"env":                        "prod",
+		"note":                       "two words",
+	}, inv.HostVars("db.example.com"))
+	require.Equal(t, int64(2222), inv.HostVars("bastion")["ansible_port"])
+}
+
+func TestExpandHosts(t *testing.T) {
+	hosts, err := expandHosts("db-[a:c]-[1:5:2]")
+	require.Nil(t, err)
+	require.Equal(t, []string{"db-a-1", "db-a-3", "db-a-5", "db-b-1", "db-b-3", "db-b-5", "db-c-1", "db-c-3", "db-c-5"}, hosts)
+
+	_, err = expandHosts("web[1:")
+	require.NotNil(t, err)
+}
+
+func TestRoundTrip(t *testing.T) {
+	inv := &Inventory{}
+	inv.Host("web1").Vars["ansible_host"] = "10.0.0.1"
+	inv.Host("web1").Vars["ansible_port"] = int64(22)
+	inv.Host("web1").Vars["note"] = "a b"
+	inv.AddHost("ns", "web1")
+	inv.AddHost("prod", "web1")
+	inv.AddHost("ns", "db")
+	inv.Group("prod").Vars["ansible_user"] = "deploy"
+
+	for _, format := range []string{FormatIni, FormatYaml} {
+		data, err := inv.Marshal(format)
+		require.Nil(t, err, format)
+		back, err := Parse(data, "")
+		require.Nil(t, err, format)
+		require.Equal(t, format, DetectFormat(data))
+		require.Equal(t, []string{"ns", "prod"}, back.HostGroups("web1"), format)
+		require.Equal(t, []string{"ns"}, back.HostGroups("db"), format)
+		require.Equal(t, map[string]interface{}{
+			"ansible_host": "10.0.0.1",
+			"ansible_port": int64(22),
+			"ansible_user": "deploy",
+			"note":         "a b",
+		}, back.HostVars("web1"), format)
+	}
+}
+
+func TestGroupName(t *testing.T) {
+	require.Equal(t, "web_edge", GroupName("web-edge"))
+	require.Equal(t, "_2024", GroupName("2024"))
+}
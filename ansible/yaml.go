@@ -0,0 +1,165 @@
+package ansible
+
+import (
+	"bytes"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// parseYaml reads a YAML inventory:
+//
+//	all:
+//	  hosts:
+//	    web1:
+//	      ansible_host: 10.0.0.1
+//	  children:
+//	    prod:
+//	      hosts:
+//	        web1:
+//	      vars:
+//	        ansible_user: deploy
+func parseYaml(data []byte) (*Inventory, error) {
+	var groups map[string]interface{}
+	if err := yaml.Unmarshal(data, &groups); err != nil {
+		return nil, err
+	}
+	inv := &Inventory{}
+	inv.Group(GroupAll)
+	for _, name := range sortedVars(groups) {
+		if err := inv.parseYamlGroup(name, groups[name]); err != nil {
+			return nil, err
+		}
+		if name != GroupAll {
+			inv.AddChild(GroupAll, name)
+		}
+	}
+	return inv, nil
+}
+
+func (inv *Inventory) parseYamlGroup(name string, data interface{}) error {
+	g := inv.Group(name)
+	if data == nil {
+		return nil
+	}
+	m, ok := data.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("group %s: expected a mapping, got %T", name, data)
+	}
+	hosts, err := yamlMap(m["hosts"], name, "hosts")
+	if err != nil {
+		return err
+	}
+	for _, h := range sortedVars(hosts) {
+		inv.AddHost(name, h)
+		vars, err := yamlMap(hosts[h], name, "host "+h)
+		if err != nil {
+			return err
+		}
+		for k, v := range vars {
+			inv.Host(h).Vars[k] = yamlValue(v)
+		}
+	}
+	vars, err := yamlMap(m["vars"], name, "vars")
+	if err != nil {
+		return err
+	}
+	for k, v := range vars {
+		g.Vars[k] = yamlValue(v)
+	}
+	children, err := yamlMap(m["children"], name, "children")
+	if err != nil {
+		return err
+	}
+	for _, c := range sortedVars(children) {
+		inv.AddChild(name, c)
+		if err := inv.parseYamlGroup(c, children[c]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func yamlMap(v interface{}, group, what string) (map[string]interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("group %s: %s must be a mapping, got %T", group, what, v)
+	}
+	return m, nil
+}
+
+// yamlValue converts the ints of YAML to the int64 of TOML.
+func yamlValue(v interface{}) interface{} {
+	switch n := v.(type) {
+	case int:
+		return int64(n)
+	case map[string]interface{}:
+		for k, e := range n {
+			n[k] = yamlValue(e)
+		}
+	case []interface{}:
+		for i, e := range n {
+			n[i] = yamlValue(e)
+		}
+	}
+	return v
+}
+
+// marshalYaml writes the hosts with their variables under all and every
+// group as a child of all.
+func (inv *Inventory) marshalYaml() ([]byte, error) {
+	hosts := map[string]interface{}{}
+	for _, h := range inv.Hosts {
+		if len(h.Vars) > 0 {
+			hosts[h.Name] = h.Vars
+		} else {
+			hosts[h.Name] = nil
+		}
+	}
+	all := map[string]interface{}{"hosts": hosts}
+	children := map[string]interface{}{}
+	for _, g := range inv.Groups {
+		if g.Name == GroupAll {
+			if len(g.Vars) > 0 {
+				all["vars"] = g.Vars
+			}
+			continue
+		}
+		group := map[string]interface{}{}
+		if len(g.Hosts) > 0 {
+			members := map[string]interface{}{}
+			for _, h := range g.Hosts {
+				members[h] = nil
+			}
+			group["hosts"] = members
+		}
+		if len(g.Children) > 0 {
+			sub := map[string]interface{}{}
+			for _, c := range g.Children {
+				sub[c] = nil
+			}
+			group["children"] = sub
+		}
+		if len(g.Vars) > 0 {
+			group["vars"] = g.Vars
+		}
+		children[g.Name] = group
+	}
+	if len(children) > 0 {
+		all["children"] = children
+	}
+
+	var b bytes.Buffer
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(2)
+	if err := enc.Encode(map[string]interface{}{GroupAll: all}); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,194 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/MinseokOh/toml-cli/agent"
+	"github.com/MinseokOh/toml-cli/encrypt"
+	"github.com/MinseokOh/toml-cli/toml"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+// AgentTomlCommand returns agent command
+func AgentTomlCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "agent",
+		Short: "Keep the keys of unlocked cmdb files in memory",
+		Long: `
+Run the agent in the foreground. It listens on a socket only you can reach
+(~/.config/cmdb/agent/agent.sock, or $CMDB_AGENT_SOCK) and keeps the keys of
+the cmdb files unlocked with "cm unlock", or whose password was entered, until
+they are unused for --ttl or "cm lock" is run. Nothing is written to disk.
+
+"cm unlock" starts the agent when it is not running.
+
+e.g.
+cm agent --ttl 30m &
+`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ttl, err := cmd.Flags().GetDuration("ttl")
+			if err != nil {
+				return err
+			}
+			ln, err := agent.Listen(agent.SocketPath())
+			if err != nil {
+				return err
+			}
+			a := agent.New(ttl)
+
+			// Outlive the terminal, stop cleanly when told to.
+			signal.Ignore(syscall.SIGHUP)
+			stop := make(chan os.Signal, 1)
+			signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+			go func() {
+				<-stop
+				ln.Close()
+			}()
+
+			err = a.Serve(ln)
+			a.Wipe()
+			return err
+		},
+	}
+
+	cmd.Flags().Duration("ttl", agent.DefaultTTL, "forget keys unused for this long")
+	return cmd
+}
+
+// UnlockTomlCommand returns unlock command
+func UnlockTomlCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "unlock",
+		Short: "Hand the key of the cmdb to the agent",
+		Long: `
+Ask for the password of the cmdb once and hand its key to the agent, starting
+the agent if needed. Later commands get the key from the agent instead of
+asking for the password. Each file has its own key in the agent, kept by the
+file ID in its header, and its own --ttl.
+
+e.g.
+cm unlock
+cm unlock --ttl 1h
+`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ttl, err := cmd.Flags().GetDuration("ttl")
+			if err != nil {
+				return err
+			}
+			if err := ensureAgent(); err != nil {
+				return err
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			toml.AgentTTL = ttl
+			tomlFile, err := toml.NewToml(path)
+			if err != nil {
+				return err
+			}
+			defer tomlFile.Close()
+			if err := tomlFile.Unlock(); err != nil {
+				return err
+			}
+
+			if !tomlFile.FieldEncrypted() && (!encrypt.IsEncrypted(data) || encrypt.IsRecipientEncrypted(data)) {
+				color.Yellow("%s is not encrypted with a password", path)
+				return nil
+			}
+			color.Green("%s is unlocked", path)
+			return nil
+		},
+	}
+
+	cmd.Flags().Duration("ttl", 0, "forget the key once unused for this long (default: the agent's)")
+	return cmd
+}
+
+// LockTomlCommand returns lock command
+func LockTomlCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "lock [file]",
+		Short: "Make the agent forget the key of the cmdb, or every key",
+		Long: `
+Make the agent forget the key of the cmdb, or of the given file. The keys of
+other files stay unlocked. With --all every key is forgotten.
+
+e.g.
+cm lock
+cm lock team.toml
+cm lock --all
+`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			all, err := cmd.Flags().GetBool("all")
+			if err != nil {
+				return err
+			}
+			if all && len(args) > 0 {
+				return errors.New("give a file or --all, not both")
+			}
+			encrypt.RemoveLegacyPasswordFile()
+
+			file := path
+			if len(args) > 0 {
+				file = args[0]
+			}
+			if all {
+				err = agent.Lock()
+			} else {
+				err = toml.ForgetKey(file)
+			}
+			if err != nil {
+				if errors.Is(err, agent.ErrNotRunning) {
+					color.Yellow("The agent is not running, no key is kept")
+					return nil
+				}
+				return err
+			}
+			if all {
+				color.Green("Locked")
+			} else {
+				color.Green("%s is locked", file)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().Bool("all", false, "forget the keys of every file")
+	return cmd
+}
+
+// ensureAgent starts the agent in the background unless it is running.
+func ensureAgent() error {
+	if _, err := agent.Status(); err == nil {
+		return nil
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	proc := exec.Command(exe, "agent")
+	if err := proc.Start(); err != nil {
+		return fmt.Errorf("failed to start agent: %w", err)
+	}
+	proc.Process.Release()
+
+	for i := 0; i < 40; i++ {
+		time.Sleep(50 * time.Millisecond)
+		if _, err := agent.Status(); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("agent did not start, run \"cm agent\" to see why")
+}
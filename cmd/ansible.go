@@ -0,0 +1,274 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/MinseokOh/toml-cli/ansible"
+	"github.com/MinseokOh/toml-cli/toml"
+	"github.com/spf13/cobra"
+)
+
+// namespacesGroup is the parent of the namespace groups of an exported
+// inventory, so that an import tells them from tags.
+const namespacesGroup = "cm_namespaces"
+
+// ExportTomlCommand returns export command
+func ExportTomlCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export the cmdb for other tools",
+	}
+	cmd.AddCommand(exportAnsibleCommand())
+	return cmd
+}
+
+func exportAnsibleCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "ansible [query]",
+		Short: "Generate an Ansible inventory from the host entries",
+		Long: `
+Generate an Ansible inventory from the *:host:* entries, or those the query
+selects. Every host is in a group named after its namespace, one named after
+its environment and one per tag. The namespace groups are the children of
+the cm_namespaces group. Host names are the last part of the key,
+prefixed with the namespace when several namespaces use the same name.
+
+Host vars: ansible_host, ansible_user, ansible_port,
+ansible_ssh_private_key_file and the entry's ansible_vars table.
+
+e.g.
+cm export ansible > inventory.ini
+cm export ansible -f yaml -o inventory.yml
+cm export ansible 'prod:host:*'
+`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			format, err := cmd.Flags().GetString(flagFormat)
+			if err != nil {
+				return err
+			}
+			out, err := cmd.Flags().GetString(flagOut)
+			if err != nil {
+				return err
+			}
+			query := "*:host:*"
+			if len(args) > 0 {
+				query = args[0]
+			}
+
+			tomlFile, err := toml.NewToml(path)
+			if err != nil {
+				return err
+			}
+			defer tomlFile.Close()
+			results, err := tomlFile.Query(query)
+			if err != nil {
+				return err
+			}
+			inv, err := ansibleInventory(results)
+			if err != nil {
+				return err
+			}
+			data, err := inv.Marshal(format)
+			if err != nil {
+				return err
+			}
+			if out != "" {
+				return os.WriteFile(out, data, 0600)
+			}
+			_, err = os.Stdout.Write(data)
+			return err
+		},
+	}
+
+	cmd.Flags().StringP(flagFormat, "f", ansible.FormatIni, "inventory format: ini or yaml")
+	cmd.Flags().StringP(flagOut, "o", "", "write to file instead of stdout")
+	return cmd
+}
+
+// ansibleInventory builds an inventory of the host entries among results.
+func ansibleInventory(results []toml.Result) (*ansible.Inventory, error) {
+	type entry struct {
+		namespace, alias, key string
+		host                  *SSHHost
+	}
+	var entries []entry
+	aliases := make(map[string]int)
+	for _, r := range results {
+		keys, ok := r.Path.Keys()
+		if !ok || len(keys) != 1 {
+			continue
+		}
+		parts := strings.SplitN(keys[0], ":", 3)
+		if len(parts) != 3 || parts[1] != "host" {
+			continue
+		}
+		host, err := hostFromData(keys[0], r.Value)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry{parts[0], parts[2], keys[0], host})
+		aliases[parts[2]]++
+	}
+
+	inv := &ansible.Inventory{}
+	for _, e := range entries {
+		name := e.alias
+		if aliases[e.alias] > 1 {
+			name = e.namespace + "_" + e.alias
+		}
+		vars := inv.Host(name).Vars
+		for k, v := range e.host.AnsibleVars {
+			vars[k] = v
+		}
+		vars["ansible_host"] = e.host.Hostname
+		vars["ansible_user"] = e.host.User
+		vars["ansible_port"] = int64(e.host.Port)
+		if e.host.KeyPath != "" {
+			vars["ansible_ssh_private_key_file"] = e.host.KeyPath
+		} else if e.host.PrivateKey != "" {
+			// Where "cm ssh key generate" keeps it, as in "cm ssh config".
+			vars["ansible_ssh_private_key_file"] = "~/.ssh/cm_" + e.key
+		}
+
+		inv.AddHost(ansible.GroupName(e.namespace), name)
+		inv.AddChild(namespacesGroup, ansible.GroupName(e.namespace))
+		if e.host.Environment != "" {
+			inv.AddHost(ansible.GroupName(e.host.Environment), name)
+		}
+		for _, tag := range strings.Split(e.host.Tags, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				inv.AddHost(ansible.GroupName(tag), name)
+			}
+		}
+	}
+	return inv, nil
+}
+
+func importAnsibleCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "ansible <inventory> <namespace>",
+		Short: "Import the hosts of an Ansible inventory",
+		Long: `
+Import the hosts of an INI or YAML Ansible inventory as namespace:host:name
+entries. Group variables apply as they do in Ansible.
+
+ansible_host, ansible_user, ansible_port and ansible_ssh_private_key_file
+become host attributes and other variables are kept in the ansible_vars table.
+The groups of a host become its environment when named like one (see
+--environments) and its tags otherwise; the group named after the namespace
+and the namespace groups of "cm export ansible" are left out.
+
+e.g.
+cm import ansible inventory.ini ns
+cm import ansible inventory.yml ns --on-conflict overwrite --dry-run
+`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			format, err := cmd.Flags().GetString(flagFormat)
+			if err != nil {
+				return err
+			}
+			environments, err := cmd.Flags().GetStringSlice("environments")
+			if err != nil {
+				return err
+			}
+			data, err := os.ReadFile(args[0])
+			if err != nil {
+				return err
+			}
+			inv, err := ansible.Parse(data, format)
+			if err != nil {
+				return fmt.Errorf("%s: %w", args[0], err)
+			}
+
+			namespace := strings.TrimSuffix(args[1], ":")
+			// Namespace groups are no tags, neither the one of namespace nor
+			// those "cm export ansible" wrote
+			nsGroups := []string{namespacesGroup, ansible.GroupName(namespace)}
+			for _, g := range inv.Groups {
+				if g.Name == namespacesGroup {
+					nsGroups = append(nsGroups, g.Children...)
+				}
+			}
+			var aliases []string
+			hosts := make(map[string]SSHHost)
+			for _, h := range inv.Hosts {
+				host, err := hostFromAnsible(h.Name, inv.HostVars(h.Name), inv.HostGroups(h.Name), nsGroups, environments)
+				if err != nil {
+					return fmt.Errorf("%s: host %s: %w", args[0], h.Name, err)
+				}
+				aliases = append(aliases, h.Name)
+				hosts[h.Name] = host
+			}
+			return importHosts(cmd, namespace, aliases, hosts, args[0])
+		},
+	}
+
+	cmd.Flags().StringP(flagFormat, "f", "", "inventory format: ini or yaml (default: detected)")
+	cmd.Flags().StringSlice("environments", []string{"dev", "staging", "prod"}, "group names that are environments")
+	addHostImportFlags(cmd)
+	return cmd
+}
+
+// hostFromAnsible maps the variables and groups of an inventory host onto an
+// SSHHost.
+func hostFromAnsible(name string, vars map[string]interface{}, groups, nsGroups, environments []string) (SSHHost, error) {
+	host := SSHHost{Hostname: name, User: "root", Port: 22}
+	for k, v := range vars {
+		s := fmt.Sprint(v)
+		switch k {
+		case "ansible_host", "ansible_ssh_host":
+			host.Hostname = s
+		case "ansible_user", "ansible_ssh_user":
+			host.User = s
+		case "ansible_port", "ansible_ssh_port":
+			port, err := strconv.Atoi(s)
+			if err != nil {
+				return host, fmt.Errorf("invalid %s %q", k, s)
+			}
+			host.Port = port
+		case "ansible_ssh_private_key_file", "ansible_private_key_file":
+			host.KeyPath = s
+		default:
+			if host.AnsibleVars == nil {
+				host.AnsibleVars = make(map[string]interface{})
+			}
+			host.AnsibleVars[k] = v
+		}
+	}
+
+	var tags []string
+	for _, g := range groups {
+		switch {
+		case contains(nsGroups, g):
+		case host.Environment == "" && containsFold(environments, g):
+			host.Environment = g
+		default:
+			tags = append(tags, g)
+		}
+	}
+	host.Tags = strings.Join(tags, ",")
+	return host, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(list []string, s string) bool {
+	for _, e := range list {
+		if strings.EqualFold(e, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/MinseokOh/toml-cli/toml"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImportAnsibleFQDN(t *testing.T) {
+	cmdb := useCmdb(t, "")
+	inventory := filepath.Join(t.TempDir(), "hosts.ini")
+	require.Nil(t, os.WriteFile(inventory, []byte("[web]\nweb1.example.com ansible_user=deploy\n"), 0600))
+
+	cmd := importAnsibleCommand()
+	cmd.SetArgs([]string{inventory, "ns"})
+	require.Nil(t, cmd.Execute())
+
+	// cm ssh add stores hosts the same way.
+	require.Nil(t, saveHostToCMDB("ns:host:web2.example.com", SSHHost{Hostname: "web2.example.com", User: "root", Port: 22}))
+
+	data, err := os.ReadFile(cmdb)
+	require.Nil(t, err)
+	require.Contains(t, string(data), `["ns:host:web1.example.com"]`)
+	require.Contains(t, string(data), `["ns:host:web2.example.com"]`)
+
+	tomlFile, err := toml.NewToml(cmdb)
+	require.Nil(t, err)
+	defer tomlFile.Close()
+	require.ElementsMatch(t, []string{"ns:host:web1.example.com", "ns:host:web2.example.com"}, tomlFile.Keys())
+	host, err := getHostFromCMDB("ns:host:web1.example.com", tomlFile)
+	require.Nil(t, err)
+	require.Equal(t, "deploy", host.User)
+}
+
+func TestAnsibleRoundTrip(t *testing.T) {
+	useCmdb(t, `["a:host:web"]
+hostname = "10.0.0.1"
+tags = ["web", "db"]
+
+["b:host:db"]
+hostname = "10.0.0.2"
+tags = "db"
+`)
+	inventory := filepath.Join(t.TempDir(), "hosts.ini")
+	cmd := exportAnsibleCommand()
+	cmd.SetArgs([]string{"-o", inventory})
+	require.Nil(t, cmd.Execute())
+
+	inv, err := os.ReadFile(inventory)
+	require.Nil(t, err)
+	require.Contains(t, string(inv), "[web]\nweb\n")
+	require.Contains(t, string(inv), "[db]\nweb\ndb\n")
+
+	cmdb := useCmdb(t, "")
+	cmd = importAnsibleCommand()
+	cmd.SetArgs([]string{inventory, "a"})
+	require.Nil(t, cmd.Execute())
+
+	tomlFile, err := toml.NewToml(cmdb)
+	require.Nil(t, err)
+	defer tomlFile.Close()
+	host, err := getHostFromCMDB("a:host:web", tomlFile)
+	require.Nil(t, err)
+	require.Equal(t, "db,web", host.Tags)
+	// b was a namespace, not a tag
+	host, err = getHostFromCMDB("a:host:db", tomlFile)
+	require.Nil(t, err)
+	require.Equal(t, "db", host.Tags)
+}
@@ -0,0 +1,164 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/MinseokOh/toml-cli/toml"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+// AuditTomlCommand returns audit command
+func AuditTomlCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Show who changed the cmdb",
+		Long: `
+Every command that changes the cmdb appends a record to .<name>.audit.jsonl next
+to it: the time, the OS user, the command line and the key paths it changed.
+Values are never logged; old and new values appear as HMACs keyed with a
+random secret kept in .<name>.audit.key, so equal hashes mean equal values but
+values cannot be guessed from the log alone. Arguments that carry a value are masked in the
+command line. Showing a private_key in plaintext (--plain, or a query that
+returns one) is logged as a read.
+
+e.g.
+cm audit
+cm audit --key 'prod:host:*'
+cm audit --since 2024-05-01 --until 2024-06-01 --user alice
+cm audit --since 24h -f json
+
+--key matches the key itself and everything below it, and accepts * and ?.
+--since and --until take a date, an RFC 3339 time or a duration back from now.
+`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key, _ := cmd.Flags().GetString("key")
+			user, _ := cmd.Flags().GetString("user")
+			format, err := cmd.Flags().GetString(flagFormat)
+			if err != nil {
+				return err
+			}
+			var since, until time.Time
+			for name, dst := range map[string]*time.Time{"since": &since, "until": &until} {
+				s, _ := cmd.Flags().GetString(name)
+				if s == "" {
+					continue
+				}
+				if *dst, err = parseAuditTime(s); err != nil {
+					return fmt.Errorf("--%s: %w", name, err)
+				}
+			}
+
+			records, err := toml.ReadAudit(path)
+			if err != nil {
+				return err
+			}
+			var matched []toml.AuditRecord
+			for _, rec := range records {
+				if user != "" && rec.User != user {
+					continue
+				}
+				if !since.IsZero() && rec.Time.Before(since) {
+					continue
+				}
+				if !until.IsZero() && !rec.Time.Before(until) {
+					continue
+				}
+				if key != "" {
+					if rec.Keys = matchAuditKeys(rec.Keys, key); len(rec.Keys) == 0 {
+						continue
+					}
+				}
+				matched = append(matched, rec)
+			}
+			return printAudit(matched, format)
+		},
+	}
+
+	cmd.Flags().String("key", "", "only records touching this key path")
+	cmd.Flags().String("since", "", "only records at or after this time")
+	cmd.Flags().String("until", "", "only records before this time")
+	cmd.Flags().String("user", "", "only records of this user")
+	cmd.Flags().StringP(flagFormat, "f", formatHuman, "output format: human or json")
+	return cmd
+}
+
+func parseAuditTime(s string) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("%q is not a date, time or duration", s)
+}
+
+// matchAuditKeys keeps the keys at or below the path pattern.
+func matchAuditKeys(keys []toml.AuditKey, pattern string) []toml.AuditKey {
+	var res []toml.AuditKey
+	for _, k := range keys {
+		if k.Matches(pattern) {
+			res = append(res, k)
+		}
+	}
+	return res
+}
+
+func printAudit(records []toml.AuditRecord, format string) error {
+	switch format {
+	case formatHuman:
+		for _, rec := range records {
+			color.New(color.Bold).Printf("%s  %s  %s\n", rec.Time.Local().Format("2006-01-02 15:04:05"), rec.User, rec.Command)
+			for _, k := range rec.Keys {
+				switch k.Op {
+				case toml.AuditRead:
+					color.Cyan("    read    %s", k.Path)
+				default:
+					fmt.Printf("    %-7s %s  %s -> %s\n", k.Op, k.Path, shortHash(k.OldHash), shortHash(k.NewHash))
+				}
+			}
+		}
+		return nil
+	case toml.FormatJson:
+		if records == nil {
+			records = []toml.AuditRecord{}
+		}
+		data, err := json.MarshalIndent(records, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+	return fmt.Errorf("unknown format %q, expected human or json", format)
+}
+
+func shortHash(h string) string {
+	if h == "" {
+		return "-"
+	}
+	// Drop the algorithm, sha256: or hmac-sha256:
+	if i := strings.Index(h, ":"); i >= 0 {
+		h = h[i+1:]
+	}
+	if len(h) > 12 {
+		h = h[:12]
+	}
+	return h
+}
+
+// logSensitiveReads records in the audit log that sensitive values in results
+// are about to be shown in plaintext.
+func logSensitiveReads(results []toml.Result) error {
+	if err := toml.LogRead(path, toml.SensitivePaths(results)); err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	return nil
+}
@@ -10,12 +10,18 @@ func ClearTomlCommand() *cobra.Command {
 		Use:     "clear key",
 		Aliases: []string{"clr"},
 		Short:   "Remove key",
-		Args:    cobra.ExactArgs(1),
+		Long: `
+e.g.
+cm clear ns:host:web
+cm clear servers[2]
+`,
+		Args: cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			toml, err := toml.NewToml(path)
 			if err != nil {
 				return err
 			}
+			defer toml.Close()
 			if err := toml.Clear(args[0]); err != nil {
 				return err
 			}
@@ -5,21 +5,22 @@ import (
 	"os"
 
 	"github.com/MinseokOh/toml-cli/encrypt"
+	"github.com/MinseokOh/toml-cli/toml"
 	"github.com/spf13/cobra"
 )
 
 // decryptCmd represents the decrypt command
-var decryptPassword string
-
 var decryptCmd = &cobra.Command{
 	Use:   "decrypt [file]",
 	Short: "Decrypt an encrypted TOML file",
 	Long: `Decrypt an encrypted TOML file using the provided password.
-This will convert the file back to plain TOML format.
+This will convert the file back to plain TOML format, whether the whole file
+or only its secret fields are encrypted. Files encrypted to recipients are
+decrypted with your identity.
 
 Example:
   cm decrypt config.toml
-  cm decrypt --password mypassword config.toml`,
+  cm decrypt --password-command 'pass show cmdb' config.toml`,
 	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		filePath := args[0]
@@ -30,6 +31,14 @@ Example:
 			os.Exit(1)
 		}
 
+		// Nothing may write the file until it is replaced
+		unlock, err := toml.LockFile(filePath)
+		if err != nil {
+			fmt.Printf("Error locking file: %v\n", err)
+			os.Exit(1)
+		}
+		defer unlock()
+
 		// Read the file
 		data, err := os.ReadFile(filePath)
 		if err != nil {
@@ -38,33 +47,41 @@ Example:
 		}
 
 		// Check if file is encrypted
-		if !encrypt.IsEncrypted(data) {
+		fieldMode := toml.IsFieldEncrypted(data)
+		if !encrypt.IsEncrypted(data) && !fieldMode {
 			fmt.Println("File is not encrypted")
 			os.Exit(1)
 		}
 
-		// Get password from flag or prompt
+		// Get password from $CMDB_PASSWORD, --password-file, --password-command or prompt
 		var password string
-		if decryptPassword != "" {
-			password = decryptPassword
-		} else {
-			var promptErr error
-			password, promptErr = encrypt.PromptPassword(false)
-			if promptErr != nil {
-				fmt.Printf("Error getting password: %v\n", promptErr)
+		if !encrypt.IsRecipientEncrypted(data) {
+			password, err = encrypt.ReadPassword(false)
+			if err != nil {
+				fmt.Printf("Error getting password: %v\n", err)
 				os.Exit(1)
 			}
 		}
 
 		// Decrypt the file content
-		decryptedContent, err := encrypt.Decrypt(string(data), password)
+		var decryptedContent []byte
+		if fieldMode {
+			decryptedContent, err = toml.DecryptFields(data, password)
+		} else if encrypt.IsRecipientEncrypted(data) {
+			var id *encrypt.Identity
+			if id, err = encrypt.LoadIdentity(); err == nil {
+				decryptedContent, err = encrypt.DecryptWith(string(data), id)
+			}
+		} else {
+			decryptedContent, err = encrypt.Decrypt(string(data), password)
+		}
 		if err != nil {
 			fmt.Printf("Error decrypting file: %v\n", err)
 			os.Exit(1)
 		}
 
 		// Write decrypted content back to file
-		err = os.WriteFile(filePath, decryptedContent, 0644)
+		err = toml.WriteFileAtomic(filePath, decryptedContent)
 		if err != nil {
 			fmt.Printf("Error writing decrypted file: %v\n", err)
 			os.Exit(1)
@@ -78,8 +95,3 @@ Example:
 func GetDecryptCommand() *cobra.Command {
 	return decryptCmd
 }
-
-func init() {
-	decryptCmd.Flags().StringVarP(&decryptPassword, "password", "p", "", "Password for decryption")
-}
-
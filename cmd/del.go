@@ -12,6 +12,12 @@ func DeleteTomlCommand() *cobra.Command {
 		Use:     "del key attr",
 		Aliases: []string{"d"},
 		Short:   "Delete a key's attr",
+		Long: `
+e.g.
+cm del ns:host:web password
+cm del ns:host:web ssh.options.port
+cm del servers [2]
+`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) < 2 {
 				return fmt.Errorf("参数最少有两个")
@@ -20,11 +26,15 @@ func DeleteTomlCommand() *cobra.Command {
 			if err != nil {
 				return err
 			}
+			defer toml.Close()
 			for _, attr := range args[1:] {
 				if err := toml.Delete(args[0], attr); err != nil {
 					return err
 				}
 			}
+			if err := checkSchema(&toml); err != nil {
+				return err
+			}
 			if err := toml.Write(); err != nil {
 				return err
 			}
@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/MinseokOh/toml-cli/toml"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+const (
+	formatHuman = "human"
+	formatPatch = "patch"
+)
+
+// DiffTomlCommand returns diff command
+func DiffTomlCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "diff <old> <new>",
+		Short: "Compare two TOML files or two entries",
+		Long: `
+Compare two TOML files, or with --key two entries of the cmdb, by value rather
+than line by line: formatting, comments and key order are ignored. Added, removed
+and changed paths are listed with their old and new values; a value whose type
+changed (the string "22" and the integer 22) is marked as such.
+
+Encrypted files are decrypted as usual.
+
+e.g.
+cm diff cmdb.toml cmdb.new.toml
+cm diff --key ns:host:web ns:host:web2
+cm diff old.toml new.toml -f json
+cm diff old.toml new.toml -f patch > changes.json
+
+Formats:
+  human   colored listing (default)
+  json    list of {"op", "path", "old", "new"}
+  patch   RFC 6902 JSON Patch, see "cm patch"
+`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			format, err := cmd.Flags().GetString(flagFormat)
+			if err != nil {
+				return err
+			}
+			byKey, err := cmd.Flags().GetBool("key")
+			if err != nil {
+				return err
+			}
+
+			var changes []toml.Change
+			if byKey {
+				tomlFile, err := toml.NewToml(path)
+				if err != nil {
+					return err
+				}
+				defer tomlFile.Close()
+				values := make([]interface{}, 2)
+				for i, k := range args {
+					if values[i] = tomlFile.Get(k); values[i] == nil {
+						return fmt.Errorf("Key %v does not exist in %v", k, path)
+					}
+				}
+				changes = toml.Diff(values[0], values[1])
+			} else {
+				var files [2]toml.Toml
+				for i, p := range args {
+					if files[i], err = toml.NewToml(p); err != nil {
+						return fmt.Errorf("failed to load %s: %v", p, err)
+					}
+					defer files[i].Close()
+				}
+				changes = files[0].Diff(&files[1])
+			}
+
+			return printChanges(changes, format)
+		},
+	}
+
+	cmd.Flags().Bool("key", false, "compare two entries of the cmdb instead of two files")
+	cmd.Flags().StringP(flagFormat, "f", formatHuman, "output format: human, json or patch")
+	return cmd
+}
+
+func printChanges(changes []toml.Change, format string) error {
+	switch format {
+	case formatHuman:
+		add := color.New(color.FgGreen)
+		remove := color.New(color.FgRed)
+		replace := color.New(color.FgYellow)
+		for _, c := range changes {
+			p := c.Path.String()
+			if p == "" {
+				p = "."
+			}
+			switch c.Kind {
+			case toml.ChangeAdd:
+				add.Printf("+ %s = %s\n", p, toml.FormatValue(c.New))
+			case toml.ChangeRemove:
+				remove.Printf("- %s = %s\n", p, toml.FormatValue(c.Old))
+			default:
+				if c.TypeChanged() {
+					replace.Printf("~ %s: %s (%s) -> %s (%s)\n", p,
+						toml.FormatValue(c.Old), toml.TypeOf(c.Old), toml.FormatValue(c.New), toml.TypeOf(c.New))
+				} else {
+					replace.Printf("~ %s: %s -> %s\n", p, toml.FormatValue(c.Old), toml.FormatValue(c.New))
+				}
+			}
+		}
+		return nil
+	case toml.FormatJson:
+		if changes == nil {
+			changes = []toml.Change{}
+		}
+		data, err := json.MarshalIndent(changes, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	case formatPatch:
+		data, err := toml.MarshalPatch(changes)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+	return fmt.Errorf("unknown format %q, expected human, json or patch", format)
+}
@@ -1,37 +1,108 @@
 package cmd
 
 import (
-	"log"
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
 
 	"github.com/MinseokOh/toml-cli/toml"
 	"github.com/spf13/cobra"
 )
 
+// DumpTomlCommand returns dump command
 func DumpTomlCommand() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "dump <json | toml>",
-		Short: "Convert to json or toml string",
+		Use:   "dump [format] [key|query]",
+		Short: "Export the cmdb, a key or a query result",
+		Long: `
+Export the whole cmdb, a single key or the results of a query.
+
+e.g.
+cm dump json
+cm dump yaml ns:host:web
+cm dump json '*:host:* | select(.environment=="prod")'
+cm dump raw '*:host:*.hostname'
+cm dump dotenv ns:app -o .env
+cm dump csv '*:host:*' --columns hostname,ssh.port
+
+Formats:
+  toml        TOML (default)
+  json        a JSON document per result
+  raw         plain values, one per line
+  yaml        YAML
+  dotenv      KEY="value" lines, e.g. ns:app.db.port becomes NS_APP_DB_PORT
+  properties  Java properties keyed by path, e.g. ns\:app.db.port=5432
+  ini         a section per table
+  csv         a row per table; the first column is its key and the others
+              are its attributes, or the --columns given
+
+See "cm get --help" for the query syntax.
+`,
+		Args: cobra.MaximumNArgs(2),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			toml, err := toml.NewToml(path)
+			format := toml.FormatToml
+			if len(args) > 0 {
+				format = args[0]
+			}
+			out, err := cmd.Flags().GetString(flagOut)
+			if err != nil {
+				return err
+			}
+			columns, err := cmd.Flags().GetStringSlice("columns")
 			if err != nil {
 				return err
 			}
-			var r string
-			if len(args) == 0 {
-				r, err = toml.ToToml()
-			} else {
-				if args[0] == "json" {
-					r, err = toml.ToJson()
-				} else {
-					r, err = toml.ToToml()
+
+			tomlFile, err := toml.NewToml(path)
+			if err != nil {
+				return err
+			}
+			defer tomlFile.Close()
+
+			var results []toml.Result
+			switch {
+			case len(args) < 2:
+				results, err = tomlFile.Query(".")
+			case toml.IsQuery(args[1]):
+				results, err = tomlFile.Query(args[1])
+			default:
+				results = tomlFile.Lookup(args[1])
+				if len(results) == 0 {
+					err = fmt.Errorf("Key %v does not exist in %v", args[1], path)
 				}
 			}
 			if err != nil {
-				log.Fatal(err)
+				return err
 			}
-			log.Println(string(r))
-			return nil
+			if results, err = tomlFile.RevealResults(results); err != nil {
+				return err
+			}
+
+			var b bytes.Buffer
+			if err := toml.Export(&b, results, format, toml.ExportOptions{Columns: columns}); err != nil {
+				return err
+			}
+			if err := logSensitiveReads(results); err != nil {
+				return err
+			}
+			if out != "" {
+				if err := toml.CheckPlaintext(out, &tomlFile); err != nil {
+					return err
+				}
+				return os.WriteFile(out, b.Bytes(), 0600)
+			}
+			_, err = os.Stdout.Write(b.Bytes())
+			return err
 		},
 	}
+
+	cmd.Flags().StringP(flagOut, "o", "", "write to file instead of stdout")
+	cmd.Flags().StringSlice("columns", nil, "attributes written by the csv format, e.g. hostname,ssh.port")
 	return cmd
 }
+
+// exportFormats lists the formats of "cm dump" for flag descriptions.
+func exportFormats() string {
+	return strings.Join(toml.ExportFormats(), ", ")
+}
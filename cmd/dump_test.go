@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/MinseokOh/toml-cli/toml"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDumpEncryptedNeedsConfirmation(t *testing.T) {
+	cmdb := encryptedSample(t, "cmdb.toml", "[web]\npassword = \"s3cret\"\n")
+	out := filepath.Join(t.TempDir(), "cmdb.json")
+
+	rootCmd.SetArgs([]string{"dump", "json", "-c", cmdb, "--no-prompt", "-o", out})
+	require.ErrorIs(t, rootCmd.Execute(), toml.ErrPlaintext)
+	_, err := os.Stat(out)
+	require.True(t, os.IsNotExist(err))
+
+	rootCmd.SetArgs([]string{"dump", "json", "-c", cmdb, "--no-prompt", "--plain", "-o", out})
+	require.Nil(t, rootCmd.Execute())
+	data, err := os.ReadFile(out)
+	require.Nil(t, err)
+	require.Contains(t, string(data), "s3cret")
+	plain = false
+}
+
+func TestDumpOverEncryptedFile(t *testing.T) {
+	cmdb := useCmdb(t, "[web]\nport = 22\n")
+	out := encryptedSample(t, "secrets.toml", "[db]\npassword = \"s3cret\"\n")
+
+	rootCmd.SetArgs([]string{"dump", "-c", cmdb, "--no-prompt", "-o", out})
+	require.ErrorIs(t, rootCmd.Execute(), toml.ErrPlaintext)
+}
@@ -5,11 +5,15 @@ import (
 	"os"
 
 	"github.com/MinseokOh/toml-cli/encrypt"
+	"github.com/MinseokOh/toml-cli/toml"
 	"github.com/spf13/cobra"
 )
 
 // encryptCmd represents the encrypt command
-var encryptPassword string
+var encryptFields bool
+var encryptKeys []string
+var encryptKDF string
+var encryptUpgrade bool
 
 var encryptCmd = &cobra.Command{
 	Use:   "encrypt [file]",
@@ -17,9 +21,20 @@ var encryptCmd = &cobra.Command{
 	Long: `Encrypt a TOML file using AES-256-GCM encryption.
 The encrypted file can only be accessed with the correct password.
 
+With --fields only the values of secret attributes are encrypted, each on its
+own, and the rest of the file stays readable. --keys sets which attributes
+are secret: names or paths, globs allowed (default: password, private_key).
+Encrypting a file in the other mode converts it, keeping its password.
+
+The key is derived from the password with --kdf: argon2id (default), scrypt
+or pbkdf2. --upgrade re-encrypts an encrypted file in the current format with
+the same password, or to the same recipients, and a key derived with --kdf.
+
 Example:
   cm encrypt config.toml
-  cm encrypt --password mypassword config.toml`,
+  CMDB_PASSWORD=... cm encrypt config.toml
+  cm encrypt --fields --keys password,private_key,'*_token' config.toml
+  cm encrypt --upgrade --kdf scrypt config.toml`,
 	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		filePath := args[0]
@@ -30,6 +45,14 @@ Example:
 			os.Exit(1)
 		}
 
+		// Nothing may write the file until it is replaced
+		unlock, err := toml.LockFile(filePath)
+		if err != nil {
+			fmt.Printf("Error locking file: %v\n", err)
+			os.Exit(1)
+		}
+		defer unlock()
+
 		// Check if file is already encrypted
 		data, err := os.ReadFile(filePath)
 		if err != nil {
@@ -37,53 +60,106 @@ Example:
 			os.Exit(1)
 		}
 
-		if encrypt.IsEncrypted(data) {
+		if encryptUpgrade {
+			if err := upgradeFile(filePath, data); err != nil {
+				fmt.Printf("Error upgrading file: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("File '%s' has been upgraded successfully\n", filePath)
+			return
+		}
+
+		fieldMode := toml.IsFieldEncrypted(data)
+		if (encrypt.IsEncrypted(data) && !encryptFields) || (fieldMode && encryptFields) {
 			fmt.Println("File is already encrypted")
 			os.Exit(1)
 		}
+		// Converting between modes keeps the password of the file
+		converting := encrypt.IsEncrypted(data) || fieldMode
 
-		// Get password from flag or prompt
-		var password string
-		if encryptPassword != "" {
-			password = encryptPassword
-		} else {
-			var promptErr error
-			password, promptErr = encrypt.PromptPassword(true)
-			if promptErr != nil {
-				fmt.Printf("Error getting password: %v\n", promptErr)
-				os.Exit(1)
-			}
+		// Get password from $CMDB_PASSWORD, --password-file, --password-command or prompt
+		password, err := encrypt.ReadPassword(!converting)
+		if err != nil {
+			fmt.Printf("Error getting password: %v\n", err)
+			os.Exit(1)
 		}
 
 		// Encrypt the file content
-		encryptedContent, err := encrypt.Encrypt(data, password)
+		var encryptedContent string
+		switch {
+		case encrypt.IsEncrypted(data):
+			var plain, fields []byte
+			plain, err = encrypt.Decrypt(string(data), password)
+			if err == nil {
+				fields, err = toml.EncryptFields(plain, password, encryptKDF, encryptKeys)
+				encryptedContent = string(fields)
+			}
+		case fieldMode:
+			var plain []byte
+			plain, err = toml.DecryptFields(data, password)
+			if err == nil {
+				encryptedContent, err = encrypt.EncryptKDF(plain, password, encryptKDF)
+			}
+		case encryptFields:
+			var fields []byte
+			fields, err = toml.EncryptFields(data, password, encryptKDF, encryptKeys)
+			encryptedContent = string(fields)
+		default:
+			encryptedContent, err = encrypt.EncryptKDF(data, password, encryptKDF)
+		}
 		if err != nil {
 			fmt.Printf("Error encrypting file: %v\n", err)
 			os.Exit(1)
 		}
 
-		// Save password for future use
-		if err := encrypt.SavePassword(password); err != nil {
-			fmt.Printf("Warning: failed to save password: %v\n", err)
-		}
-
 		// Write encrypted content back to file
-		err = os.WriteFile(filePath, []byte(encryptedContent), 0644)
+		err = toml.WriteFileAtomic(filePath, []byte(encryptedContent))
 		if err != nil {
 			fmt.Printf("Error writing encrypted file: %v\n", err)
 			os.Exit(1)
 		}
+		// A file encrypted anew is remembered as the one at this path; files in
+		// field mode have no binding
+		if encrypt.IsEncrypted([]byte(encryptedContent)) {
+			if err := toml.RememberFile(filePath, []byte(encryptedContent)); err != nil {
+				fmt.Printf("Error remembering encrypted file: %v\n", err)
+				os.Exit(1)
+			}
+		}
 
 		fmt.Printf("File '%s' has been encrypted successfully\n", filePath)
 	},
 }
 
+// upgradeFile re-encrypts an encrypted file in the current format.
+func upgradeFile(filePath string, data []byte) error {
+	if encrypt.IsRecipientEncrypted(data) {
+		// Written to the same recipients in the current format
+		tomlFile, err := toml.NewToml(filePath)
+		if err != nil {
+			return err
+		}
+		defer tomlFile.Close()
+		return tomlFile.Write()
+	}
+	if !encrypt.IsEncrypted(data) && !toml.IsFieldEncrypted(data) {
+		return fmt.Errorf("file is not encrypted")
+	}
+	password, err := encrypt.ReadPassword(false)
+	if err != nil {
+		return err
+	}
+	return toml.Rekey(filePath, password, password, encryptKDF, true)
+}
+
 // GetEncryptCommand returns the encrypt command
 func GetEncryptCommand() *cobra.Command {
 	return encryptCmd
 }
 
 func init() {
-	encryptCmd.Flags().StringVarP(&encryptPassword, "password", "p", "", "Password for encryption")
+	encryptCmd.Flags().BoolVar(&encryptFields, "fields", false, "Encrypt only the values of secret attributes")
+	encryptCmd.Flags().StringSliceVar(&encryptKeys, "keys", nil, "Secret attribute names or paths for --fields (default: password, private_key)")
+	encryptCmd.Flags().StringVar(&encryptKDF, "kdf", encrypt.DefaultKDF, "Key derivation function: argon2id, scrypt or pbkdf2")
+	encryptCmd.Flags().BoolVar(&encryptUpgrade, "upgrade", false, "Re-encrypt an encrypted file in the current format")
 }
-
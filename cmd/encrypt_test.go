@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/MinseokOh/toml-cli/agent"
+	"github.com/MinseokOh/toml-cli/encrypt"
+	"github.com/MinseokOh/toml-cli/toml"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptDecryptKeepMode(t *testing.T) {
+	t.Setenv(agent.SocketEnv, filepath.Join(t.TempDir(), "agent.sock"))
+	t.Setenv(toml.SeenEnv, filepath.Join(t.TempDir(), "seen.json"))
+	t.Setenv(encrypt.PasswordEnv, "pw")
+	defer func() { encryptKDF = encrypt.DefaultKDF }()
+	file := filepath.Join(t.TempDir(), "cmdb.toml")
+	require.Nil(t, os.WriteFile(file, []byte("[web]\nport = 22\n"), 0600))
+
+	rootCmd.SetArgs([]string{"encrypt", "--kdf", encrypt.KDFPBKDF2, file})
+	require.Nil(t, rootCmd.Execute())
+	data, err := os.ReadFile(file)
+	require.Nil(t, err)
+	require.True(t, encrypt.IsEncrypted(data))
+
+	rootCmd.SetArgs([]string{"decrypt", file})
+	require.Nil(t, rootCmd.Execute())
+	data, err = os.ReadFile(file)
+	require.Nil(t, err)
+	require.Equal(t, "[web]\nport = 22\n", string(data))
+
+	info, err := os.Stat(file)
+	require.Nil(t, err)
+	if runtime.GOOS != "windows" {
+		require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+	entries, err := os.ReadDir(filepath.Dir(file))
+	require.Nil(t, err)
+	for _, e := range entries {
+		require.NotContains(t, e.Name(), ".tmp-")
+	}
+}
+
+func TestEncryptFields(t *testing.T) {
+	t.Setenv(agent.SocketEnv, filepath.Join(t.TempDir(), "agent.sock"))
+	t.Setenv(toml.SeenEnv, filepath.Join(t.TempDir(), "seen.json"))
+	t.Setenv(encrypt.PasswordEnv, "pw")
+	defer func() { encryptKDF, encryptFields = encrypt.DefaultKDF, false }()
+	file := filepath.Join(t.TempDir(), "cmdb.toml")
+	require.Nil(t, os.WriteFile(file, []byte("[web]\nport = 22\npassword = \"s3cret\"\n"), 0600))
+
+	rootCmd.SetArgs([]string{"encrypt", "--fields", "--kdf", encrypt.KDFPBKDF2, file})
+	require.Nil(t, rootCmd.Execute())
+	data, err := os.ReadFile(file)
+	require.Nil(t, err)
+	require.True(t, toml.IsFieldEncrypted(data))
+	require.NotContains(t, string(data), "s3cret")
+	require.Contains(t, string(data), "port = 22")
+
+	rootCmd.SetArgs([]string{"decrypt", file})
+	require.Nil(t, rootCmd.Execute())
+	data, err = os.ReadFile(file)
+	require.Nil(t, err)
+	require.Contains(t, string(data), `password = "s3cret"`)
+}
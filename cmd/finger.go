@@ -28,6 +28,7 @@ cm f title
 			if err != nil {
 				return err
 			}
+			defer toml.Close()
 
 			keys := toml.Keys()
 			sort.Strings(keys)
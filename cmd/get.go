@@ -7,6 +7,8 @@ import (
 	"github.com/spf13/cobra"
 )
 
+const flagFormat = "format"
+
 // GetTomlCommand returns get command
 func GetTomlCommand() *cobra.Command {
 	cmd := &cobra.Command{
@@ -16,24 +18,98 @@ func GetTomlCommand() *cobra.Command {
 		Long: `
 e.g.
 cm get title
+cm get ns:host:web.port
+cm get servers[2].ip
+cm get '"192.168.11.11".title'
+
+Queries select several values at once:
+cm get '*:host:* | select(.environment=="prod") | .hostname' -f raw
+cm get 'ns:db:*.port'
+cm get '..private_key' -f json
+cm get '*:host:* | {hostname, port}'
+
+Query syntax:
+  *:host:*            top-level keys matching a glob (* and ?)
+  .a.b  .a[0]         relative paths
+  .*  .a[*]           every child of a table or array
+  ..  ..port          recursive descent
+  select(cond)        keep values where cond holds; cond compares paths and
+                      literals with == != < <= > >= =~ and joins with and/or/not
+  {a, b: .x.y}        build a table from selected fields
+  stage | stage       feed the results of one stage into the next
+
+Private keys are masked unless --plain is set; "cm dump" exports them.
 `,
 		Args: cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			query := args[0]
+			format, err := cmd.Flags().GetString(flagFormat)
+			if err != nil {
+				return err
+			}
 
-			toml, err := toml.NewToml(path)
+			tomlFile, err := toml.NewToml(path)
 			if err != nil {
 				return err
 			}
-			res := toml.Get(query)
-			if res == nil {
+			defer tomlFile.Close()
+
+			if toml.IsQuery(query) {
+				results, err := tomlFile.Query(query)
+				if err != nil {
+					return err
+				}
+				if results, err = tomlFile.RevealResults(results); err != nil {
+					return err
+				}
+				return printResults(results, format)
+			}
+
+			results := tomlFile.Lookup(query)
+			if len(results) == 0 {
 				return fmt.Errorf("Key %v does not exist in %v", query, path)
 			}
+			if results, err = tomlFile.RevealResults(results); err != nil {
+				return err
+			}
+			if cmd.Flags().Changed(flagFormat) {
+				return printResults(results, format)
+			}
 
-			printAConfigure(query, res)
+			results, err = maskResults(results[:1])
+			if err != nil {
+				return err
+			}
+			printAConfigure(query, results[0].Value)
 			return nil
 		},
 	}
 
+	cmd.Flags().StringP(flagFormat, "f", toml.FormatToml, "output format of queries: "+exportFormats())
 	return cmd
 }
+
+// printResults writes query results to stdout without any decoration so the
+// output can be piped into other tools. Private keys are masked unless
+// --plain is set, as printAConfigure does.
+func printResults(results []toml.Result, format string) error {
+	results, err := maskResults(results)
+	if err != nil {
+		return err
+	}
+	out, err := toml.FormatResults(results, format)
+	if err != nil {
+		return err
+	}
+	fmt.Print(out)
+	return nil
+}
+
+// maskResults masks the private keys in results about to be shown, or with
+// --plain records in the audit log that they are shown.
+func maskResults(results []toml.Result) ([]toml.Result, error) {
+	if !plain {
+		return toml.MaskResults(results, secretMask), nil
+	}
+	return results, logSensitiveReads(results)
+}
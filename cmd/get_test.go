@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	"github.com/MinseokOh/toml-cli/toml"
+	"github.com/stretchr/testify/require"
+)
+
+// captureStdout returns what run prints to stdout.
+func captureStdout(t *testing.T, run func()) string {
+	r, w, err := os.Pipe()
+	require.Nil(t, err)
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	done := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(r)
+		done <- data
+	}()
+	run()
+	w.Close()
+	return string(<-done)
+}
+
+func TestGetMasksPrivateKey(t *testing.T) {
+	cmdb := useCmdb(t, "[\"ns:host:web\"]\nhostname = \"web\"\nprivate_key = \"SECRETKEY\"\n")
+
+	for _, args := range [][]string{
+		{"get", "ns:host:web.private_key"},
+		{"get", "ns:host:web"},
+		{"get", "*:host:*", "-f", "json"},
+	} {
+		out := captureStdout(t, func() {
+			rootCmd.SetArgs(args)
+			require.Nil(t, rootCmd.Execute())
+		})
+		require.NotContains(t, out, "SECRETKEY", args)
+		require.Contains(t, out, secretMask, args)
+	}
+	records, err := toml.ReadAudit(cmdb)
+	require.Nil(t, err)
+	require.Empty(t, records)
+
+	out := captureStdout(t, func() {
+		rootCmd.SetArgs([]string{"get", "ns:host:web.private_key", "--plain"})
+		require.Nil(t, rootCmd.Execute())
+	})
+	plain = false
+	require.Contains(t, out, "SECRETKEY")
+	records, err = toml.ReadAudit(cmdb)
+	require.Nil(t, err)
+	require.Len(t, records, 1)
+	require.Equal(t, toml.AuditRead, records[0].Action)
+}
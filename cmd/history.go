@@ -0,0 +1,190 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/MinseokOh/toml-cli/toml"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+// HistoryTomlCommand returns history command
+func HistoryTomlCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "history",
+		Short: "List and restore earlier versions of the cmdb",
+		Long: `
+Every command that changes the cmdb first keeps the content it replaces in
+.<name>.history/ next to the file. Versions are compressed, and stay encrypted
+when the cmdb is encrypted. Each version lists the command that replaced it.
+
+e.g.
+cm history
+cm history diff 12
+cm history diff before-migration 12
+cm history restore 12
+cm history tag before-migration
+cm undo
+`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			h, err := toml.OpenHistory(path)
+			if err != nil {
+				return err
+			}
+			if len(h.Versions) == 0 {
+				color.Yellow("No history for %s", path)
+				return nil
+			}
+			for _, v := range h.Versions {
+				tags := ""
+				if len(v.Tags) > 0 {
+					tags = color.CyanString(" (%s)", strings.Join(v.Tags, ", "))
+				}
+				fmt.Printf("%4d  %s%s  %s\n", v.ID, v.Time.Local().Format("2006-01-02 15:04:05"), tags, v.Command)
+			}
+			return nil
+		},
+	}
+
+	diff := &cobra.Command{
+		Use:   "diff <version> [version]",
+		Short: "Compare a version with the current cmdb, or with another version",
+		Args:  cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			format, err := cmd.Flags().GetString(flagFormat)
+			if err != nil {
+				return err
+			}
+			tomlFile, err := toml.NewToml(path)
+			if err != nil {
+				return err
+			}
+			defer tomlFile.Close()
+			h, err := tomlFile.History()
+			if err != nil {
+				return err
+			}
+			old, err := loadVersion(&tomlFile, h, args[0])
+			if err != nil {
+				return err
+			}
+			new := &tomlFile
+			if len(args) > 1 {
+				if new, err = loadVersion(&tomlFile, h, args[1]); err != nil {
+					return err
+				}
+			}
+			return printChanges(old.Diff(new), format)
+		},
+	}
+	diff.Flags().StringP(flagFormat, "f", formatHuman, "output format: human, json or patch")
+
+	restore := &cobra.Command{
+		Use:   "restore <version>",
+		Short: "Restore a version of the cmdb",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return restoreVersion(args[0])
+		},
+	}
+
+	tag := &cobra.Command{
+		Use:   "tag <name> [version]",
+		Short: "Name a version, or snapshot the current cmdb",
+		Args:  cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			tomlFile, err := toml.NewToml(path)
+			if err != nil {
+				return err
+			}
+			defer tomlFile.Close()
+			if len(args) == 1 {
+				v, err := tomlFile.Snapshot(args[0])
+				if err != nil {
+					return err
+				}
+				color.Green("Saved snapshot %s as version %d", args[0], v.ID)
+				return nil
+			}
+			h, err := tomlFile.History()
+			if err != nil {
+				return err
+			}
+			v, err := h.Find(args[1])
+			if err != nil {
+				return err
+			}
+			if err := h.Tag(args[0], v.ID); err != nil {
+				return err
+			}
+			color.Green("Tagged version %d as %s", v.ID, args[0])
+			return nil
+		},
+	}
+
+	cmd.AddCommand(diff, restore, tag)
+	return cmd
+}
+
+// UndoTomlCommand returns undo command
+func UndoTomlCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "undo",
+		Short: "Revert the last change to the cmdb",
+		Long: `
+Restore the most recent version from the history, i.e. the cmdb as it was before
+the last command that changed it. The undo is itself recorded, so running undo
+again reverts it.
+`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			h, err := toml.OpenHistory(path)
+			if err != nil {
+				return err
+			}
+			v, ok := h.Latest()
+			if !ok {
+				return fmt.Errorf("no history for %s", path)
+			}
+			return restoreVersion(strconv.Itoa(v.ID))
+		},
+	}
+	return cmd
+}
+
+func loadVersion(tomlFile *toml.Toml, h *toml.History, ref string) (*toml.Toml, error) {
+	v, err := h.Find(ref)
+	if err != nil {
+		return nil, err
+	}
+	return tomlFile.LoadVersion(h, v)
+}
+
+func restoreVersion(ref string) error {
+	tomlFile, err := toml.NewToml(path)
+	if err != nil {
+		return err
+	}
+	defer tomlFile.Close()
+	h, err := tomlFile.History()
+	if err != nil {
+		return err
+	}
+	v, err := h.Find(ref)
+	if err != nil {
+		return err
+	}
+	old, err := tomlFile.LoadVersion(h, v)
+	if err != nil {
+		return err
+	}
+	tomlFile.Restore(old)
+	if err := tomlFile.Write(); err != nil {
+		return err
+	}
+	color.Green("Restored version %d (%s)", v.ID, v.Time.Local().Format("2006-01-02 15:04:05"))
+	return nil
+}
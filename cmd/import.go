@@ -1,46 +1,122 @@
 package cmd
 
 import (
-	"encoding/json"
-	"fmt"
-	"log"
+	"io"
 	"os"
 
-	"github.com/pelletier/go-toml"
+	"github.com/MinseokOh/toml-cli/toml"
+	"github.com/fatih/color"
 	"github.com/spf13/cobra"
 )
 
+// ImportTomlCommand returns import command
 func ImportTomlCommand() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "import <json file> <prefix>",
-		Short: "Import json to toml",
-		Args:  cobra.ExactArgs(2),
+		Use:   "import <file|-> [prefix]",
+		Short: "Import json, yaml, toml or csv into the cmdb",
+		Long: `
+Import the top-level entries of a JSON, YAML, TOML or CSV file into the cmdb.
+Nested objects become sub-tables. With a prefix, entry web is imported as
+prefix:web.
+
+e.g.
+cm import hosts.json ns
+cm import hosts.yaml ns:host --on-conflict merge
+cm import hosts.csv --dry-run
+curl -s $URL | cm import - --format json
+
+See "cm import ansible --help" for Ansible inventories.
+
+The format is taken from the file extension unless --format is given. A CSV
+file has a header row; the first column names the entry, the other columns are
+its attributes and may be paths such as ssh.port.
+
+--on-conflict decides what happens to entries that already exist with a
+different value:
+  fail       import nothing (default)
+  skip       keep the existing entry
+  overwrite  replace the existing entry
+  merge      merge the imported attributes into the existing entry
+`,
+		Args: cobra.RangeArgs(1, 2),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			from, err := os.ReadFile(args[0])
+			format, err := cmd.Flags().GetString(flagFormat)
 			if err != nil {
-				log.Fatal(err)
+				return err
 			}
-			data := make(map[string]interface{})
-			if err := json.Unmarshal([]byte(from), &data); err != nil {
-				log.Fatal(err)
+			policy, err := cmd.Flags().GetString("on-conflict")
+			if err != nil {
+				return err
+			}
+			if err := toml.ValidateImportPolicy(policy); err != nil {
+				return err
 			}
-			prefix := args[1]
-			newdata := make(map[string]interface{})
-			for k, v := range data {
-				if prefix != "" {
-					newdata[fmt.Sprintf("%s:%s", prefix, k)] = v
-				} else {
-					newdata[k] = v
-				}
+			dryRun, err := cmd.Flags().GetBool("dry-run")
+			if err != nil {
+				return err
 			}
-			tree, err := toml.TreeFromMap(newdata)
+			outDir, err := cmd.Flags().GetString(flagOut)
 			if err != nil {
-				log.Fatal(err)
+				return err
+			}
+			prefix := ""
+			if len(args) > 1 {
+				prefix = args[1]
 			}
-			fmt.Println(tree.String())
 
+			var data []byte
+			if args[0] == "-" {
+				data, err = io.ReadAll(os.Stdin)
+			} else {
+				data, err = os.ReadFile(args[0])
+			}
+			if err != nil {
+				return err
+			}
+			if format == "" {
+				format = toml.DetectFormat(args[0], data)
+			}
+			src, err := toml.Decode(data, format)
+			if err != nil {
+				return err
+			}
+
+			tomlFile, err := toml.NewToml(path)
+			if err != nil {
+				return err
+			}
+			defer tomlFile.Close()
+			tomlFile.Out(outDir)
+			conflicts, err := tomlFile.Import(src, prefix, policy)
+			if err != nil {
+				return err
+			}
+
+			changes, err := tomlFile.Changes()
+			if err != nil {
+				return err
+			}
+			if dryRun {
+				return printChanges(changes, formatHuman)
+			}
+			if err := checkSchema(&tomlFile); err != nil {
+				return err
+			}
+			if err := tomlFile.Write(); err != nil {
+				return err
+			}
+			if len(conflicts) > 0 {
+				color.Yellow("%d existing entries: %s", len(conflicts), policy)
+			}
+			color.Green("imported %s, %d changes", args[0], len(changes))
 			return nil
 		},
 	}
+
+	cmd.Flags().StringP(flagFormat, "f", "", "input format: json, yaml, toml or csv (default: from the file extension)")
+	cmd.Flags().String("on-conflict", toml.ImportFail, "what to do with existing entries: skip, overwrite, merge or fail")
+	cmd.Flags().Bool("dry-run", false, "print the resulting changes without writing")
+	cmd.Flags().StringP(flagOut, "o", "", "set output directory")
+	cmd.AddCommand(importAnsibleCommand())
 	return cmd
 }
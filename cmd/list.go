@@ -18,6 +18,7 @@ func ListTomlCommand() *cobra.Command {
 			if err != nil {
 				return err
 			}
+			defer toml.Close()
 			query := ""
 			if len(args) > 0 {
 				query = args[0]
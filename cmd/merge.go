@@ -3,6 +3,7 @@ package cmd
 import (
 	"fmt"
 	"path/filepath"
+	"strings"
 
 	"github.com/MinseokOh/toml-cli/toml"
 	"github.com/spf13/cobra"
@@ -12,11 +13,29 @@ var mergeCmd = &cobra.Command{
 	Use:   "merge <source1> <source2>",
 	Short: "Merge two TOML files",
 	Long: `Merge two TOML files. The second file's values will overwrite the first file's values.
-Nested objects are merged recursively. Arrays are replaced entirely.
+Nested objects are merged recursively. Arrays are replaced entirely unless another
+strategy is selected:
+
+  replace        the overlay array replaces the base array (default)
+  append         overlay elements are appended
+  union          overlay elements not already present are appended
+  keyed:<field>  arrays of tables are merged element by element, matching on field
+
+A value of "__delete__" in the overlay removes the key; in a keyed array an element
+with __delete__ = true removes its match. Strategies can also be set by the overlay
+itself in a [__merge__] section, which is not copied into the result:
+
+  [__merge__]
+  arrays = "union"
+  [__merge__.paths]
+  servers = "keyed:name"
+
+Flags take precedence over the [__merge__] section.
 
 Examples:
   toml-cli merge config.toml defaults.toml
   toml-cli merge config.toml override.toml -o merged.toml
+  toml-cli merge base.toml prod.toml --arrays union --strategy servers=keyed:name
 `,
 	Args: cobra.ExactArgs(2),
 	RunE: func(cmd *cobra.Command, args []string) error {
@@ -28,17 +47,24 @@ Examples:
 			return err
 		}
 
+		opts, err := mergeOptions(cmd)
+		if err != nil {
+			return err
+		}
+
 		// Load first TOML file (base)
 		base, err := toml.NewToml(source1Path)
 		if err != nil {
 			return fmt.Errorf("failed to load base file %s: %v", source1Path, err)
 		}
+		defer base.Close()
 
 		// Load second TOML file (overlay)
 		overlay, err := toml.NewToml(source2Path)
 		if err != nil {
 			return fmt.Errorf("failed to load overlay file %s: %v", source2Path, err)
 		}
+		defer overlay.Close()
 
 		// Set output path
 		if outDir != "" {
@@ -51,10 +77,14 @@ Examples:
 		}
 
 		// Merge overlay into base
-		if err := base.Merge(&overlay); err != nil {
+		if err := base.MergeWith(&overlay, opts); err != nil {
 			return fmt.Errorf("failed to merge files: %v", err)
 		}
 
+		if err := checkSchema(&base); err != nil {
+			return err
+		}
+
 		// Write the merged result
 		if err := base.Write(); err != nil {
 			return fmt.Errorf("failed to write merged file: %v", err)
@@ -67,4 +97,27 @@ Examples:
 func init() {
 	rootCmd.AddCommand(mergeCmd)
 	mergeCmd.Flags().StringP("output", "o", "", "Output file path")
+	mergeCmd.Flags().String("arrays", "", "Array strategy: replace, append, union or keyed:<field>")
+	mergeCmd.Flags().StringArray("strategy", nil, "Strategy for matching paths, as path=strategy (repeatable)")
+}
+
+// mergeOptions reads the strategy flags of cmd.
+func mergeOptions(cmd *cobra.Command) (toml.MergeOptions, error) {
+	opts := toml.MergeOptions{Paths: make(map[string]string)}
+	var err error
+	if opts.Arrays, err = cmd.Flags().GetString("arrays"); err != nil {
+		return opts, err
+	}
+	specs, err := cmd.Flags().GetStringArray("strategy")
+	if err != nil {
+		return opts, err
+	}
+	for _, spec := range specs {
+		i := strings.LastIndexByte(spec, '=')
+		if i <= 0 {
+			return opts, fmt.Errorf("invalid --strategy %q, expected path=strategy", spec)
+		}
+		opts.Paths[spec[:i]] = spec[i+1:]
+	}
+	return opts, nil
 }
@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/MinseokOh/toml-cli/toml"
+	"github.com/spf13/cobra"
+)
+
+var merge3Cmd = &cobra.Command{
+	Use:   "merge3 <base> <ours> <theirs>",
+	Short: "Three-way merge of TOML files",
+	Long: `Merge the changes made to a common ancestor on two sides. base is the file both
+sides started from; the changes from base to theirs are applied to ours.
+
+Keys changed on one side only are taken from that side, and tables changed on both
+sides are merged key by key. A key changed differently on both sides is a conflict:
+our value is kept and the conflict is reported. The command exits non-zero when
+there are conflicts.
+
+With --format toml (default) the merged file is printed with a comment block above
+each conflicting key. With --format json only the list of conflicts is printed.
+
+With -o the merged file is written like any edit of the cmdb: locked, replaced
+atomically, kept in the history and encrypted as ours is; with --format json
+the conflicts are still printed. Printing the merged file or the conflicts
+when an input is encrypted needs --plain or a confirmation.
+
+Examples:
+  toml-cli merge3 base.toml cmdb.toml theirs.toml
+  toml-cli merge3 base.toml cmdb.toml theirs.toml -o cmdb.toml
+  toml-cli merge3 base.toml cmdb.toml theirs.toml -f json
+`,
+	Args: cobra.ExactArgs(3),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		out, err := cmd.Flags().GetString("output")
+		if err != nil {
+			return err
+		}
+		format, err := cmd.Flags().GetString(flagFormat)
+		if err != nil {
+			return err
+		}
+		if format != toml.FormatToml && format != toml.FormatJson {
+			return fmt.Errorf("unknown format %q, expected toml or json", format)
+		}
+
+		var files [3]toml.Toml
+		for i, p := range args {
+			if files[i], err = toml.NewToml(p); err != nil {
+				return fmt.Errorf("failed to load %s: %v", p, err)
+			}
+			defer files[i].Close()
+		}
+		base, ours, theirs := &files[0], &files[1], &files[2]
+
+		conflicts := ours.Merge3(base, theirs)
+
+		var content []byte
+		switch {
+		case format == toml.FormatJson:
+			if conflicts == nil {
+				conflicts = []toml.Conflict{}
+			}
+			if content, err = json.MarshalIndent(conflicts, "", "  "); err != nil {
+				return err
+			}
+			content = append(content, '\n')
+		case out == "":
+			if content, err = ours.Render(); err != nil {
+				return err
+			}
+			if content, err = toml.AnnotateConflicts(content, conflicts); err != nil {
+				return err
+			}
+		}
+
+		if content != nil {
+			if err := toml.CheckPlaintext("", base, ours, theirs); err != nil {
+				return err
+			}
+		}
+		if out != "" {
+			if err := ours.Annotate(conflicts); err != nil {
+				return err
+			}
+			ours.Out(out)
+			if err := ours.Write(); err != nil {
+				return err
+			}
+		}
+		if content != nil {
+			fmt.Print(string(content))
+		}
+
+		if len(conflicts) > 0 {
+			for _, c := range conflicts {
+				fmt.Fprintln(os.Stderr, "conflict:", c)
+			}
+			return fmt.Errorf("%d conflicts", len(conflicts))
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(merge3Cmd)
+	merge3Cmd.Flags().StringP("output", "o", "", "Output file path (default: stdout)")
+	merge3Cmd.Flags().StringP(flagFormat, "f", toml.FormatToml, "output format: toml or json")
+}
@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/MinseokOh/toml-cli/agent"
+	"github.com/MinseokOh/toml-cli/encrypt"
+	"github.com/MinseokOh/toml-cli/toml"
+	"github.com/stretchr/testify/require"
+)
+
+// encryptedSample writes content encrypted with password "pw", which the
+// commands read from the environment.
+func encryptedSample(t *testing.T, name, content string) string {
+	t.Setenv(agent.SocketEnv, filepath.Join(t.TempDir(), "agent.sock"))
+	t.Setenv(toml.SeenEnv, filepath.Join(t.TempDir(), "seen.json"))
+	t.Setenv(encrypt.PasswordEnv, "pw")
+	enc, err := encrypt.EncryptKDF([]byte(content), "pw", encrypt.KDFPBKDF2)
+	require.Nil(t, err)
+	p := filepath.Join(t.TempDir(), name)
+	require.Nil(t, os.WriteFile(p, []byte(enc), 0600))
+	return p
+}
+
+func TestMerge3EncryptedOutput(t *testing.T) {
+	base := encryptedSample(t, "base.toml", "[web]\npassword = \"s3cret\"\nport = 22\n")
+	ours := encryptedSample(t, "ours.toml", "[web]\npassword = \"s3cret\"\nport = 2222\n")
+	theirs := encryptedSample(t, "theirs.toml", "[web]\npassword = \"n3w\"\nport = 22\n")
+	out := filepath.Join(t.TempDir(), "merged.toml")
+
+	rootCmd.SetArgs([]string{"merge3", "--no-prompt", base, ours, theirs, "-o", out})
+	require.Nil(t, rootCmd.Execute())
+	data, err := os.ReadFile(out)
+	require.Nil(t, err)
+	require.NotContains(t, string(data), "n3w")
+	doc, err := encrypt.Decrypt(string(data), "pw")
+	require.Nil(t, err)
+	require.Contains(t, string(doc), `password = "n3w"`)
+	require.Contains(t, string(doc), "port = 2222")
+
+	// With -f json the merged file is still what is written, and printing the
+	// conflicts has to be confirmed first.
+	before, err := os.ReadFile(ours)
+	require.Nil(t, err)
+	rootCmd.SetArgs([]string{"merge3", "--no-prompt", base, ours, theirs, "-f", "json", "-o", ours})
+	require.ErrorIs(t, rootCmd.Execute(), toml.ErrPlaintext)
+	data, err = os.ReadFile(ours)
+	require.Nil(t, err)
+	require.Equal(t, before, data)
+	rootCmd.SetArgs([]string{"merge3", "--no-prompt", "--plain", base, ours, theirs, "-f", "json", "-o", ours})
+	require.Nil(t, rootCmd.Execute())
+	plain = false
+	data, err = os.ReadFile(ours)
+	require.Nil(t, err)
+	doc, err = encrypt.Decrypt(string(data), "pw")
+	require.Nil(t, err)
+	require.Contains(t, string(doc), `password = "n3w"`)
+
+	// Printing it has to be confirmed.
+	rootCmd.SetArgs([]string{"merge3", "--no-prompt", base, ours, theirs, "-f", "toml", "-o", ""})
+	require.ErrorIs(t, rootCmd.Execute(), toml.ErrPlaintext)
+}
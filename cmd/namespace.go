@@ -23,6 +23,7 @@ cm ns
 			if err != nil {
 				return err
 			}
+			defer toml.Close()
 
 			ns := make(map[string]int)
 
@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"io"
+	"os"
+
+	"github.com/MinseokOh/toml-cli/toml"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+// PatchTomlCommand returns patch command
+func PatchTomlCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "patch <patch-file|->",
+		Short: "Apply a list of operations to the cmdb",
+		Long: `
+Apply an RFC 6902 JSON Patch, or the same operations written in TOML, to the
+cmdb. Operations run in order and either all of them apply or the file is left
+untouched. Encrypted files stay encrypted.
+
+e.g.
+cm patch changes.json
+cm diff old.toml new.toml -f patch | cm patch -
+cm patch changes.toml --dry-run
+
+JSON patch:
+  [
+    {"op": "test",    "path": "/ns:host:web/port", "value": 22},
+    {"op": "replace", "path": "/ns:host:web/port", "value": 2222},
+    {"op": "add",     "path": "/ns:host:web/tags/-", "value": "edge"},
+    {"op": "move",    "from": "/ns:host:old", "path": "/ns:host:new"}
+  ]
+
+TOML patch (paths may also be written as in "cm get"):
+  [[op]]
+  op    = "replace"
+  path  = "ns:host:web.port"
+  value = 2222
+
+  [[op]]
+  op   = "copy"
+  from = "ns:host:web"
+  path = "ns:host:web2"
+
+Operations: add, remove, replace, move, copy, test
+`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			outDir, err := cmd.Flags().GetString(flagOut)
+			if err != nil {
+				return err
+			}
+			dryRun, err := cmd.Flags().GetBool("dry-run")
+			if err != nil {
+				return err
+			}
+
+			var data []byte
+			if args[0] == "-" {
+				data, err = io.ReadAll(os.Stdin)
+			} else {
+				data, err = os.ReadFile(args[0])
+			}
+			if err != nil {
+				return err
+			}
+			ops, err := toml.ParsePatch(data)
+			if err != nil {
+				return err
+			}
+
+			tomlFile, err := toml.NewToml(path)
+			if err != nil {
+				return err
+			}
+			defer tomlFile.Close()
+			tomlFile.Out(outDir)
+			if err := tomlFile.ApplyPatch(ops); err != nil {
+				return err
+			}
+
+			changes, err := tomlFile.Changes()
+			if err != nil {
+				return err
+			}
+			if dryRun {
+				return printChanges(changes, formatHuman)
+			}
+			if err := checkSchema(&tomlFile); err != nil {
+				return err
+			}
+			if err := tomlFile.Write(); err != nil {
+				return err
+			}
+			color.Green("applied %d operations, %d changes", len(ops), len(changes))
+			return nil
+		},
+	}
+
+	cmd.Flags().StringP(flagOut, "o", "", "set output directory")
+	cmd.Flags().Bool("dry-run", false, "print the resulting changes without writing")
+	return cmd
+}
@@ -0,0 +1,220 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/MinseokOh/toml-cli/encrypt"
+	"github.com/MinseokOh/toml-cli/toml"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+// IdentityTomlCommand returns identity command
+func IdentityTomlCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "identity",
+		Short: "Show the public key of your identity",
+		Long: `
+Your identity is the private key that opens cmdb files encrypted to you as a
+recipient. It is kept in ~/.config/cmdb/identity, or in $CMDB_IDENTITY. Give
+its public key to whoever adds you with "cm recipients add".
+
+e.g.
+cm identity generate
+cm identity
+`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := encrypt.LoadIdentity()
+			if err != nil {
+				return err
+			}
+			fmt.Println(id.PublicKey())
+			return nil
+		},
+	}
+
+	generate := &cobra.Command{
+		Use:   "generate",
+		Short: "Create your identity",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := encrypt.GenerateIdentity()
+			if err != nil {
+				return err
+			}
+			if err := encrypt.SaveIdentity(id, encrypt.IdentityPath()); err != nil {
+				return fmt.Errorf("failed to save identity: %w", err)
+			}
+			color.Green("Identity saved to %s, public key:", encrypt.IdentityPath())
+			fmt.Println(id.PublicKey())
+			return nil
+		},
+	}
+	cmd.AddCommand(generate)
+	return cmd
+}
+
+// RecipientsTomlCommand returns recipients command
+func RecipientsTomlCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "recipients",
+		Short: "List the recipients the cmdb is encrypted to",
+		Long: `
+A cmdb encrypted to recipients needs no shared password: its key is wrapped
+once per recipient public key, and each recipient opens it with their own
+identity (see "cm identity").
+
+Adding recipients to a plain or password encrypted cmdb encrypts it to them,
+and to you if you have an identity. Every change of the recipients, and
+rewrap, encrypts the cmdb with a new key: once removed, a recipient cannot
+read later versions.
+
+e.g.
+cm recipients
+cm recipients add cmdb-pub-... alice
+cm recipients remove alice
+cm recipients rewrap
+`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			tomlFile, err := toml.NewToml(path)
+			if err != nil {
+				return err
+			}
+			defer tomlFile.Close()
+
+			if len(tomlFile.Recipients()) == 0 {
+				color.Yellow("%s is not encrypted to recipients", path)
+				return nil
+			}
+			self := selfPublicKey()
+			for _, r := range tomlFile.Recipients() {
+				you := ""
+				if r.PublicKey == self {
+					you = color.CyanString(" (you)")
+				}
+				fmt.Printf("%-12s %s%s\n", r.Name, r.PublicKey, you)
+			}
+			return nil
+		},
+	}
+
+	add := &cobra.Command{
+		Use:   "add <public-key> [name]",
+		Short: "Encrypt the cmdb to one more recipient",
+		Args:  cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			public, err := encrypt.ParsePublicKey(args[0])
+			if err != nil {
+				return err
+			}
+			name := ""
+			if len(args) > 1 {
+				name = args[1]
+			}
+			return updateRecipients(func(recipients []encrypt.Recipient) ([]encrypt.Recipient, error) {
+				for _, r := range recipients {
+					if r.PublicKey == public {
+						return nil, fmt.Errorf("%s is already a recipient", args[0])
+					}
+					if name != "" && r.Name == name {
+						return nil, fmt.Errorf("a recipient is already named %s", name)
+					}
+				}
+				if self := selfPublicKey(); len(recipients) == 0 && self != "" && self != public {
+					// Keep access to the cmdb being encrypted.
+					recipients = append(recipients, encrypt.Recipient{PublicKey: self})
+				}
+				return append(recipients, encrypt.Recipient{Name: name, PublicKey: public}), nil
+			})
+		},
+	}
+
+	remove := &cobra.Command{
+		Use:   "remove <public-key|name>...",
+		Short: "Stop encrypting the cmdb to recipients",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return updateRecipients(func(recipients []encrypt.Recipient) ([]encrypt.Recipient, error) {
+				var kept []encrypt.Recipient
+				removed := make(map[string]bool)
+				for _, r := range recipients {
+					match := false
+					for _, arg := range args {
+						if arg == r.PublicKey || (r.Name != "" && arg == r.Name) {
+							match, removed[arg] = true, true
+						}
+					}
+					if !match {
+						kept = append(kept, r)
+					}
+				}
+				for _, arg := range args {
+					if !removed[arg] {
+						return nil, fmt.Errorf("%s is not a recipient", arg)
+					}
+				}
+				if len(kept) == 0 {
+					return nil, fmt.Errorf("cannot remove every recipient, use \"cm decrypt\" instead")
+				}
+				return kept, nil
+			})
+		},
+	}
+
+	rewrap := &cobra.Command{
+		Use:   "rewrap",
+		Short: "Encrypt the cmdb to its recipients with a new key",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return updateRecipients(func(recipients []encrypt.Recipient) ([]encrypt.Recipient, error) {
+				if len(recipients) == 0 {
+					return nil, fmt.Errorf("%s is not encrypted to recipients", path)
+				}
+				return recipients, nil
+			})
+		},
+	}
+
+	cmd.AddCommand(add, remove, rewrap)
+	return cmd
+}
+
+// updateRecipients writes the cmdb encrypted to the recipients update returns
+// for its current ones.
+func updateRecipients(update func([]encrypt.Recipient) ([]encrypt.Recipient, error)) error {
+	tomlFile, err := toml.NewToml(path)
+	if err != nil {
+		return err
+	}
+	defer tomlFile.Close()
+
+	recipients, err := update(append([]encrypt.Recipient(nil), tomlFile.Recipients()...))
+	if err != nil {
+		return err
+	}
+	tomlFile.SetRecipients(recipients)
+	if err := tomlFile.Write(); err != nil {
+		return err
+	}
+
+	self, found := selfPublicKey(), false
+	for _, r := range recipients {
+		found = found || r.PublicKey == self
+	}
+	if !found {
+		color.Yellow("You are not a recipient: you can no longer decrypt %s", path)
+	}
+	color.Green("%s is encrypted to %d recipients", path, len(recipients))
+	return nil
+}
+
+// selfPublicKey returns the public key of the user's identity, if any.
+func selfPublicKey() string {
+	id, err := encrypt.LoadIdentity()
+	if err != nil {
+		return ""
+	}
+	return id.PublicKey()
+}
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/MinseokOh/toml-cli/encrypt"
+	"github.com/MinseokOh/toml-cli/toml"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+// RekeyTomlCommand returns rekey command
+func RekeyTomlCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rekey",
+		Short: "Change the password of the cmdb",
+		Long: `
+Re-encrypt the cmdb, encrypted whole or in field mode, with a new password.
+The plaintext is never written to disk and the file is replaced atomically,
+after the result was decrypted again unless --verify=false. If the agent kept
+the file unlocked, it keeps it unlocked with the new key.
+
+The current password comes from the usual sources (see "cm --help"); the
+new one is always entered on the terminal, twice.
+
+Versions kept in the history stay encrypted with the old password.
+
+e.g.
+cm rekey
+`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			verify, err := cmd.Flags().GetBool("verify")
+			if err != nil {
+				return err
+			}
+			kdf, err := cmd.Flags().GetString("kdf")
+			if err != nil {
+				return err
+			}
+
+			oldPassword, err := encrypt.ReadPassword(false)
+			if err != nil {
+				return err
+			}
+			newPassword, err := encrypt.PromptNewPassword()
+			if err != nil {
+				return err
+			}
+			if newPassword == "" {
+				return fmt.Errorf("the new password is empty")
+			}
+
+			if err := toml.Rekey(path, oldPassword, newPassword, kdf, verify); err != nil {
+				return err
+			}
+			color.Green("%s is now encrypted with the new password", path)
+			if h, err := toml.OpenHistory(path); err == nil && len(h.Versions) > 0 {
+				color.Yellow("The %d versions in its history stay encrypted with the old password", len(h.Versions))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().Bool("verify", true, "decrypt the result again before replacing the file")
+	cmd.Flags().String("kdf", encrypt.DefaultKDF, "derive the new key with argon2id, scrypt or pbkdf2")
+	return cmd
+}
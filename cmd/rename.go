@@ -11,7 +11,12 @@ func RenameTomlCommand() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "rename oldkey newkey",
 		Short: "Rename a key",
-		Args:  cobra.ExactArgs(2),
+		Long: `
+e.g.
+cm rename ns:host:web ns:host:web1
+cm rename ns:host:web.ssh ns:host:web.ssh_old
+`,
+		Args: cobra.ExactArgs(2),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			ok := args[0]
 			nk := args[1]
@@ -19,6 +24,7 @@ func RenameTomlCommand() *cobra.Command {
 			if err != nil {
 				return err
 			}
+			defer toml.Close()
 			v := toml.Get(ok)
 			if v == nil {
 				return fmt.Errorf("The key [%s] do not exist", ok)
@@ -26,9 +32,15 @@ func RenameTomlCommand() *cobra.Command {
 			if err := toml.Clear(ok); err != nil {
 				return fmt.Errorf("Clear key [%s] failed: %s", ok, err)
 			}
+			if toml.Get(ok) != nil {
+				return fmt.Errorf("Clear key [%s] failed: it is still set", ok)
+			}
 			if err := toml.Set(nk, "", v); err != nil {
 				return fmt.Errorf("Write new key [%s] error: %s", nk, err)
 			}
+			if err := checkSchema(&toml); err != nil {
+				return err
+			}
 			if err := toml.Write(); err != nil {
 				return fmt.Errorf("Save error: %v", err)
 			}
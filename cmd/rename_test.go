@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenameNested(t *testing.T) {
+	cmdb := useCmdb(t, "[server]\nport = 1\n\n[\"ns:host:web\".ssh]\nport = 22\n")
+
+	rootCmd.SetArgs([]string{"rename", "server.port", "server.p2"})
+	require.Nil(t, rootCmd.Execute())
+	rootCmd.SetArgs([]string{"rename", "ns:host:web.ssh", "ns:host:web.ssh_old"})
+	require.Nil(t, rootCmd.Execute())
+
+	data, err := os.ReadFile(cmdb)
+	require.Nil(t, err)
+	require.Contains(t, string(data), "p2 = 1")
+	require.NotContains(t, string(data), "port = 1")
+	require.NotContains(t, string(data), `"server.p2"`)
+	require.Contains(t, string(data), "ssh_old")
+	require.NotContains(t, string(data), `["ns:host:web".ssh]`)
+
+	rootCmd.SetArgs([]string{"clear", "server.p2"})
+	require.Nil(t, rootCmd.Execute())
+	data, err = os.ReadFile(cmdb)
+	require.Nil(t, err)
+	require.NotContains(t, string(data), "p2")
+}
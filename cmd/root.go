@@ -8,6 +8,8 @@ import (
 	"sort"
 	"strings"
 
+	"github.com/MinseokOh/toml-cli/encrypt"
+	"github.com/MinseokOh/toml-cli/toml"
 	"github.com/fatih/color"
 	lib "github.com/pelletier/go-toml"
 	"github.com/spf13/cobra"
@@ -24,7 +26,26 @@ func GetRootCommand() *cobra.Command {
 		Use:          "cm",
 		Short:        "cm",
 		SilenceUsage: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			// Recorded with the history of the files this command writes.
+			toml.Command, toml.CommandArgs = cmd.CommandPath(), args
+			return setWriteEncryption(cmd)
+		},
 		Long: `A simple CLI for editing and querying TOML files. We use it as a config manager.
+
+The password of an encrypted cmdb is taken from the first of: the agent (see
+"cm unlock"), $CMDB_PASSWORD, --password-file, --password-command, or else a
+prompt on the terminal. With --no-prompt a missing password is an error.
+
+Reading an encrypted cmdb older than the one last read or written at its path,
+or another cmdb, fails: it may have been rolled back to hide a change. Pass
+--allow-rollback to read it anyway, e.g. after checking out an old revision.
+
+What a command writes, to the cmdb or to another file with -o, is encrypted as
+the cmdb it was read from. --encrypt also encrypts a plaintext cmdb, asking for
+a new password; --no-encrypt writes plaintext, once confirmed if the cmdb or
+the file replaced is encrypted. --plain confirms it, and printing the content
+of an encrypted cmdb.
 	`,
 	}
 
@@ -34,11 +55,20 @@ func GetRootCommand() *cobra.Command {
 func init() {
 	rootCmd.PersistentFlags().StringVarP(&path, "config", "c", "", "配置文件路径")
 	rootCmd.PersistentFlags().BoolVarP(&plain, "plain", "p", false, "是否解析密文信息")
+	rootCmd.PersistentFlags().StringVar(&schemaPath, "schema", "", "schema file (default: <config>.schema.toml)")
+	rootCmd.PersistentFlags().StringVar(&encrypt.PasswordFile, "password-file", "", "read the cmdb password from this file")
+	rootCmd.PersistentFlags().StringVar(&encrypt.PasswordCommand, "password-command", "", "run this shell command to get the cmdb password")
+	rootCmd.PersistentFlags().BoolVar(&encrypt.NoPrompt, "no-prompt", false, "fail instead of prompting for the cmdb password")
+	rootCmd.PersistentFlags().Bool(flagEncrypt, false, "encrypt what is written, even if the cmdb is plaintext")
+	rootCmd.PersistentFlags().Bool(flagNoEncrypt, false, "write plaintext, even if the cmdb is encrypted")
+	rootCmd.MarkFlagsMutuallyExclusive(flagEncrypt, flagNoEncrypt)
+	rootCmd.PersistentFlags().BoolVar(&toml.AllowRollback, "allow-rollback", false, "warn instead of failing when an encrypted cmdb was rolled back")
 	rootCmd.AddCommand(GetTomlCommand())
 	rootCmd.AddCommand(SetTomlCommand())
 	rootCmd.AddCommand(ListTomlCommand())
 	rootCmd.AddCommand(DeleteTomlCommand())
 	rootCmd.AddCommand(DumpTomlCommand())
+	rootCmd.AddCommand(ExportTomlCommand())
 	rootCmd.AddCommand(ImportTomlCommand())
 	rootCmd.AddCommand(ClearTomlCommand())
 	rootCmd.AddCommand(FingerTomlCommand())
@@ -48,10 +78,31 @@ func init() {
 	rootCmd.AddCommand(sshCmd)
 	rootCmd.AddCommand(GetEncryptCommand())
 	rootCmd.AddCommand(GetDecryptCommand())
+	rootCmd.AddCommand(IdentityTomlCommand())
+	rootCmd.AddCommand(RecipientsTomlCommand())
+	rootCmd.AddCommand(AgentTomlCommand())
+	rootCmd.AddCommand(UnlockTomlCommand())
+	rootCmd.AddCommand(LockTomlCommand())
+	rootCmd.AddCommand(RekeyTomlCommand())
+	rootCmd.AddCommand(ValidateTomlCommand())
+	rootCmd.AddCommand(DiffTomlCommand())
+	rootCmd.AddCommand(PatchTomlCommand())
+	rootCmd.AddCommand(HistoryTomlCommand())
+	rootCmd.AddCommand(UndoTomlCommand())
+	rootCmd.AddCommand(AuditTomlCommand())
 }
 
 // Execute commands
 func Execute() {
+	cobra.OnInitialize(initConfigPath)
+	if err := rootCmd.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+// initConfigPath falls back to the default cmdb file once flags are parsed and
+// creates it if missing. The notice goes to stderr so stdout stays pipeable.
+func initConfigPath() {
 	home := os.Getenv("HOME")
 	if home == "" {
 		// Windows fallback
@@ -59,7 +110,7 @@ func Execute() {
 	}
 	if path == "" {
 		path = filepath.Join(home, ".config", "cmdb", "cmdb.toml")
-		fmt.Printf("配置文件未指定，使用默认文件: %s\n", path)
+		fmt.Fprintf(os.Stderr, "配置文件未指定，使用默认文件: %s\n", path)
 	}
 	if _, err := os.Stat(path); os.IsNotExist(err) {
 		configDir := filepath.Join(home, ".config", "cmdb")
@@ -72,11 +123,44 @@ func Execute() {
 		}
 		f.Close()
 	}
-	if err := rootCmd.Execute(); err != nil {
-		os.Exit(1)
+}
+
+const (
+	flagEncrypt   = "encrypt"
+	flagNoEncrypt = "no-encrypt"
+)
+
+// setWriteEncryption applies --encrypt and --no-encrypt to what the command
+// writes. Writing an encrypted cmdb out in plaintext is confirmed first.
+func setWriteEncryption(cmd *cobra.Command) error {
+	encryptOut, err := cmd.Flags().GetBool(flagEncrypt)
+	if err != nil {
+		return err
+	}
+	plaintextOut, err := cmd.Flags().GetBool(flagNoEncrypt)
+	if err != nil {
+		return err
 	}
+	switch {
+	case encryptOut:
+		toml.WriteEncryption = toml.EncryptAlways
+	case plaintextOut:
+		toml.WriteEncryption = toml.EncryptNever
+	}
+	toml.ConfirmPlaintext = func(path string) (bool, error) {
+		if plain {
+			return true, nil
+		}
+		// On stderr, stdout may be what is written.
+		fmt.Fprintln(os.Stderr, color.YellowString("Secrets of an encrypted cmdb are about to be written to %s in plaintext", path))
+		return encrypt.Confirm("Write plaintext?")
+	}
+	return nil
 }
 
+// secretMask is shown instead of a private key unless --plain is set.
+const secretMask = "********************"
+
 func printAConfigure(key string, v any) {
 	color.New(color.FgRed).Add(color.Bold).Add(color.Underline).Printf("%s\n", key)
 	switch v.(type) {
@@ -111,7 +195,7 @@ func printAConfigure(key string, v any) {
 			v = treeMap[k]
 			if s, ok := v.(string); ok {
 				if k == "private_key" && !plain {
-					fmt.Printf("%-*s = %s\n", maxKeyLength, k, "********************")
+					fmt.Printf("%-*s = %s\n", maxKeyLength, k, secretMask)
 				} else {
 					fmt.Printf("%-*s = %s\n", maxKeyLength, k, s)
 				}
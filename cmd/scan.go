@@ -28,6 +28,7 @@ cm scan title
 			if err != nil {
 				return err
 			}
+			defer toml.Close()
 
 			for _, k := range toml.Keys() {
 				pos := strings.Index(strings.ToLower(k), strings.ToLower(query))
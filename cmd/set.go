@@ -1,15 +1,17 @@
 package cmd
 
 import (
-	"strconv"
+	"fmt"
+	"strings"
 
 	"github.com/MinseokOh/toml-cli/toml"
-	lib "github.com/pelletier/go-toml"
 	"github.com/spf13/cobra"
 )
 
 const (
-	flagOut = "out"
+	flagOut    = "out"
+	flagType   = "type"
+	flagString = "string"
 )
 
 // SetTomlCommand returns set command
@@ -24,64 +26,128 @@ cm set  192.168.11.11 title 123456
 
 e.g.
 cm set  192.168.11.11 title 123456 comment 测试主机 -o out.toml
+
+key and attr are paths, as in "cm get", so nested tables and array elements
+can be edited. A top-level key that literally matches key, such as
+192.168.11.11 above, takes precedence; quote a new one with dots in it:
+cm set  ns:host:web ssh.options.port 2222
+cm set  servers[2] ip 10.0.0.3
+cm set  '"10.0.0.7"' title db
+
+Values are typed by default: 22 is an integer, true a boolean, 2024-01-02 a
+date, and TOML arrays or inline tables are accepted as written. Input that
+would not be written back as typed, such as 0x1F or 1e3, stays a string, and so
+do the values of password and private_key. A type can be forced per attribute
+with attr:type, and attr=value works as a single argument:
+cm set  ns:host:web port:int=2222 tags='["web","db"]' opts '{ agent = true }'
+cm set  ns:host:web version 1.0 --string
+cm set  ns:host:web tags web,db --type array
+
+Types: auto, string, int, float, bool, date, datetime, time, array, table
 `,
-		Args: cobra.MinimumNArgs(3),
+		Args: cobra.MinimumNArgs(2),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			key := args[0]
 			outDir, err := cmd.Flags().GetString(flagOut)
 			if err != nil {
 				return err
 			}
+			typ, err := cmd.Flags().GetString(flagType)
+			if err != nil {
+				return err
+			}
+			if asString, _ := cmd.Flags().GetBool(flagString); asString {
+				typ = toml.TypeString
+			}
+			if !toml.IsValueType(typ) {
+				return fmt.Errorf("unknown type %q", typ)
+			}
+
+			values, err := parseSetArgs(args[1:], typ)
+			if err != nil {
+				return err
+			}
+
 			toml, err := toml.NewToml(path)
 			if err != nil {
 				return err
 			}
+			defer toml.Close()
 
 			toml.Out(outDir)
 
-			for i := 1; i < len(args); i += 2 {
-				if err := toml.Set(key, args[i], args[i+1]); err != nil {
+			for _, v := range values {
+				if err := toml.Set(key, v.attr, v.value); err != nil {
 					return err
 				}
 			}
 
+			if err := checkSchema(&toml); err != nil {
+				return err
+			}
 			if err := toml.Write(); err != nil {
 				return err
 			}
-            printAConfigure(key, toml.Get(key))
+			printAConfigure(key, toml.Get(key))
 
 			return nil
 		},
 	}
 
 	cmd.Flags().StringP(flagOut, "o", "", "set output directory")
+	cmd.Flags().StringP(flagType, "t", toml.TypeAuto, "type of the values unless overridden by attr:type")
+	cmd.Flags().BoolP(flagString, "s", false, "store every value as a string")
 	return cmd
 }
 
-func parseInput(str string) interface{} {
-	if val, err := strconv.ParseBool(str); err == nil {
-		return val
-	}
-
-	if val, err := strconv.ParseInt(str, 0, 64); err == nil {
-		return val
-	}
-
-	if val, err := strconv.ParseFloat(str, 64); err == nil {
-		return val
-	}
+type setValue struct {
+	attr  string
+	value interface{}
+}
 
-	if val, err := lib.ParseLocalDate(str); err == nil {
-		return val
+// isSecretAttr reports whether attr names one of toml.DefaultSecretKeys.
+func isSecretAttr(attr string) bool {
+	p, err := toml.ParsePath(attr)
+	if err != nil || len(p) == 0 || p[len(p)-1].IsIndex {
+		return false
 	}
-
-	if val, err := lib.ParseLocalDateTime(str); err == nil {
-		return val
+	for _, k := range toml.DefaultSecretKeys {
+		if p[len(p)-1].Key == k {
+			return true
+		}
 	}
+	return false
+}
 
-	if val, err := lib.ParseLocalTime(str); err == nil {
-		return val
+// parseSetArgs reads `attr value` pairs and `attr=value` arguments. An attr
+// may carry a type suffix (port:int) which overrides typ.
+func parseSetArgs(args []string, typ string) ([]setValue, error) {
+	var values []setValue
+	for i := 0; i < len(args); i++ {
+		spec, raw := args[i], ""
+		if eq := strings.IndexByte(spec, '='); eq > 0 {
+			spec, raw = spec[:eq], spec[eq+1:]
+		} else {
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("missing value for %s", spec)
+			}
+			i++
+			raw = args[i]
+		}
+
+		attr, attrType := toml.SplitTypedAttr(spec)
+		if attrType == "" {
+			attrType = typ
+			// A password of digits is still a password
+			if attrType == toml.TypeAuto && isSecretAttr(attr) {
+				attrType = toml.TypeString
+			}
+		}
+		v, err := toml.ParseValue(raw, attrType)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", attr, err)
+		}
+		values = append(values, setValue{attr: attr, value: v})
 	}
-
-	return str
+	return values, nil
 }
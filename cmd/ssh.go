@@ -6,6 +6,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -116,6 +117,10 @@ type SSHHost struct {
 	Tags         string `toml:"tags"`
 	ForwardAgent bool   `toml:"forward_agent"`
 	ProxyJump    string `toml:"proxy_jump"`
+	// Options are other ssh_config options, by keyword.
+	Options map[string][]string `toml:"ssh_options"`
+	// AnsibleVars are other Ansible host variables.
+	AnsibleVars map[string]interface{} `toml:"ansible_vars"`
 }
 
 func runSSHAdd(cmd *cobra.Command, args []string) {
@@ -266,6 +271,7 @@ func runSSHList(cmd *cobra.Command, args []string) {
 		color.Red("Failed to load cmdb file: %v", err)
 		return
 	}
+	defer tomlFile.Close()
 
 	color.Cyan("SSH Hosts in cmdb:")
 	fmt.Println()
@@ -294,6 +300,7 @@ func runSSHConfig(cmd *cobra.Command, args []string) {
 		color.Red("Failed to load cmdb file: %v", err)
 		return
 	}
+	defer tomlFile.Close()
 
 	host, err := getHostFromCMDB(hostKey, tomlFile)
 	if err != nil {
@@ -313,12 +320,15 @@ func runSSHConnect(cmd *cobra.Command, args []string) {
 		color.Red("Failed to load cmdb file: %v", err)
 		return
 	}
+	defer tomlFile.Close()
 
 	host, err := getHostFromCMDB(hostKey, tomlFile)
 	if err != nil {
 		color.Red("Failed to get host '%s': %v", hostKey, err)
 		return
 	}
+	// The session may last long: do not keep others from writing the cmdb.
+	tomlFile.Close()
 
 	// Determine authentication method
 	hasPrivateKey := host.KeyPath != "" || host.PrivateKey != ""
@@ -420,6 +430,7 @@ func runSSHSync(cmd *cobra.Command, args []string) {
 		color.Red("Failed to load cmdb file: %v", err)
 		return
 	}
+	defer tomlFile.Close()
 
 	// Create separate cmdb SSH config file
 	home := os.Getenv("HOME")
@@ -481,6 +492,7 @@ func runSSHKeyGenerate(cmd *cobra.Command, args []string) {
 		color.Red("Failed to load cmdb file: %v", err)
 		return
 	}
+	defer tomlFile.Close()
 
 	host, err := getHostFromCMDB(hostKey, tomlFile)
 	if err != nil {
@@ -523,6 +535,7 @@ func runSSHKeyImportPublic(cmd *cobra.Command, args []string) {
 		color.Red("Failed to load cmdb file: %v", err)
 		return
 	}
+	defer tomlFile.Close()
 
 	host, err := getHostFromCMDB(hostKey, tomlFile)
 	if err != nil {
@@ -561,6 +574,7 @@ func runSSHKeyImportPrivate(cmd *cobra.Command, args []string) {
 		color.Red("Failed to load cmdb file: %v", err)
 		return
 	}
+	defer tomlFile.Close()
 
 	host, err := getHostFromCMDB(hostKey, tomlFile)
 	if err != nil {
@@ -606,6 +620,7 @@ func runSSHKeyImportBoth(cmd *cobra.Command, args []string) {
 		color.Red("Failed to load cmdb file: %v", err)
 		return
 	}
+	defer tomlFile.Close()
 
 	host, err := getHostFromCMDB(hostKey, tomlFile)
 	if err != nil {
@@ -742,9 +757,26 @@ func generateSSHConfigEntry(hostKey string, host SSHHost) string {
 		config.WriteString(fmt.Sprintf("    ProxyJump %s\n", host.ProxyJump))
 	}
 
+	keywords := make([]string, 0, len(host.Options))
+	overridden := make(map[string]bool)
+	for keyword := range host.Options {
+		keywords = append(keywords, keyword)
+		overridden[strings.ToLower(keyword)] = true
+	}
+	sort.Strings(keywords)
+	for _, keyword := range keywords {
+		for _, value := range host.Options[keyword] {
+			config.WriteString(fmt.Sprintf("    %s %s\n", keyword, value))
+		}
+	}
+
 	// Add some nice defaults
-	config.WriteString("    StrictHostKeyChecking no\n")
-	config.WriteString("    UserKnownHostsFile /dev/null\n")
+	if !overridden["stricthostkeychecking"] {
+		config.WriteString("    StrictHostKeyChecking no\n")
+	}
+	if !overridden["userknownhostsfile"] {
+		config.WriteString("    UserKnownHostsFile /dev/null\n")
+	}
 
 	return config.String()
 }
@@ -795,7 +827,7 @@ func copyFile(src, dst string) error {
 }
 
 func getHostFromCMDB(hostKey string, tomlFile toml.Toml) (*SSHHost, error) {
-	hostData := tomlFile.Get(hostKey)
+	hostData := tomlFile.Get(entryKey(hostKey))
 	if hostData == nil {
 		// Try fuzzy matching if exact match not found
 		matches := findMatchingHostKeys(hostKey, &tomlFile)
@@ -803,7 +835,7 @@ func getHostFromCMDB(hostKey string, tomlFile toml.Toml) (*SSHHost, error) {
 			return nil, fmt.Errorf("host '%s' not found in cmdb", hostKey)
 		} else if len(matches) == 1 {
 			hostKey = matches[0]
-			hostData = tomlFile.Get(hostKey)
+			hostData = tomlFile.Get(entryKey(hostKey))
 		} else {
 			// Multiple matches found, prompt user to select
 			selectedHost, err := promptHostSelection(matches)
@@ -811,10 +843,15 @@ func getHostFromCMDB(hostKey string, tomlFile toml.Toml) (*SSHHost, error) {
 				return nil, fmt.Errorf("failed to select host: %v", err)
 			}
 			hostKey = selectedHost
-			hostData = tomlFile.Get(hostKey)
+			hostData = tomlFile.Get(entryKey(hostKey))
 		}
 	}
 
+	return hostFromData(hostKey, hostData)
+}
+
+// hostFromData reads the host entry hostKey, as returned by Get.
+func hostFromData(hostKey string, hostData interface{}) (*SSHHost, error) {
 	// The Get method returns a *lib.Tree for complex objects
 	var hostMap map[string]interface{}
 
@@ -835,7 +872,11 @@ func getHostFromCMDB(hostKey string, tomlFile toml.Toml) (*SSHHost, error) {
 	if user, ok := hostMap["user"].(string); ok {
 		host.User = user
 	}
-	if port, ok := hostMap["port"].(string); ok {
+	switch port := hostMap["port"].(type) {
+	case int64:
+		host.Port = int(port)
+	case string:
+		// Older entries stored the port as a string.
 		p, _ := strconv.ParseInt(port, 10, 64)
 		host.Port = int(p)
 	}
@@ -857,8 +898,18 @@ func getHostFromCMDB(hostKey string, tomlFile toml.Toml) (*SSHHost, error) {
 	if environment, ok := hostMap["environment"].(string); ok {
 		host.Environment = environment
 	}
-	if tags, ok := hostMap["tags"].(string); ok {
+	switch tags := hostMap["tags"].(type) {
+	case string:
 		host.Tags = tags
+	case []interface{}:
+		// As set by cm set tags='["web","db"]'
+		var list []string
+		for _, tag := range tags {
+			if s, ok := tag.(string); ok {
+				list = append(list, s)
+			}
+		}
+		host.Tags = strings.Join(list, ",")
 	}
 	if forwardAgent, ok := hostMap["forward_agent"].(bool); ok {
 		host.ForwardAgent = forwardAgent
@@ -866,6 +917,24 @@ func getHostFromCMDB(hostKey string, tomlFile toml.Toml) (*SSHHost, error) {
 	if proxyJump, ok := hostMap["proxy_jump"].(string); ok {
 		host.ProxyJump = proxyJump
 	}
+	if options, ok := hostMap["ssh_options"].(map[string]interface{}); ok {
+		host.Options = make(map[string][]string)
+		for keyword, value := range options {
+			switch v := value.(type) {
+			case string:
+				host.Options[keyword] = []string{v}
+			case []interface{}:
+				for _, e := range v {
+					host.Options[keyword] = append(host.Options[keyword], fmt.Sprint(e))
+				}
+			default:
+				host.Options[keyword] = []string{fmt.Sprint(v)}
+			}
+		}
+	}
+	if vars, ok := hostMap["ansible_vars"].(map[string]interface{}); ok {
+		host.AnsibleVars = vars
+	}
 
 	if host.Hostname == "" {
 		return nil, fmt.Errorf("hostname is required for host '%s'", hostKey)
@@ -887,7 +956,29 @@ func saveHostToCMDB(hostKey string, host SSHHost) error {
 	if err != nil {
 		return err
 	}
+	defer tomlFile.Close()
+
+	if err := setHost(&tomlFile, hostKey, host); err != nil {
+		return err
+	}
+
+	if err := checkSchema(&tomlFile); err != nil {
+		return err
+	}
 
+	// Save to file
+	return tomlFile.Write()
+}
+
+// entryKey returns the query addressing the entry name as a single key, so
+// that names like db1.example.com or 10.0.0.5 are not split into tables.
+func entryKey(name string) string {
+	return toml.Path{{Key: name}}.String()
+}
+
+// setHost stores host in tomlFile without writing it.
+func setHost(tomlFile *toml.Toml, hostKey string, host SSHHost) error {
+	key := entryKey(hostKey)
 	// Prepare host data for saving
 	hostMap := make(map[string]interface{})
 	hostMap["hostname"] = host.Hostname
@@ -921,6 +1012,24 @@ func saveHostToCMDB(hostKey string, host SSHHost) error {
 	if host.ProxyJump != "" {
 		hostMap["proxy_jump"] = host.ProxyJump
 	}
+	if len(host.Options) > 0 {
+		options := make(map[string]interface{})
+		for keyword, values := range host.Options {
+			if len(values) == 1 {
+				options[keyword] = values[0]
+				continue
+			}
+			list := make([]interface{}, len(values))
+			for i, v := range values {
+				list[i] = v
+			}
+			options[keyword] = list
+		}
+		hostMap["ssh_options"] = options
+	}
+	if len(host.AnsibleVars) > 0 {
+		hostMap["ansible_vars"] = host.AnsibleVars
+	}
 
 	// Set the host data - need to handle this differently based on the toml package API
 	// Since toml.Set expects (key, attr, value), we'll set each attribute individually
@@ -929,13 +1038,11 @@ func saveHostToCMDB(hostKey string, host SSHHost) error {
 		if attr == "port" {
 			value = int64(value.(int))
 		}
-		if err := tomlFile.Set(hostKey, attr, value); err != nil {
+		if err := tomlFile.Set(key, attr, value); err != nil {
 			return err
 		}
 	}
-
-	// Save to file
-	return tomlFile.Write()
+	return nil
 }
 
 func generatePublicKeyFromPrivate(privateKeyFile string) (string, error) {
@@ -0,0 +1,26 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/MinseokOh/toml-cli/toml"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHostPasswordAsTyped(t *testing.T) {
+	useCmdb(t, "[\"ns:host:web\"]\nhostname = \"web\"\n\n[\"ns:host:db\"]\nhostname = \"db\"\n")
+	rootCmd.SetArgs([]string{"set", "ns:host:web", "password", "0x1F"})
+	require.Nil(t, rootCmd.Execute())
+	rootCmd.SetArgs([]string{"set", "ns:host:db", "password", "123456"})
+	require.Nil(t, rootCmd.Execute())
+
+	tomlFile, err := toml.NewToml(path)
+	require.Nil(t, err)
+	defer tomlFile.Close()
+	host, err := getHostFromCMDB("ns:host:web", tomlFile)
+	require.Nil(t, err)
+	require.Equal(t, "0x1F", host.Password)
+	host, err = getHostFromCMDB("ns:host:db", tomlFile)
+	require.Nil(t, err)
+	require.Equal(t, "123456", host.Password)
+}
@@ -0,0 +1,181 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/MinseokOh/toml-cli/sshconfig"
+	"github.com/MinseokOh/toml-cli/toml"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+var sshImportConfigCmd = &cobra.Command{
+	Use:   "import-config <namespace> [file]",
+	Short: "Import hosts from an OpenSSH client config",
+	Long: `
+Import every host alias of an OpenSSH client config (default ~/.ssh/config)
+as a namespace:host:alias entry. Include directives are followed and the
+options of matching wildcard blocks such as "Host *" apply, as they do for ssh.
+
+HostName, User, Port, IdentityFile, ProxyJump and ForwardAgent become host
+attributes; other options are kept in the ssh_options table of the entry and
+written back by "cm ssh config" and "cm ssh sync".
+
+e.g.
+cm ssh import-config ns
+cm ssh import-config ns ~/work/ssh_config --on-conflict overwrite
+cm ssh import-config ns --dry-run
+`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: runSSHImportConfig,
+}
+
+func init() {
+	addHostImportFlags(sshImportConfigCmd)
+	sshCmd.AddCommand(sshImportConfigCmd)
+}
+
+func runSSHImportConfig(cmd *cobra.Command, args []string) error {
+	configPath := ""
+	if len(args) > 1 {
+		configPath = args[1]
+	} else {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return err
+		}
+		configPath = filepath.Join(home, ".ssh", "config")
+	}
+	config, err := sshconfig.Load(configPath)
+	if err != nil {
+		return err
+	}
+
+	aliases := config.Hosts()
+	hosts := make(map[string]SSHHost, len(aliases))
+	for _, alias := range aliases {
+		hosts[alias] = sshHostFromConfig(alias, config.Options(alias))
+	}
+	return importHosts(cmd, args[0], aliases, hosts, configPath)
+}
+
+// addHostImportFlags adds the flags of the commands that import hosts.
+func addHostImportFlags(cmd *cobra.Command) {
+	cmd.Flags().String("on-conflict", toml.ImportSkip, "what to do with existing hosts: skip, overwrite or fail")
+	cmd.Flags().Bool("dry-run", false, "print the resulting changes without writing")
+}
+
+// importHosts stores hosts as namespace:host:alias entries in the order of
+// aliases, as the --on-conflict and --dry-run flags of cmd say.
+func importHosts(cmd *cobra.Command, namespace string, aliases []string, hosts map[string]SSHHost, source string) error {
+	namespace = strings.TrimSuffix(namespace, ":")
+	policy, err := cmd.Flags().GetString("on-conflict")
+	if err != nil {
+		return err
+	}
+	if policy != toml.ImportSkip && policy != toml.ImportOverwrite && policy != toml.ImportFail {
+		return fmt.Errorf("unknown conflict policy %q, expected skip, overwrite or fail", policy)
+	}
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	if err != nil {
+		return err
+	}
+
+	tomlFile, err := toml.NewToml(path)
+	if err != nil {
+		return err
+	}
+	defer tomlFile.Close()
+
+	var existing, skipped []string
+	imported := 0
+	for _, alias := range aliases {
+		hostKey := namespace + ":host:" + alias
+		if tomlFile.Get(entryKey(hostKey)) != nil {
+			existing = append(existing, hostKey)
+			if policy != toml.ImportOverwrite {
+				skipped = append(skipped, hostKey)
+				continue
+			}
+			if err := tomlFile.Clear(entryKey(hostKey)); err != nil {
+				return err
+			}
+		}
+		if err := setHost(&tomlFile, hostKey, hosts[alias]); err != nil {
+			return err
+		}
+		imported++
+	}
+	if policy == toml.ImportFail && len(existing) > 0 {
+		return fmt.Errorf("%d hosts already exist: %s", len(existing), strings.Join(existing, ", "))
+	}
+
+	if dryRun {
+		changes, err := tomlFile.Changes()
+		if err != nil {
+			return err
+		}
+		return printChanges(changes, formatHuman)
+	}
+	if err := checkSchema(&tomlFile); err != nil {
+		return err
+	}
+	if err := tomlFile.Write(); err != nil {
+		return err
+	}
+	for _, hostKey := range skipped {
+		color.Yellow("Skipped existing host '%s'", hostKey)
+	}
+	color.Green("Imported %d hosts from %s", imported, source)
+	return nil
+}
+
+// sshHostFromConfig maps the ssh_config options of alias onto an SSHHost.
+func sshHostFromConfig(alias string, options []sshconfig.Option) SSHHost {
+	host := SSHHost{Hostname: alias, User: "root", Port: 22}
+	for _, o := range options {
+		switch strings.ToLower(o.Keyword) {
+		case "hostname":
+			host.Hostname = expandHostToken(o.Value, alias)
+			continue
+		case "user":
+			host.User = o.Value
+			continue
+		case "port":
+			if port, err := strconv.Atoi(o.Value); err == nil {
+				host.Port = port
+				continue
+			}
+		case "identityfile":
+			if host.KeyPath == "" {
+				host.KeyPath = o.Value
+				continue
+			}
+		case "proxyjump":
+			host.ProxyJump = o.Value
+			continue
+		case "forwardagent":
+			switch strings.ToLower(o.Value) {
+			case "yes":
+				host.ForwardAgent = true
+				continue
+			case "no":
+				continue
+			}
+		}
+		if host.Options == nil {
+			host.Options = make(map[string][]string)
+		}
+		host.Options[o.Keyword] = append(host.Options[o.Keyword], o.Value)
+	}
+	return host
+}
+
+// expandHostToken replaces the %h token of a HostName with the alias.
+func expandHostToken(hostname, alias string) string {
+	return strings.NewReplacer("%h", alias, "%%", "%").Replace(hostname)
+}
@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/require"
+)
+
+// useCmdb points the commands at a new cmdb with content.
+func useCmdb(t *testing.T, content string) string {
+	old := path
+	t.Cleanup(func() { path = old })
+	path = filepath.Join(t.TempDir(), "cmdb.toml")
+	require.Nil(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestImportDottedAliases(t *testing.T) {
+	cmdb := useCmdb(t, "")
+	aliases := []string{"db1.example.com", "10.0.0.5"}
+	hosts := map[string]SSHHost{
+		"db1.example.com": {Hostname: "db1.example.com", User: "root", Port: 22},
+		"10.0.0.5":        {Hostname: "10.0.0.5", User: "admin", Port: 2222},
+	}
+	cmd := &cobra.Command{}
+	addHostImportFlags(cmd)
+	require.Nil(t, importHosts(cmd, "ns", aliases, hosts, "config"))
+
+	data, err := os.ReadFile(cmdb)
+	require.Nil(t, err)
+	require.Contains(t, string(data), `["ns:host:db1.example.com"]`)
+	require.Contains(t, string(data), `["ns:host:10.0.0.5"]`)
+	require.NotContains(t, string(data), `["ns:host:db1".example]`)
+
+	// The hosts are found again, so a second import skips them.
+	require.Nil(t, cmd.Flags().Set("on-conflict", "fail"))
+	require.NotNil(t, importHosts(cmd, "ns", aliases, hosts, "config"))
+}
@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/MinseokOh/toml-cli/schema"
+	"github.com/MinseokOh/toml-cli/toml"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+var schemaPath string
+
+// ValidateTomlCommand returns validate command
+func ValidateTomlCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "validate",
+		Short: "Check the cmdb against its schema",
+		Long: `
+Check every entry of the cmdb against the schema file (by default
+cmdb.schema.toml next to the cmdb file, or --schema).
+
+e.g.
+cm validate
+cm validate --schema team.schema.toml
+
+Schema example:
+  enforce = true        # make set, del, rename, merge and ssh add refuse
+                        # writes that introduce violations
+
+  [[rule]]
+  pattern  = "*:host:*" # a query, see "cm get --help"
+  required = ["hostname"]
+  closed   = true       # reject attributes that are not declared
+
+  [rule.attrs.port]
+  type = "int"
+  min  = 1
+  max  = 65535
+
+  [rule.attrs.environment]
+  enum = ["dev", "staging", "prod"]
+`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			s, err := loadSchema()
+			if err != nil {
+				return err
+			}
+			if s == nil {
+				return fmt.Errorf("no schema found at %s", schemaFile())
+			}
+			tomlFile, err := toml.NewToml(path)
+			if err != nil {
+				return err
+			}
+			defer tomlFile.Close()
+			violations, err := s.Validate(&tomlFile)
+			if err != nil {
+				return err
+			}
+			for _, v := range violations {
+				fmt.Println(v)
+			}
+			if len(violations) > 0 {
+				return fmt.Errorf("%d schema violations", len(violations))
+			}
+			color.Green("%s is valid", path)
+			return nil
+		},
+	}
+	return cmd
+}
+
+func schemaFile() string {
+	if schemaPath != "" {
+		return schemaPath
+	}
+	return schema.DefaultPath(path)
+}
+
+// loadSchema returns the schema in effect, or nil if there is none.
+func loadSchema() (*schema.Schema, error) {
+	s, err := schema.Load(schemaFile())
+	if os.IsNotExist(err) && schemaPath == "" {
+		return nil, nil
+	}
+	return s, err
+}
+
+// checkSchema refuses a pending write when the schema is enforced and the
+// changes made to t introduce violations.
+func checkSchema(t *toml.Toml) error {
+	s, err := loadSchema()
+	if err != nil || s == nil || !s.Enforce {
+		return err
+	}
+	violations, err := s.Check(t)
+	if err != nil || len(violations) == 0 {
+		return err
+	}
+	msgs := make([]string, len(violations))
+	for i, v := range violations {
+		msgs[i] = "  " + v.String()
+	}
+	return fmt.Errorf("refusing to write, schema violations:\n%s", strings.Join(msgs, "\n"))
+}
@@ -0,0 +1,65 @@
+package encrypt
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// Binding identifies an encrypted file and its revision. From
+// versionBinding on it is authenticated, with the rest of the header, as the
+// associated data of the content: it cannot be edited, nor the ciphertext
+// moved under another header, without decryption failing.
+type Binding struct {
+	// ID is the logical file ID, random and kept across writes.
+	ID string `json:"id,omitempty"`
+	// Counter increases with every write of the file.
+	Counter uint64 `json:"counter,omitempty"`
+}
+
+// NewBinding returns the binding of a newly encrypted file: a new ID at the
+// first revision.
+func NewBinding() (Binding, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return Binding{}, fmt.Errorf("failed to generate file ID: %w", err)
+	}
+	return Binding{ID: hex.EncodeToString(id), Counter: 1}, nil
+}
+
+// Next returns the binding of the revision written after b. Files written
+// before bindings get a new ID.
+func (b Binding) Next() (Binding, error) {
+	if b.ID == "" {
+		return NewBinding()
+	}
+	b.Counter++
+	return b, nil
+}
+
+// ReadBinding returns the binding of encrypted data, empty for files written
+// before bindings.
+func ReadBinding(data []byte) (Binding, error) {
+	encryptData, err := ParseEncryptData(data)
+	if err != nil {
+		return Binding{}, err
+	}
+	return encryptData.Binding, nil
+}
+
+// aad returns the associated data of the content of d: the magic line and the
+// header, that is d without its nonce and ciphertext. Earlier versions have
+// none.
+func (d *EncryptData) aad() ([]byte, error) {
+	if d.Version < versionBinding {
+		return nil, nil
+	}
+	header := *d
+	header.Nonce, header.Ciphertext = "", ""
+	data, err := json.Marshal(header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal header: %w", err)
+	}
+	return append([]byte(Magic), data...), nil
+}
@@ -0,0 +1,61 @@
+package encrypt
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBindingIsAuthenticated(t *testing.T) {
+	enc, err := EncryptKDF([]byte("a = 1\n"), "pw", KDFPBKDF2)
+	require.Nil(t, err)
+	binding, err := ReadBinding([]byte(enc))
+	require.Nil(t, err)
+	require.NotEmpty(t, binding.ID)
+	require.Equal(t, uint64(1), binding.Counter)
+
+	next, err := binding.Next()
+	require.Nil(t, err)
+	require.Equal(t, Binding{ID: binding.ID, Counter: 2}, next)
+
+	// Any change to the header fails decryption.
+	for _, tamper := range []func(d *EncryptData){
+		func(d *EncryptData) { d.Counter = 7 },
+		func(d *EncryptData) { d.ID = "other" },
+		func(d *EncryptData) { d.KDF.Iterations++ },
+	} {
+		d, err := ParseEncryptData([]byte(enc))
+		require.Nil(t, err)
+		tamper(d)
+		changed, err := d.encode()
+		require.Nil(t, err)
+		_, err = Decrypt(changed, "pw")
+		require.NotNil(t, err)
+	}
+}
+
+func TestDecryptEnvelopeWithoutBinding(t *testing.T) {
+	kdf, err := NewKDF(KDFPBKDF2)
+	require.Nil(t, err)
+	key, err := kdf.Derive("pw")
+	require.Nil(t, err)
+	d := EncryptData{Version: versionEnvelope, KDF: kdf, Cipher: CipherAES256GCM}
+	require.Nil(t, d.seal(key, []byte("a = 1\n")))
+	data, err := json.Marshal(d)
+	require.Nil(t, err)
+	enc := Magic + string(data) + "\n"
+
+	plain, err := Decrypt(enc, "pw")
+	require.Nil(t, err)
+	require.Equal(t, "a = 1\n", string(plain))
+	binding, err := ReadBinding([]byte(enc))
+	require.Nil(t, err)
+	require.Empty(t, binding.ID)
+
+	next, err := binding.Next()
+	require.Nil(t, err)
+	require.NotEmpty(t, next.ID)
+	require.False(t, strings.Contains(enc, `"id"`))
+}
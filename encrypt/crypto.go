@@ -1,51 +1,57 @@
 package encrypt
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
+	"bufio"
+	"bytes"
 	"crypto/rand"
-	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"os"
-	"path/filepath"
 	"strings"
-	"syscall"
-	"time"
 
-	"golang.org/x/crypto/pbkdf2"
 	"golang.org/x/term"
 )
 
 const (
-	CMDBRCFile    = ".cmdbrc"
 	SaltSize      = 16
 	NonceSize     = 12
 	KeyIterations = 100000
-	ExpiryMinutes = 10
 )
 
-// PasswordData stores password hash with timestamp
-type PasswordData struct {
-	Password   string `json:"password_hash"`
-	LastAccess int64  `json:"last_access"`
-}
+// Magic starts the content of files in the versioned envelope format: the
+// magic line, then the EncryptData as JSON. Earlier files are bare JSON.
+const Magic = "CMDB-ENCRYPTED\n"
 
-// EncryptData represents encrypted file content
-type EncryptData struct {
-	Nonce      string `json:"nonce"`
-	Ciphertext string `json:"ciphertext"`
-	Salt       string `json:"salt"`
-}
+const (
+	// FormatVersion is the version of the envelope written.
+	FormatVersion = 4
+	// versionEnvelope is the first version of the envelope.
+	versionEnvelope = 3
+	// versionBinding is the first version authenticating the header and
+	// Binding of the file with its content.
+	versionBinding = 4
+	// CipherAES256GCM is the cipher of the content.
+	CipherAES256GCM = "aes-256-gcm"
+)
 
-// deriveKey derives encryption key from password using PBKDF2
-func deriveKey(password, salt []byte) []byte {
-	return pbkdf2.Key(password, salt, KeyIterations, 32, sha256.New)
+// EncryptData represents encrypted file content.
+// Version 1 files have no version and are keyed by a password through Salt
+// with PBKDF2; VersionRecipients files are keyed by a file key wrapped for
+// Recipients. FormatVersion files describe the KDF of the password, or have
+// recipients, and name their cipher; from versionBinding on they carry a
+// Binding authenticated with the content.
+type EncryptData struct {
+	Version    int         `json:"version,omitempty"`
+	KDF        *KDF        `json:"kdf,omitempty"`
+	Cipher     string      `json:"cipher,omitempty"`
+	Nonce      string      `json:"nonce"`
+	Ciphertext string      `json:"ciphertext"`
+	Salt       string      `json:"salt,omitempty"`
+	Recipients []Recipient `json:"recipients,omitempty"`
+	Binding
 }
 
-
 // generateSalt generates a random salt
 func generateSalt() ([]byte, error) {
 	salt := make([]byte, SaltSize)
@@ -60,206 +66,254 @@ func generateNonce() ([]byte, error) {
 	return nonce, err
 }
 
-// Encrypt encrypts data using AES-256-GCM
+// Encrypt encrypts data using AES-256-GCM, with a key derived from password
+// by DefaultKDF
 func Encrypt(data []byte, password string) (string, error) {
-	salt, err := generateSalt()
-	if err != nil {
-		return "", fmt.Errorf("failed to generate salt: %w", err)
-	}
+	return EncryptKDF(data, password, DefaultKDF)
+}
 
-	nonce, err := generateNonce()
+// EncryptKDF encrypts data with a key derived from password by the KDF name.
+func EncryptKDF(data []byte, password, name string) (string, error) {
+	kdf, err := NewKDF(name)
 	if err != nil {
-		return "", fmt.Errorf("failed to generate nonce: %w", err)
+		return "", err
 	}
-
-	key := deriveKey([]byte(password), salt)
-
-	block, err := aes.NewCipher(key)
+	key, err := kdf.Derive(password)
 	if err != nil {
-		return "", fmt.Errorf("failed to create cipher: %w", err)
+		return "", err
 	}
-
-	aesgcm, err := cipher.NewGCM(block)
+	binding, err := NewBinding()
 	if err != nil {
-		return "", fmt.Errorf("failed to create GCM: %w", err)
-	}
-
-	ciphertext := aesgcm.Seal(nil, nonce, data, nil)
-
-	encryptData := EncryptData{
-		Nonce:      base64.StdEncoding.EncodeToString(nonce),
-		Ciphertext: base64.StdEncoding.EncodeToString(ciphertext),
-		Salt:       base64.StdEncoding.EncodeToString(salt),
+		return "", err
 	}
+	return EncryptWithKey(data, key, kdf, binding)
+}
 
-	jsonData, err := json.Marshal(encryptData)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal encrypted data: %w", err)
+// EncryptWithKey encrypts data with a key already derived by kdf, see
+// PasswordKDF, as the revision of the file described by binding.
+func EncryptWithKey(data, key []byte, kdf *KDF, binding Binding) (string, error) {
+	encryptData := EncryptData{Version: FormatVersion, KDF: kdf, Cipher: CipherAES256GCM, Binding: binding}
+	if err := encryptData.seal(key, data); err != nil {
+		return "", err
 	}
-
-	return string(jsonData), nil
+	return encryptData.encode()
 }
 
 // Decrypt decrypts data using AES-256-GCM
 func Decrypt(encryptedData string, password string) ([]byte, error) {
-	var encryptData EncryptData
-	err := json.Unmarshal([]byte(encryptedData), &encryptData)
+	kdf, err := PasswordKDF([]byte(encryptedData))
 	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal encrypted data: %w", err)
+		return nil, err
 	}
 
-	nonce, err := base64.StdEncoding.DecodeString(encryptData.Nonce)
+	key, err := kdf.Derive(password)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode nonce: %w", err)
+		return nil, err
 	}
+	return DecryptWithKey(encryptedData, key)
+}
 
-	ciphertext, err := base64.StdEncoding.DecodeString(encryptData.Ciphertext)
+// PasswordKDF returns how the key of a password encrypted file is derived.
+func PasswordKDF(encryptedData []byte) (*KDF, error) {
+	encryptData, err := ParseEncryptData(encryptedData)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode ciphertext: %w", err)
+		return nil, err
+	}
+	if encryptData.HasRecipients() {
+		return nil, errors.New("file is encrypted to recipients, not with a password")
+	}
+	if encryptData.KDF != nil {
+		return encryptData.KDF, encryptData.KDF.Check()
 	}
 
 	salt, err := base64.StdEncoding.DecodeString(encryptData.Salt)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode salt: %w", err)
 	}
+	return LegacyKDF(salt), nil
+}
 
-	key := deriveKey([]byte(password), salt)
-
-	block, err := aes.NewCipher(key)
+// DecryptWithKey decrypts data with a key already derived from the password,
+// see PasswordKDF.
+func DecryptWithKey(encryptedData string, key []byte) ([]byte, error) {
+	encryptData, err := ParseEncryptData([]byte(encryptedData))
 	if err != nil {
-		return nil, fmt.Errorf("failed to create cipher: %w", err)
+		return nil, err
 	}
+	return encryptData.open(key)
+}
 
-	aesgcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create GCM: %w", err)
+// ParseEncryptData returns the header and content of an encrypted file, in
+// the envelope format or an earlier one.
+func ParseEncryptData(data []byte) (*EncryptData, error) {
+	var encryptData EncryptData
+	if bytes.HasPrefix(data, []byte(Magic)) {
+		if err := json.Unmarshal(data[len(Magic):], &encryptData); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal encrypted data: %w", err)
+		}
+		if encryptData.Version < versionEnvelope || encryptData.Version > FormatVersion {
+			return nil, fmt.Errorf("unsupported encrypted file version %d, upgrade cm", encryptData.Version)
+		}
+		if encryptData.Cipher != CipherAES256GCM {
+			return nil, fmt.Errorf("unsupported cipher %q", encryptData.Cipher)
+		}
+		return &encryptData, nil
 	}
 
-	plaintext, err := aesgcm.Open(nil, nonce, ciphertext, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decrypt: %w", err)
+	if err := json.Unmarshal(bytes.TrimSpace(data), &encryptData); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal encrypted data: %w", err)
+	}
+	if encryptData.Version != 0 && encryptData.Version != VersionRecipients {
+		return nil, fmt.Errorf("unsupported encrypted file version %d", encryptData.Version)
+	}
+	if encryptData.Nonce == "" || encryptData.Ciphertext == "" {
+		return nil, errors.New("not an encrypted file")
 	}
+	return &encryptData, nil
+}
 
-	return plaintext, nil
+// HasRecipients reports whether the file is encrypted to recipients rather
+// than with a password.
+func (d *EncryptData) HasRecipients() bool {
+	return d.Version == VersionRecipients || len(d.Recipients) > 0
 }
 
-// GetCMDBRCPath returns the path to .cmdbrc file
-func GetCMDBRCPath() string {
-	home, err := os.UserHomeDir()
+// encode returns the file content for d.
+func (d *EncryptData) encode() (string, error) {
+	jsonData, err := json.Marshal(d)
 	if err != nil {
-		return CMDBRCFile
+		return "", fmt.Errorf("failed to marshal encrypted data: %w", err)
 	}
-	return filepath.Join(home, CMDBRCFile)
+	if d.Version < FormatVersion {
+		return string(jsonData), nil
+	}
+	return Magic + string(jsonData) + "\n", nil
 }
 
-// SavePassword saves password hash to .cmdbrc file
-func SavePassword(password string) error {
-	passwordData := PasswordData{
-		Password:   password,
-		LastAccess: time.Now().Unix(),
+// seal encrypts data with key into d, authenticating the header of d which
+// must be complete.
+func (d *EncryptData) seal(key, data []byte) error {
+	nonce, err := generateNonce()
+	if err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
 	}
 
-	jsonData, err := json.Marshal(passwordData)
+	aesgcm, err := newGCM(key)
 	if err != nil {
-		return fmt.Errorf("failed to marshal password data: %w", err)
+		return err
 	}
-
-	path := GetCMDBRCPath()
-	err = os.WriteFile(path, jsonData, 0600)
+	aad, err := d.aad()
 	if err != nil {
-		return fmt.Errorf("failed to write password file: %w", err)
+		return err
 	}
 
+	d.Nonce = base64.StdEncoding.EncodeToString(nonce)
+	d.Ciphertext = base64.StdEncoding.EncodeToString(aesgcm.Seal(nil, nonce, data, aad))
 	return nil
 }
 
+// open decrypts the content of d with key.
+func (d *EncryptData) open(key []byte) ([]byte, error) {
+	nonce, err := base64.StdEncoding.DecodeString(d.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode nonce: %w", err)
+	}
 
-// ReadPasswordFile reads password data if file exists and is not expired
-func ReadPasswordFile() (*PasswordData, error) {
-	path := GetCMDBRCPath()
-
-	// Check if file exists
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		return nil, errors.New("password file not found")
+	ciphertext, err := base64.StdEncoding.DecodeString(d.Ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode ciphertext: %w", err)
 	}
 
-	// Read the file
-	data, err := os.ReadFile(path)
+	aesgcm, err := newGCM(key)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read password file: %w", err)
+		return nil, err
+	}
+	aad, err := d.aad()
+	if err != nil {
+		return nil, err
 	}
 
-	var passwordData PasswordData
-	err = json.Unmarshal(data, &passwordData)
+	plaintext, err := aesgcm.Open(nil, nonce, ciphertext, aad)
 	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal password data: %w", err)
+		return nil, fmt.Errorf("failed to decrypt: %w", err)
 	}
-	lastAccessTime := time.Unix(passwordData.LastAccess, 0)
-	if time.Since(lastAccessTime) > time.Duration(10)*time.Minute {
-		// Remove expired file
-		os.Remove(path)
-		return nil, errors.New("password expired")
+
+	return plaintext, nil
+}
+
+// IsEncrypted checks if data is an encrypted file: in the envelope format,
+// or JSON with the fields of an earlier format. Plaintext TOML is never
+// mistaken for it, whatever keys it has.
+func IsEncrypted(data []byte) bool {
+	if bytes.HasPrefix(data, []byte(Magic)) {
+		return true
 	}
+	_, err := ParseEncryptData(data)
+	return err == nil
+}
 
-	return &passwordData, nil
+// IsLegacyFormat reports whether data is encrypted in a format earlier than
+// the envelope, see "cm encrypt --upgrade".
+func IsLegacyFormat(data []byte) bool {
+	return IsEncrypted(data) && !bytes.HasPrefix(data, []byte(Magic))
 }
 
-// UpdateLastAccess updates the last access time in the password file
-func UpdateLastAccess() {
-	path := GetCMDBRCPath()
+// PromptPassword prompts user to enter password on the terminal, not on
+// stdin which may be a pipe. It fails when NoPrompt is set.
+func PromptPassword(confirm bool) (string, error) {
+	return promptPassword("Enter password for cmdb file: ", confirm)
+}
 
-	// Read current data
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return
-	}
+// PromptNewPassword prompts user to enter a new password twice.
+func PromptNewPassword() (string, error) {
+	return promptPassword("Enter new password for cmdb file: ", true)
+}
 
-	var passwordData PasswordData
-	if err := json.Unmarshal(data, &passwordData); err != nil {
-		return
+// Confirm asks question on the terminal and reports whether it was answered
+// yes. Nothing is asked, and the answer is no, when NoPrompt is set.
+func Confirm(question string) (bool, error) {
+	if NoPrompt {
+		return false, nil
 	}
-
-	// Update last access time
-	passwordData.LastAccess = time.Now().Unix()
-	jsonData, err := json.Marshal(passwordData)
+	tty, err := openTerminal()
 	if err != nil {
-		return
+		return false, errors.New("no terminal to confirm on")
 	}
+	defer tty.close()
 
-	os.WriteFile(path, jsonData, 0600)
-}
-
-// ClearPassword removes the .cmdbrc file
-func ClearPassword() error {
-	path := GetCMDBRCPath()
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		return nil
+	fmt.Fprintf(tty.out, "%s (y/N): ", question)
+	answer, err := bufio.NewReader(tty.in).ReadString('\n')
+	if err != nil {
+		return false, err
 	}
-	return os.Remove(path)
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes", nil
 }
 
-// IsEncrypted checks if data is encrypted by looking for JSON structure
-func IsEncrypted(data []byte) bool {
-	trimmed := strings.TrimSpace(string(data))
-	return strings.HasPrefix(trimmed, "{") && strings.Contains(trimmed, "ciphertext")
-}
+func promptPassword(label string, confirm bool) (string, error) {
+	if NoPrompt {
+		return "", ErrNoPassword
+	}
+	tty, err := openTerminal()
+	if err != nil {
+		return "", err
+	}
+	defer tty.close()
 
-// PromptPassword prompts user to enter password
-func PromptPassword(confirm bool) (string, error) {
-	fmt.Print("Enter password for cmdb file: ")
-	password, err := term.ReadPassword(int(syscall.Stdin))
+	fmt.Fprint(tty.out, label)
+	password, err := term.ReadPassword(int(tty.in.Fd()))
 	if err != nil {
 		return "", err
 	}
-	fmt.Println() // New line after password input
+	fmt.Fprintln(tty.out) // New line after password input
 
 	if confirm {
-		fmt.Print("Confirm password: ")
-		confirmPassword, err := term.ReadPassword(int(syscall.Stdin))
+		fmt.Fprint(tty.out, "Confirm password: ")
+		confirmPassword, err := term.ReadPassword(int(tty.in.Fd()))
 		if err != nil {
 			return "", err
 		}
-		fmt.Println() // New line after password input
+		fmt.Fprintln(tty.out) // New line after password input
 
 		if string(password) != string(confirmPassword) {
 			return "", errors.New("passwords do not match")
@@ -0,0 +1,74 @@
+package encrypt
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+func TestEncryptKDF(t *testing.T) {
+	data := []byte("a = 1\n")
+	for _, name := range KDFNames() {
+		enc, err := EncryptKDF(data, "pw", name)
+		require.Nil(t, err, name)
+		require.True(t, strings.HasPrefix(enc, Magic), name)
+		require.True(t, IsEncrypted([]byte(enc)), name)
+		require.False(t, IsLegacyFormat([]byte(enc)), name)
+
+		kdf, err := PasswordKDF([]byte(enc))
+		require.Nil(t, err, name)
+		require.Equal(t, name, kdf.Name)
+
+		plain, err := Decrypt(enc, "pw")
+		require.Nil(t, err, name)
+		require.Equal(t, data, plain)
+		_, err = Decrypt(enc, "wrong")
+		require.NotNil(t, err, name)
+	}
+	_, err := EncryptKDF(data, "pw", "md5")
+	require.NotNil(t, err)
+}
+
+func TestDecryptLegacy(t *testing.T) {
+	salt := []byte("0123456789abcdef")
+	key := pbkdf2.Key([]byte("pw"), salt, KeyIterations, 32, sha256.New)
+	d := EncryptData{Salt: base64.StdEncoding.EncodeToString(salt)}
+	require.Nil(t, d.seal(key, []byte("a = 1\n")))
+	legacy, err := json.Marshal(d)
+	require.Nil(t, err)
+
+	require.True(t, IsEncrypted(legacy))
+	require.True(t, IsLegacyFormat(legacy))
+	plain, err := Decrypt(string(legacy), "pw")
+	require.Nil(t, err)
+	require.Equal(t, "a = 1\n", string(plain))
+}
+
+func TestIsEncrypted(t *testing.T) {
+	for _, doc := range []string{
+		"ciphertext = \"x\"\nnonce = \"y\"\n",
+		"a = { ciphertext = \"x\" }\n",
+		"{\"ciphertext\": \"\"}",
+		"",
+	} {
+		require.False(t, IsEncrypted([]byte(doc)), doc)
+	}
+}
+
+func TestKDFCheck(t *testing.T) {
+	kdf, err := NewKDF(KDFArgon2id)
+	require.Nil(t, err)
+	require.Nil(t, kdf.Check())
+	kdf.Memory = 1 << 30
+	require.NotNil(t, kdf.Check())
+
+	kdf, err = NewKDF(KDFScrypt)
+	require.Nil(t, err)
+	kdf.N = 1000
+	require.NotNil(t, kdf.Check())
+}
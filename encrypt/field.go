@@ -0,0 +1,71 @@
+package encrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// FieldPrefix starts the values encrypted one by one in a plaintext file.
+const FieldPrefix = "enc:v1:"
+
+// ErrWrongPassword is returned when a key does not open a field.
+var ErrWrongPassword = errors.New("wrong password")
+
+// IsEncryptedField reports whether s is a value encrypted by EncryptField.
+func IsEncryptedField(s string) bool {
+	return strings.HasPrefix(s, FieldPrefix)
+}
+
+// EncryptField encrypts a single value with AES-256-GCM under key. The
+// result is FieldPrefix followed by the base64 nonce and ciphertext.
+func EncryptField(key, plaintext []byte) (string, error) {
+	aesgcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce, err := generateNonce()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := aesgcm.Seal(nonce, nonce, plaintext, nil)
+	return FieldPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptField decrypts a value encrypted by EncryptField.
+func DecryptField(key []byte, value string) ([]byte, error) {
+	if !IsEncryptedField(value) {
+		return nil, fmt.Errorf("not an encrypted field")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, FieldPrefix))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode field: %w", err)
+	}
+	if len(sealed) < NonceSize {
+		return nil, fmt.Errorf("encrypted field too short")
+	}
+	aesgcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aesgcm.Open(nil, sealed[:NonceSize], sealed[NonceSize:], nil)
+	if err != nil {
+		return nil, ErrWrongPassword
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	aesgcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+	return aesgcm, nil
+}
@@ -0,0 +1,121 @@
+package encrypt
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Key derivation functions of password encrypted files.
+const (
+	KDFPBKDF2   = "pbkdf2"
+	KDFScrypt   = "scrypt"
+	KDFArgon2id = "argon2id"
+
+	// DefaultKDF derives the keys of newly encrypted files.
+	DefaultKDF = KDFArgon2id
+)
+
+// Limits on the parameters read from a file, so that a crafted header cannot
+// make deriving its key take forever or exhaust memory.
+const (
+	maxIterations = 10000000
+	maxScryptN    = 1 << 22
+	maxMemoryKiB  = 4 << 20
+	maxTime       = 64
+)
+
+// KDF describes how the key of a file is derived from its password.
+type KDF struct {
+	Name string `json:"name"`
+	Salt []byte `json:"salt"`
+
+	// PBKDF2-HMAC-SHA256
+	Iterations int `json:"iterations,omitempty"`
+	// scrypt
+	N int `json:"n,omitempty"`
+	R int `json:"r,omitempty"`
+	P int `json:"p,omitempty"`
+	// argon2id; Memory is in KiB
+	Time    uint32 `json:"time,omitempty"`
+	Memory  uint32 `json:"memory,omitempty"`
+	Threads uint8  `json:"threads,omitempty"`
+}
+
+// KDFNames lists the supported key derivation functions.
+func KDFNames() []string {
+	return []string{KDFArgon2id, KDFScrypt, KDFPBKDF2}
+}
+
+// NewKDF returns the KDF name with the recommended parameters and a new salt.
+func NewKDF(name string) (*KDF, error) {
+	salt, err := generateSalt()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	var k *KDF
+	switch name {
+	case KDFPBKDF2:
+		k = &KDF{Name: name, Iterations: 600000}
+	case KDFScrypt:
+		k = &KDF{Name: name, N: 1 << 15, R: 8, P: 1}
+	case KDFArgon2id:
+		k = &KDF{Name: name, Time: 3, Memory: 64 * 1024, Threads: 4}
+	default:
+		return nil, fmt.Errorf("unknown KDF %q, use one of %v", name, KDFNames())
+	}
+	k.Salt = salt
+	return k, nil
+}
+
+// LegacyKDF is the key derivation of files written before the KDF was
+// recorded: PBKDF2 with KeyIterations.
+func LegacyKDF(salt []byte) *KDF {
+	return &KDF{Name: KDFPBKDF2, Salt: salt, Iterations: KeyIterations}
+}
+
+// ID identifies the key the KDF derives for a password, e.g. in the agent.
+func (k *KDF) ID() string {
+	return base64.StdEncoding.EncodeToString(k.Salt)
+}
+
+// Check returns an error if the parameters are unknown or out of bounds.
+func (k *KDF) Check() error {
+	if len(k.Salt) == 0 {
+		return fmt.Errorf("%s: missing salt", k.Name)
+	}
+	ok := false
+	switch k.Name {
+	case KDFPBKDF2:
+		ok = k.Iterations > 0 && k.Iterations <= maxIterations
+	case KDFScrypt:
+		ok = k.N > 1 && k.N <= maxScryptN && k.N&(k.N-1) == 0 && k.R > 0 && k.P > 0 && k.R*k.P < 1<<10
+	case KDFArgon2id:
+		ok = k.Time > 0 && k.Time <= maxTime && k.Memory >= 8 && k.Memory <= maxMemoryKiB && k.Threads > 0
+	default:
+		return fmt.Errorf("unknown KDF %q", k.Name)
+	}
+	if !ok {
+		return fmt.Errorf("%s: invalid parameters", k.Name)
+	}
+	return nil
+}
+
+// Derive derives the 256-bit key of password.
+func (k *KDF) Derive(password string) ([]byte, error) {
+	if err := k.Check(); err != nil {
+		return nil, err
+	}
+	switch k.Name {
+	case KDFPBKDF2:
+		return pbkdf2.Key([]byte(password), k.Salt, k.Iterations, 32, sha256.New), nil
+	case KDFScrypt:
+		return scrypt.Key([]byte(password), k.Salt, k.N, k.R, k.P, 32)
+	default:
+		return argon2.IDKey([]byte(password), k.Salt, k.Time, k.Memory, k.Threads, 32), nil
+	}
+}
@@ -0,0 +1,113 @@
+package encrypt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"golang.org/x/term"
+)
+
+// PasswordEnv is the environment variable holding the password of the cmdb.
+const PasswordEnv = "CMDB_PASSWORD"
+
+// The sources of the password besides PasswordEnv and the prompt, in the
+// order ReadPassword tries them. They are set from the command line.
+var (
+	// PasswordFile is a file holding the password.
+	PasswordFile string
+	// PasswordCommand is a shell command printing the password.
+	PasswordCommand string
+	// NoPrompt makes prompting for a password fail instead.
+	NoPrompt bool
+)
+
+// ErrNoPassword is returned when a password is needed but none of its sources
+// is set and prompting is disabled.
+var ErrNoPassword = errors.New("a password is needed and --no-prompt is set: set " + PasswordEnv + ", --password-file or --password-command")
+
+// ReadPassword returns the password from the first source that is set:
+// PasswordEnv, PasswordFile, PasswordCommand, or else the user is prompted
+// on the terminal, twice with confirm.
+func ReadPassword(confirm bool) (string, error) {
+	legacyOnce.Do(RemoveLegacyPasswordFile)
+	if password, ok := os.LookupEnv(PasswordEnv); ok {
+		return password, nil
+	}
+	if PasswordFile != "" {
+		data, err := os.ReadFile(PasswordFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read password file: %w", err)
+		}
+		return trimNewline(string(data)), nil
+	}
+	if PasswordCommand != "" {
+		return runPasswordCommand(PasswordCommand)
+	}
+	return PromptPassword(confirm)
+}
+
+var legacyOnce sync.Once
+
+// RemoveLegacyPasswordFile removes the ~/.cmdbrc earlier versions kept the
+// password in. ReadPassword does so the first time a password is needed.
+func RemoveLegacyPasswordFile() {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return
+	}
+	if err := os.Remove(filepath.Join(home, ".cmdbrc")); err == nil {
+		fmt.Fprintln(os.Stderr, "Removed the password file ~/.cmdbrc, passwords are now kept by \"cm agent\"")
+	}
+}
+
+// runPasswordCommand runs command with the shell and returns its output.
+func runPasswordCommand(command string) (string, error) {
+	cmd := exec.Command("sh", "-c", command)
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	}
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("password command failed: %w", err)
+	}
+	password := trimNewline(out.String())
+	if password == "" {
+		return "", errors.New("password command printed no password")
+	}
+	return password, nil
+}
+
+// trimNewline removes the line ending files and commands end with.
+func trimNewline(s string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(s, "\n"), "\r")
+}
+
+// terminal is where the user is prompted for a password.
+type terminal struct {
+	in    *os.File
+	out   io.Writer
+	close func() error
+}
+
+// openTerminal opens the controlling terminal, so that prompting works when
+// stdin and stdout are redirected. Stdin is used if it is the terminal and
+// there is no /dev/tty, as on Windows.
+func openTerminal() (*terminal, error) {
+	if tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0); err == nil {
+		return &terminal{in: tty, out: tty, close: tty.Close}, nil
+	}
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		return &terminal{in: os.Stdin, out: os.Stderr, close: func() error { return nil }}, nil
+	}
+	return nil, fmt.Errorf("no terminal to prompt for the password: set %s, --password-file or --password-command", PasswordEnv)
+}
@@ -0,0 +1,55 @@
+package encrypt
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadPassword(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	defer func() { PasswordFile, PasswordCommand, NoPrompt = "", "", false }()
+	file := filepath.Join(t.TempDir(), "password")
+	require.Nil(t, os.WriteFile(file, []byte("from-file\n"), 0600))
+	PasswordFile, PasswordCommand, NoPrompt = file, "echo from-command", true
+
+	t.Setenv(PasswordEnv, "from-env")
+	password, err := ReadPassword(false)
+	require.Nil(t, err)
+	require.Equal(t, "from-env", password)
+
+	os.Unsetenv(PasswordEnv)
+	password, err = ReadPassword(false)
+	require.Nil(t, err)
+	require.Equal(t, "from-file", password)
+
+	PasswordFile = ""
+	password, err = ReadPassword(false)
+	require.Nil(t, err)
+	require.Equal(t, "from-command", password)
+
+	PasswordCommand = "exit 3"
+	_, err = ReadPassword(false)
+	require.NotNil(t, err)
+
+	PasswordCommand = ""
+	_, err = ReadPassword(false)
+	require.ErrorIs(t, err, ErrNoPassword)
+}
+
+func TestReadPasswordRemovesLegacyFile(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv(PasswordEnv, "pw")
+	legacyOnce = sync.Once{}
+	rc := filepath.Join(home, ".cmdbrc")
+	require.Nil(t, os.WriteFile(rc, []byte(`{"password_hash":"pw"}`), 0600))
+
+	_, err := ReadPassword(false)
+	require.Nil(t, err)
+	_, err = os.Stat(rc)
+	require.True(t, os.IsNotExist(err))
+}
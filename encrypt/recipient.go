@@ -0,0 +1,286 @@
+package encrypt
+
+import (
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A file encrypted to recipients has a random file key instead of a password.
+// The content is encrypted with the file key as in version 1, and the file key
+// is wrapped once per recipient public key: an ephemeral X25519 key agreement
+// with the recipient gives, through HKDF-SHA256, the AES-256-GCM key the file
+// key is sealed with. Anyone holding the identity of a recipient can unwrap
+// the file key; nobody shares a password.
+
+const (
+	// VersionRecipients is the EncryptData version of files encrypted to
+	// recipients before FormatVersion. Version 1 files have no version field.
+	VersionRecipients = 2
+
+	// PublicKeyPrefix starts the text form of a recipient public key.
+	PublicKeyPrefix = "cmdb-pub-"
+	// IdentityPrefix starts the text form of an identity.
+	IdentityPrefix = "cmdb-key-"
+
+	// IdentityEnv overrides the path of the identity file.
+	IdentityEnv = "CMDB_IDENTITY"
+
+	wrapInfo = "cmdb recipient v2"
+)
+
+// ErrNoIdentity is returned when no identity can decrypt a file.
+var ErrNoIdentity = errors.New("file is not encrypted to your identity")
+
+// Recipient is a public key a file key is wrapped for.
+type Recipient struct {
+	Name      string `json:"name,omitempty"`
+	PublicKey string `json:"public_key"`
+	Ephemeral string `json:"ephemeral,omitempty"`
+	Wrapped   string `json:"wrapped_key,omitempty"`
+}
+
+// Identity is the X25519 private key of a recipient.
+type Identity struct {
+	key *ecdh.PrivateKey
+}
+
+// GenerateIdentity returns a new random identity.
+func GenerateIdentity() (*Identity, error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	return &Identity{key: key}, nil
+}
+
+// ParseIdentity parses an identity in the form written by String.
+func ParseIdentity(s string) (*Identity, error) {
+	raw, err := decodeKey(s, IdentityPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("invalid identity: %w", err)
+	}
+	key, err := ecdh.X25519().NewPrivateKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid identity: %w", err)
+	}
+	return &Identity{key: key}, nil
+}
+
+// String returns the secret text form of the identity.
+func (id *Identity) String() string {
+	return IdentityPrefix + base64.RawURLEncoding.EncodeToString(id.key.Bytes())
+}
+
+// PublicKey returns the text form of the identity's public key, to be given
+// to those who add it as a recipient.
+func (id *Identity) PublicKey() string {
+	return PublicKeyPrefix + base64.RawURLEncoding.EncodeToString(id.key.PublicKey().Bytes())
+}
+
+// ParsePublicKey checks that s is a public key and returns it normalized.
+func ParsePublicKey(s string) (string, error) {
+	key, err := parsePublicKey(s)
+	if err != nil {
+		return "", err
+	}
+	return PublicKeyPrefix + base64.RawURLEncoding.EncodeToString(key.Bytes()), nil
+}
+
+func parsePublicKey(s string) (*ecdh.PublicKey, error) {
+	raw, err := decodeKey(s, PublicKeyPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	key, err := ecdh.X25519().NewPublicKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	return key, nil
+}
+
+func decodeKey(s, prefix string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, prefix) {
+		return nil, fmt.Errorf("missing %q prefix", prefix)
+	}
+	return base64.RawURLEncoding.DecodeString(strings.TrimPrefix(s, prefix))
+}
+
+// IdentityPath returns the path of the identity file: $CMDB_IDENTITY, or
+// ~/.config/cmdb/identity.
+func IdentityPath() string {
+	if p := os.Getenv(IdentityEnv); p != "" {
+		return p
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "identity"
+	}
+	return filepath.Join(home, ".config", "cmdb", "identity")
+}
+
+// LoadIdentity reads the identity file.
+func LoadIdentity() (*Identity, error) {
+	path := IdentityPath()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no identity at %s, create one with \"cm identity generate\"", path)
+		}
+		return nil, fmt.Errorf("failed to read identity: %w", err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			return ParseIdentity(line)
+		}
+	}
+	return nil, fmt.Errorf("%s: no identity found", path)
+}
+
+// SaveIdentity writes id to path, readable by the user only. An existing
+// identity is not overwritten.
+func SaveIdentity(id *Identity, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(f, "# created: %s\n# public key: %s\n%s\n", time.Now().Format(time.RFC3339), id.PublicKey(), id)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// IsRecipientEncrypted reports whether data is encrypted to recipients.
+func IsRecipientEncrypted(data []byte) bool {
+	if !IsEncrypted(data) {
+		return false
+	}
+	encryptData, err := ParseEncryptData(data)
+	return err == nil && encryptData.HasRecipients()
+}
+
+// EncryptTo encrypts data with a new file key wrapped for each recipient, as
+// the revision of the file described by binding. Only the Name and PublicKey
+// of recipients are used.
+func EncryptTo(data []byte, recipients []Recipient, binding Binding) (string, error) {
+	if len(recipients) == 0 {
+		return "", errors.New("no recipients")
+	}
+	fileKey := make([]byte, 32)
+	if _, err := rand.Read(fileKey); err != nil {
+		return "", fmt.Errorf("failed to generate file key: %w", err)
+	}
+
+	encryptData := EncryptData{Version: FormatVersion, Cipher: CipherAES256GCM, Binding: binding}
+	for _, r := range recipients {
+		wrapped, err := wrapKey(fileKey, r)
+		if err != nil {
+			return "", err
+		}
+		encryptData.Recipients = append(encryptData.Recipients, wrapped)
+	}
+	if err := encryptData.seal(fileKey, data); err != nil {
+		return "", err
+	}
+	return encryptData.encode()
+}
+
+// DecryptWith decrypts data encrypted to recipients with id.
+func DecryptWith(encryptedData string, id *Identity) ([]byte, error) {
+	encryptData, err := ParseEncryptData([]byte(encryptedData))
+	if err != nil {
+		return nil, err
+	}
+	if !encryptData.HasRecipients() {
+		return nil, errors.New("file is not encrypted to recipients")
+	}
+
+	public := id.PublicKey()
+	for _, r := range encryptData.Recipients {
+		if r.PublicKey != public {
+			continue
+		}
+		fileKey, err := unwrapKey(r, id)
+		if err != nil {
+			return nil, err
+		}
+		return encryptData.open(fileKey)
+	}
+	return nil, fmt.Errorf("%w (%s)", ErrNoIdentity, public)
+}
+
+// wrapKey seals fileKey for r.
+func wrapKey(fileKey []byte, r Recipient) (Recipient, error) {
+	public, err := parsePublicKey(r.PublicKey)
+	if err != nil {
+		return r, err
+	}
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return r, fmt.Errorf("failed to generate key: %w", err)
+	}
+	shared, err := ephemeral.ECDH(public)
+	if err != nil {
+		return r, fmt.Errorf("key agreement failed: %w", err)
+	}
+	key, err := wrappingKey(shared, ephemeral.PublicKey().Bytes(), public.Bytes())
+	if err != nil {
+		return r, err
+	}
+	wrapped, err := EncryptField(key, fileKey)
+	if err != nil {
+		return r, err
+	}
+	return Recipient{
+		Name:      r.Name,
+		PublicKey: PublicKeyPrefix + base64.RawURLEncoding.EncodeToString(public.Bytes()),
+		Ephemeral: base64.StdEncoding.EncodeToString(ephemeral.PublicKey().Bytes()),
+		Wrapped:   strings.TrimPrefix(wrapped, FieldPrefix),
+	}, nil
+}
+
+// unwrapKey opens the file key wrapped for id in r.
+func unwrapKey(r Recipient, id *Identity) ([]byte, error) {
+	raw, err := base64.StdEncoding.DecodeString(r.Ephemeral)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode ephemeral key: %w", err)
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ephemeral key: %w", err)
+	}
+	shared, err := id.key.ECDH(ephemeral)
+	if err != nil {
+		return nil, fmt.Errorf("key agreement failed: %w", err)
+	}
+	key, err := wrappingKey(shared, raw, id.key.PublicKey().Bytes())
+	if err != nil {
+		return nil, err
+	}
+	fileKey, err := DecryptField(key, FieldPrefix+r.Wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap file key: %w", err)
+	}
+	return fileKey, nil
+}
+
+// wrappingKey derives the key wrapping a file key from the X25519 shared
+// secret, bound to the ephemeral and recipient public keys.
+func wrappingKey(shared, ephemeral, recipient []byte) ([]byte, error) {
+	salt := append(append([]byte{}, ephemeral...), recipient...)
+	return hkdf.Key(sha256.New, shared, salt, wrapInfo, 32)
+}
@@ -0,0 +1,66 @@
+package encrypt
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptToRecipients(t *testing.T) {
+	alice, err := GenerateIdentity()
+	require.Nil(t, err)
+	bob, err := GenerateIdentity()
+	require.Nil(t, err)
+	eve, err := GenerateIdentity()
+	require.Nil(t, err)
+
+	data := []byte("a = 1\n")
+	enc, err := EncryptTo(data, []Recipient{{Name: "alice", PublicKey: alice.PublicKey()}, {PublicKey: bob.PublicKey()}}, Binding{ID: "f", Counter: 1})
+	require.Nil(t, err)
+	require.True(t, IsEncrypted([]byte(enc)))
+	require.True(t, IsRecipientEncrypted([]byte(enc)))
+
+	for _, id := range []*Identity{alice, bob} {
+		plain, err := DecryptWith(enc, id)
+		require.Nil(t, err)
+		require.Equal(t, data, plain)
+	}
+	_, err = DecryptWith(enc, eve)
+	require.ErrorIs(t, err, ErrNoIdentity)
+	_, err = Decrypt(enc, "password")
+	require.NotNil(t, err)
+
+	header, err := ParseEncryptData([]byte(enc))
+	require.Nil(t, err)
+	require.Equal(t, "alice", header.Recipients[0].Name)
+	require.Empty(t, header.Salt)
+
+	_, err = EncryptTo(data, []Recipient{{PublicKey: "cmdb-pub-nope"}}, Binding{})
+	require.NotNil(t, err)
+}
+
+func TestPasswordFilesAreNotRecipientEncrypted(t *testing.T) {
+	enc, err := Encrypt([]byte("a = 1\n"), "password")
+	require.Nil(t, err)
+	require.False(t, IsRecipientEncrypted([]byte(enc)))
+	_, err = PasswordKDF([]byte(enc))
+	require.Nil(t, err)
+}
+
+func TestIdentityFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cmdb", "identity")
+	t.Setenv(IdentityEnv, path)
+	id, err := GenerateIdentity()
+	require.Nil(t, err)
+	require.Nil(t, SaveIdentity(id, IdentityPath()))
+	require.NotNil(t, SaveIdentity(id, IdentityPath()))
+
+	loaded, err := LoadIdentity()
+	require.Nil(t, err)
+	require.Equal(t, id.PublicKey(), loaded.PublicKey())
+
+	public, err := ParsePublicKey(" " + id.PublicKey() + "\n")
+	require.Nil(t, err)
+	require.Equal(t, id.PublicKey(), public)
+}
@@ -0,0 +1,334 @@
+// Package schema checks cmdb entries against declared attribute rules.
+//
+// A schema is a TOML file of rules. Each rule selects entries with a query
+// (see toml.Toml.Query) and constrains their attributes:
+//
+//	enforce = true   # refuse writes that introduce violations
+//
+//	[[rule]]
+//	pattern  = "*:host:*"
+//	required = ["hostname"]
+//	closed   = true  # no attributes besides the declared ones
+//
+//	[rule.attrs.port]
+//	type = "int"
+//	min  = 1
+//	max  = 65535
+//
+//	[rule.attrs.environment]
+//	enum = ["dev", "staging", "prod"]
+//
+//	[rule.attrs.hostname]
+//	pattern = '^[A-Za-z0-9.-]+$'
+package schema
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/MinseokOh/toml-cli/toml"
+	lib "github.com/pelletier/go-toml"
+)
+
+// Schema is a set of rules.
+type Schema struct {
+	Enforce bool
+	Rules   []Rule
+}
+
+// Rule constrains the entries selected by Pattern.
+type Rule struct {
+	Pattern  string
+	Required []string
+	Closed   bool
+	Attrs    map[string]Attr
+}
+
+// Attr constrains a single attribute.
+type Attr struct {
+	Type     string
+	Enum     []interface{}
+	Pattern  *regexp.Regexp
+	Min, Max *float64
+	Required bool
+}
+
+// Violation is an entry that breaks a rule.
+type Violation struct {
+	Entry   toml.Path
+	Attr    string
+	Message string
+}
+
+func (v Violation) String() string {
+	p := v.Entry
+	if v.Attr != "" {
+		p = p.Append(toml.PathSegment{Key: v.Attr})
+	}
+	return fmt.Sprintf("%s: %s", p, v.Message)
+}
+
+// DefaultPath returns the schema file used for a cmdb file:
+// cmdb.toml is checked against cmdb.schema.toml next to it.
+func DefaultPath(cmdbPath string) string {
+	ext := filepath.Ext(cmdbPath)
+	return strings.TrimSuffix(cmdbPath, ext) + ".schema" + ext
+}
+
+// Load reads a schema file.
+func Load(path string) (*Schema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := lib.LoadBytes(data)
+	if err != nil {
+		return nil, fmt.Errorf("schema %s: %w", path, err)
+	}
+	s, err := parse(tree)
+	if err != nil {
+		return nil, fmt.Errorf("schema %s: %w", path, err)
+	}
+	return s, nil
+}
+
+func parse(tree *lib.Tree) (*Schema, error) {
+	s := &Schema{}
+	if v, ok := tree.Get("enforce").(bool); ok {
+		s.Enforce = v
+	}
+	rules, _ := tree.Get("rule").([]*lib.Tree)
+	for i, rt := range rules {
+		r := Rule{Attrs: make(map[string]Attr)}
+		var ok bool
+		if r.Pattern, ok = rt.Get("pattern").(string); !ok || r.Pattern == "" {
+			return nil, fmt.Errorf("rule %d: pattern is required", i+1)
+		}
+		r.Closed, _ = rt.Get("closed").(bool)
+		for _, v := range asList(rt.Get("required")) {
+			r.Required = append(r.Required, fmt.Sprint(v))
+		}
+		if attrs, ok := rt.Get("attrs").(*lib.Tree); ok {
+			for _, name := range attrs.Keys() {
+				at, ok := attrs.GetPath([]string{name}).(*lib.Tree)
+				if !ok {
+					return nil, fmt.Errorf("rule %d: attrs.%s must be a table", i+1, name)
+				}
+				a, err := parseAttr(at)
+				if err != nil {
+					return nil, fmt.Errorf("rule %d: attrs.%s: %w", i+1, name, err)
+				}
+				r.Attrs[name] = a
+			}
+		}
+		s.Rules = append(s.Rules, r)
+	}
+	return s, nil
+}
+
+func parseAttr(t *lib.Tree) (Attr, error) {
+	var a Attr
+	a.Type, _ = t.Get("type").(string)
+	if a.Type != "" && (!toml.IsValueType(a.Type) || a.Type == toml.TypeAuto) {
+		return a, fmt.Errorf("unknown type %q", a.Type)
+	}
+	a.Enum = asList(t.Get("enum"))
+	a.Required, _ = t.Get("required").(bool)
+	if p, ok := t.Get("pattern").(string); ok {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return a, err
+		}
+		a.Pattern = re
+	}
+	for name, dst := range map[string]**float64{"min": &a.Min, "max": &a.Max} {
+		switch n := t.Get(name).(type) {
+		case nil:
+		case int64:
+			f := float64(n)
+			*dst = &f
+		case float64:
+			*dst = &n
+		default:
+			return a, fmt.Errorf("%s must be a number", name)
+		}
+	}
+	return a, nil
+}
+
+func asList(v interface{}) []interface{} {
+	if l, ok := v.([]interface{}); ok {
+		return l
+	}
+	return nil
+}
+
+// Validate checks every entry of t.
+func (s *Schema) Validate(t *toml.Toml) ([]Violation, error) {
+	return s.validate(t, nil)
+}
+
+// Check validates only the entries touched by the pending changes of t, so
+// existing problems elsewhere in the file do not block unrelated edits.
+func (s *Schema) Check(t *toml.Toml) ([]Violation, error) {
+	changes, err := t.Changes()
+	if err != nil {
+		return nil, err
+	}
+	touched := make([]toml.Path, len(changes))
+	for i, c := range changes {
+		touched[i] = c.Path
+	}
+	return s.validate(t, func(entry toml.Path) bool {
+		for _, c := range touched {
+			if related(entry, c) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// related reports whether one path is a prefix of the other.
+func related(a, b toml.Path) bool {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Schema) validate(t *toml.Toml, include func(toml.Path) bool) ([]Violation, error) {
+	var violations []Violation
+	for _, r := range s.Rules {
+		results, err := t.Query(r.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		for _, res := range results {
+			if include != nil && !include(res.Path) {
+				continue
+			}
+			violations = append(violations, r.check(res)...)
+		}
+	}
+	sort.SliceStable(violations, func(i, j int) bool {
+		return violations[i].Entry.String() < violations[j].Entry.String()
+	})
+	return violations, nil
+}
+
+func (r Rule) check(res toml.Result) []Violation {
+	entry, ok := res.Value.(*lib.Tree)
+	if !ok {
+		return []Violation{{Entry: res.Path, Message: "must be a table"}}
+	}
+	var vs []Violation
+	add := func(attr, format string, args ...interface{}) {
+		vs = append(vs, Violation{Entry: res.Path, Attr: attr, Message: fmt.Sprintf(format, args...)})
+	}
+
+	for _, name := range r.Required {
+		if !entry.HasPath([]string{name}) {
+			add(name, "required attribute is missing")
+		}
+	}
+	names := make([]string, 0, len(r.Attrs))
+	for name := range r.Attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		a := r.Attrs[name]
+		v := entry.GetPath([]string{name})
+		if v == nil {
+			if a.Required {
+				add(name, "required attribute is missing")
+			}
+			continue
+		}
+		if msg := a.check(v); msg != "" {
+			add(name, "%s", msg)
+		}
+	}
+	if r.Closed {
+		keys := entry.Keys()
+		sort.Strings(keys)
+		for _, k := range keys {
+			if _, ok := r.Attrs[k]; ok || contains(r.Required, k) {
+				continue
+			}
+			add(k, "unknown attribute")
+		}
+	}
+	return vs
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// check returns why v breaks the constraint, or "" if it does not.
+func (a Attr) check(v interface{}) string {
+	if a.Type != "" {
+		if got := toml.TypeOf(v); got != a.Type && !(a.Type == toml.TypeFloat && got == toml.TypeInt) {
+			return fmt.Sprintf("expected %s, got %s %v", a.Type, got, quoted(v))
+		}
+	}
+	if len(a.Enum) > 0 {
+		found := false
+		for _, e := range a.Enum {
+			if fmt.Sprint(e) == fmt.Sprint(v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Sprintf("%v is not one of %v", quoted(v), a.Enum)
+		}
+	}
+	if a.Pattern != nil {
+		if s, ok := v.(string); !ok || !a.Pattern.MatchString(s) {
+			return fmt.Sprintf("%v does not match %s", quoted(v), a.Pattern)
+		}
+	}
+	if a.Min != nil || a.Max != nil {
+		var f float64
+		switch n := v.(type) {
+		case int64:
+			f = float64(n)
+		case float64:
+			f = n
+		default:
+			return fmt.Sprintf("%v is not a number", quoted(v))
+		}
+		if a.Min != nil && f < *a.Min {
+			return fmt.Sprintf("%v is less than %v", v, *a.Min)
+		}
+		if a.Max != nil && f > *a.Max {
+			return fmt.Sprintf("%v is greater than %v", v, *a.Max)
+		}
+	}
+	return ""
+}
+
+func quoted(v interface{}) interface{} {
+	if s, ok := v.(string); ok {
+		return fmt.Sprintf("%q", s)
+	}
+	return v
+}
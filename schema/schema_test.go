@@ -0,0 +1,80 @@
+package schema
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/MinseokOh/toml-cli/toml"
+	lib "github.com/pelletier/go-toml"
+	"github.com/stretchr/testify/require"
+)
+
+const testSchema = `
+enforce = true
+
+[[rule]]
+pattern = "*:host:*"
+required = ["hostname"]
+closed = true
+
+[rule.attrs.port]
+type = "int"
+min = 1
+max = 65535
+
+[rule.attrs.environment]
+enum = ["dev", "staging", "prod"]
+`
+
+func TestValidate(t *testing.T) {
+	tree, err := lib.Load(testSchema)
+	require.Nil(t, err)
+	s, err := parse(tree)
+	require.Nil(t, err)
+	require.True(t, s.Enforce)
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "cmdb.toml")
+	require.Nil(t, os.WriteFile(file, []byte(`
+["ns:host:web"]
+hostname = "10.0.0.1"
+port = 22
+environment = "prod"
+
+["ns:host:bad"]
+hostnme = "10.0.0.2"
+port = "22"
+environment = "qa"
+`), 0600))
+	cmdb, err := toml.NewToml(file)
+	require.Nil(t, err)
+
+	violations, err := s.Validate(&cmdb)
+	require.Nil(t, err)
+	msgs := make([]string, len(violations))
+	for i, v := range violations {
+		msgs[i] = v.String()
+	}
+	require.Equal(t, []string{
+		`ns:host:bad.hostname: required attribute is missing`,
+		`ns:host:bad.environment: "qa" is not one of [dev staging prod]`,
+		`ns:host:bad.port: expected int, got string "22"`,
+		`ns:host:bad.hostnme: unknown attribute`,
+	}, msgs)
+
+	// Only entries touched by pending changes are checked.
+	violations, err = s.Check(&cmdb)
+	require.Nil(t, err)
+	require.Empty(t, violations)
+
+	require.Nil(t, cmdb.Set("ns:host:web", "port", int64(0)))
+	violations, err = s.Check(&cmdb)
+	require.Nil(t, err)
+	require.Len(t, violations, 1)
+	require.Equal(t, "ns:host:web.port: 0 is less than 1", violations[0].String())
+}
+
+func TestDefaultPath(t *testing.T) {
+	require.Equal(t, "/a/cmdb.schema.toml", DefaultPath("/a/cmdb.toml"))
+}
@@ -0,0 +1,273 @@
+// Package sshconfig reads OpenSSH client configuration files.
+//
+// It resolves the options that apply to each host alias the way ssh does:
+// Host blocks apply when one of their patterns matches the alias, Include
+// directives are followed, and the first value obtained for an option wins,
+// so both leading overrides and trailing "Host *" defaults work. Match blocks
+// depend on the connection and are never applied.
+package sshconfig
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxIncludeDepth limits nested Include directives, as ssh does.
+const maxIncludeDepth = 16
+
+// Option is a keyword and its value as written in the file.
+type Option struct {
+	Keyword string
+	Value   string
+}
+
+// Config is a parsed client configuration.
+type Config struct {
+	blocks []*block
+}
+
+type block struct {
+	patterns []string // nil for the lines before the first Host
+	match    bool
+	options  []Option
+}
+
+// accumulating options take every value given instead of the first.
+var accumulating = map[string]bool{
+	"identityfile":    true,
+	"certificatefile": true,
+	"localforward":    true,
+	"remoteforward":   true,
+	"dynamicforward":  true,
+	"sendenv":         true,
+	"setenv":          true,
+}
+
+// Load reads the configuration at path and the files it includes. Relative
+// includes are resolved against the directory of path.
+func Load(path string) (*Config, error) {
+	c := &Config{}
+	first := &block{}
+	c.blocks = append(c.blocks, first)
+	if err := c.load(path, filepath.Dir(path), first, 0); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Parse reads a configuration from data. Relative includes are resolved
+// against dir.
+func Parse(data []byte, dir string) (*Config, error) {
+	c := &Config{}
+	first := &block{}
+	c.blocks = append(c.blocks, first)
+	if err := c.parse(data, "config", dir, first, 0); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Config) load(path, dir string, cur *block, depth int) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return c.parse(data, path, dir, cur, depth)
+}
+
+// parse adds the lines of data to the configuration. Options before the
+// first Host or Match line of data belong to cur, the block the file is
+// included from.
+func (c *Config) parse(data []byte, name, dir string, cur *block, depth int) error {
+	for i, line := range strings.Split(string(data), "\n") {
+		keyword, value, ok := splitLine(line)
+		if !ok {
+			continue
+		}
+		switch strings.ToLower(keyword) {
+		case "host":
+			patterns, err := splitArgs(value)
+			if err != nil {
+				return fmt.Errorf("%s:%d: %w", name, i+1, err)
+			}
+			cur = &block{patterns: patterns}
+			c.blocks = append(c.blocks, cur)
+		case "match":
+			cur = &block{match: true}
+			c.blocks = append(c.blocks, cur)
+		case "include":
+			if depth >= maxIncludeDepth {
+				return fmt.Errorf("%s:%d: too many nested includes", name, i+1)
+			}
+			args, err := splitArgs(value)
+			if err != nil {
+				return fmt.Errorf("%s:%d: %w", name, i+1, err)
+			}
+			for _, arg := range args {
+				files, err := filepath.Glob(resolve(arg, dir))
+				if err != nil {
+					return fmt.Errorf("%s:%d: %w", name, i+1, err)
+				}
+				for _, f := range files {
+					if err := c.load(f, dir, cur, depth+1); err != nil {
+						return err
+					}
+				}
+			}
+		default:
+			cur.options = append(cur.options, Option{Keyword: keyword, Value: unquote(value)})
+		}
+	}
+	return nil
+}
+
+// Hosts returns the aliases named by Host lines, leaving out patterns, in the
+// order they first appear.
+func (c *Config) Hosts() []string {
+	var hosts []string
+	seen := map[string]bool{}
+	for _, b := range c.blocks {
+		for _, p := range b.patterns {
+			if strings.ContainsAny(p, "*?!") || seen[p] {
+				continue
+			}
+			seen[p] = true
+			hosts = append(hosts, p)
+		}
+	}
+	return hosts
+}
+
+// Options returns the options that apply to host in file order. An option
+// given several times appears once, with its first value, unless it takes
+// several values like IdentityFile or LocalForward.
+func (c *Config) Options(host string) []Option {
+	var opts []Option
+	seen := map[string]bool{}
+	for _, b := range c.blocks {
+		if !b.matches(host) {
+			continue
+		}
+		for _, o := range b.options {
+			k := strings.ToLower(o.Keyword)
+			if seen[k] && !accumulating[k] {
+				continue
+			}
+			seen[k] = true
+			opts = append(opts, o)
+		}
+	}
+	return opts
+}
+
+func (b *block) matches(host string) bool {
+	if b.match {
+		return false
+	}
+	if b.patterns == nil {
+		return true
+	}
+	matched := false
+	for _, p := range b.patterns {
+		if strings.HasPrefix(p, "!") {
+			if matchPattern(p[1:], host) {
+				return false
+			}
+			continue
+		}
+		if matchPattern(p, host) {
+			matched = true
+		}
+	}
+	return matched
+}
+
+// matchPattern matches s against a pattern of * and ? wildcards.
+func matchPattern(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(s); i >= 0; i-- {
+				if matchPattern(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		default:
+			if len(s) == 0 || pattern[0] != s[0] {
+				return false
+			}
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return len(s) == 0
+}
+
+// splitLine splits a "Keyword value" or "Keyword=value" line. Blank lines
+// and comments are not ok.
+func splitLine(line string) (keyword, value string, ok bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || line[0] == '#' {
+		return "", "", false
+	}
+	end := strings.IndexAny(line, " \t=")
+	if end < 0 {
+		return line, "", true
+	}
+	keyword, value = line[:end], strings.TrimLeft(line[end:], " \t")
+	if strings.HasPrefix(value, "=") {
+		value = strings.TrimLeft(value[1:], " \t")
+	}
+	return keyword, value, true
+}
+
+// splitArgs splits value into whitespace separated, optionally double quoted
+// arguments.
+func splitArgs(value string) ([]string, error) {
+	var args []string
+	for value = strings.TrimSpace(value); value != ""; value = strings.TrimLeft(value, " \t") {
+		if value[0] == '"' {
+			end := strings.IndexByte(value[1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quote in %q", value)
+			}
+			args = append(args, value[1:end+1])
+			value = value[end+2:]
+			continue
+		}
+		end := strings.IndexAny(value, " \t")
+		if end < 0 {
+			end = len(value)
+		}
+		args = append(args, value[:end])
+		value = value[end:]
+	}
+	return args, nil
+}
+
+// unquote removes the quotes around a value that is a single quoted argument.
+func unquote(value string) string {
+	if len(value) >= 2 && value[0] == '"' && strings.IndexByte(value[1:], '"') == len(value)-2 {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// resolve expands a leading ~ and makes path relative to dir absolute.
+func resolve(path, dir string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, path[1:])
+		}
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	return path
+}
@@ -0,0 +1,101 @@
+package sshconfig
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const sample = `
+# leading options apply to every host
+Compression yes
+
+Host bastion
+    HostName bastion.example.com
+    User ops
+
+Host web1 "web 2" !web3
+    HostName=%h.internal
+    LocalForward 8080 localhost:80
+    LocalForward 8443 localhost:443
+
+Include conf.d/*.conf
+
+Match host web1
+    User nobody
+
+Host web*
+    Port 2222
+
+Host *
+    User deploy
+    IdentityFile "~/.ssh/id ed25519"
+`
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "config"), []byte(sample), 0600))
+	require.Nil(t, os.Mkdir(filepath.Join(dir, "conf.d"), 0700))
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "conf.d", "db.conf"), []byte("Host db\n  HostName 10.0.0.5\n"), 0600))
+
+	config, err := Load(filepath.Join(dir, "config"))
+	require.Nil(t, err)
+	require.Equal(t, []string{"bastion", "web1", "web 2", "db"}, config.Hosts())
+
+	require.Equal(t, []Option{
+		{"Compression", "yes"},
+		{"HostName", "bastion.example.com"},
+		{"User", "ops"},
+		{"IdentityFile", "~/.ssh/id ed25519"},
+	}, config.Options("bastion"))
+
+	require.Equal(t, []Option{
+		{"Compression", "yes"},
+		{"HostName", "%h.internal"},
+		{"LocalForward", "8080 localhost:80"},
+		{"LocalForward", "8443 localhost:443"},
+		{"Port", "2222"},
+		{"User", "deploy"},
+		{"IdentityFile", "~/.ssh/id ed25519"},
+	}, config.Options("web1"))
+
+	require.Equal(t, []Option{
+		{"Compression", "yes"},
+		{"HostName", "10.0.0.5"},
+		{"User", "deploy"},
+		{"IdentityFile", "~/.ssh/id ed25519"},
+	}, config.Options("db"))
+
+	// web3 is excluded from the web1 block but not from web*.
+	require.Equal(t, []Option{
+		{"Compression", "yes"},
+		{"Port", "2222"},
+		{"User", "deploy"},
+		{"IdentityFile", "~/.ssh/id ed25519"},
+	}, config.Options("web3"))
+}
+
+func TestIncludeInsideHost(t *testing.T) {
+	dir := t.TempDir()
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "extra"), []byte("User ops\nHost other\n  User x\n"), 0600))
+	config, err := Parse([]byte("Host a\n  Include extra\n  Port 2222\n"), dir)
+	require.Nil(t, err)
+	require.Equal(t, []Option{{"User", "ops"}, {"Port", "2222"}}, config.Options("a"))
+	require.Equal(t, []Option{{"User", "x"}}, config.Options("other"))
+}
+
+func TestIncludeLoop(t *testing.T) {
+	dir := t.TempDir()
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "config"), []byte("Include config\n"), 0600))
+	_, err := Load(filepath.Join(dir, "config"))
+	require.NotNil(t, err)
+}
+
+func TestMatchPattern(t *testing.T) {
+	require.True(t, matchPattern("*", "anything"))
+	require.True(t, matchPattern("web?.example.*", "web1.example.com"))
+	require.False(t, matchPattern("web?", "web10"))
+	require.False(t, matchPattern("db*", "web1"))
+}
@@ -0,0 +1,392 @@
+package toml
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	lib "github.com/pelletier/go-toml"
+)
+
+// Every write appends a record to an audit log next to the file,
+// .<name>.audit.jsonl, one JSON object per line. Records name the user, the
+// command and the key paths that changed. Values are never logged: old and
+// new values are represented by HMACs keyed with a random secret of the log,
+// .<name>.audit.key, so equal hashes mean equal values but a guessed value,
+// e.g. a short PIN, cannot be checked against the log without the secret.
+
+// Audit actions.
+const (
+	AuditWrite = "write"
+	AuditRead  = "read"
+)
+
+// SensitiveKeys are attribute names whose plaintext display is audited.
+var SensitiveKeys = []string{"private_key"}
+
+// AuditRecord is one entry of the audit log.
+type AuditRecord struct {
+	Time    time.Time  `json:"time"`
+	User    string     `json:"user"`
+	Command string     `json:"command"`
+	File    string     `json:"file"`
+	Action  string     `json:"action"`
+	Keys    []AuditKey `json:"keys"`
+}
+
+// AuditKey is a key path touched by a command.
+type AuditKey struct {
+	Op      string `json:"op"` // add, remove, replace or read
+	Path    string `json:"path"`
+	OldHash string `json:"old_hash,omitempty"`
+	NewHash string `json:"new_hash,omitempty"`
+}
+
+// Matches reports whether the key, or one of the tables it is in, matches
+// pattern, a path that may contain * and ? globs.
+func (k AuditKey) Matches(pattern string) bool {
+	p, err := ParsePath(k.Path)
+	if err != nil {
+		return pattern == k.Path
+	}
+	if pp, err := ParsePath(pattern); err == nil {
+		pattern = pp.String()
+	}
+	for i := len(p); i > 0; i-- {
+		s := p[:i].String()
+		if s == pattern || globMatch(pattern, s) || globMatch(pattern, strings.Trim(s, `"`)) {
+			return true
+		}
+	}
+	return false
+}
+
+// AuditLogPath returns the audit log of the file at path.
+func AuditLogPath(path string) string {
+	dir, name := filepath.Split(path)
+	return filepath.Join(dir, "."+name+".audit.jsonl")
+}
+
+// AuditKeyPath returns the secret the audit log of the file at path hashes
+// values with.
+func AuditKeyPath(path string) string {
+	dir, name := filepath.Split(path)
+	return filepath.Join(dir, "."+name+".audit.key")
+}
+
+// auditSecret returns the secret of the audit log of the file at path,
+// creating it on first use.
+func auditSecret(path string) ([]byte, error) {
+	file := AuditKeyPath(path)
+	secret, err := os.ReadFile(file)
+	if err == nil {
+		if len(secret) != 32 {
+			return nil, fmt.Errorf("%s is not an audit key", file)
+		}
+		return secret, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	secret = make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if errors.Is(err, os.ErrExist) {
+		// Created meanwhile by another writer
+		return auditSecret(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(secret); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return secret, f.Close()
+}
+
+// auditChanges returns the keys that writing t to path changes from the
+// content t was loaded with, to be logged once written, and their values.
+func (t *Toml) auditChanges(path string) ([]AuditKey, []interface{}, error) {
+	changes, err := t.Changes()
+	if err != nil {
+		return nil, nil, err
+	}
+	var keys []AuditKey
+	var values []interface{}
+	var secret []byte
+	for _, c := range changes {
+		for _, leaf := range leafChanges(c) {
+			if secret == nil {
+				if secret, err = auditSecret(path); err != nil {
+					return nil, nil, err
+				}
+			}
+			k := AuditKey{Op: leaf.Kind.String(), Path: leaf.Path.String()}
+			if leaf.Old != nil {
+				k.OldHash = hashValue(secret, leaf.Old)
+				values = append(values, leaf.Old)
+			}
+			if leaf.New != nil {
+				k.NewHash = hashValue(secret, leaf.New)
+				values = append(values, leaf.New)
+			}
+			keys = append(keys, k)
+		}
+	}
+	return keys, values, nil
+}
+
+// LogRead records in the audit log that the values at paths of the file at
+// path were shown in plaintext.
+func LogRead(path string, paths []Path) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	keys := make([]AuditKey, len(paths))
+	for i, p := range paths {
+		keys[i] = AuditKey{Op: AuditRead, Path: p.String()}
+	}
+	return appendAudit(path, AuditRead, keys, nil)
+}
+
+// SensitivePaths returns the paths of SensitiveKeys found in results.
+func SensitivePaths(results []Result) []Path {
+	var paths []Path
+	var walk func(p Path, v interface{})
+	walk = func(p Path, v interface{}) {
+		switch n := v.(type) {
+		case *lib.Tree:
+			for _, k := range sortedKeys(n) {
+				kp := p.Append(PathSegment{Key: k})
+				if containsString(SensitiveKeys, k) {
+					paths = append(paths, kp)
+					continue
+				}
+				walk(kp, n.GetPath([]string{k}))
+			}
+		case []*lib.Tree:
+			for i, e := range n {
+				walk(p.Append(PathSegment{Index: i, IsIndex: true}), e)
+			}
+		}
+	}
+	for _, r := range results {
+		if len(r.Path) > 0 {
+			if last := r.Path[len(r.Path)-1]; !last.IsIndex && containsString(SensitiveKeys, last.Key) {
+				paths = append(paths, r.Path)
+				continue
+			}
+		}
+		walk(r.Path, r.Value)
+	}
+	return paths
+}
+
+// MaskResults returns results with the values of SensitiveKeys in them
+// replaced by mask, for display. results are left as they are.
+func MaskResults(results []Result, mask string) []Result {
+	res := make([]Result, len(results))
+	for i, r := range results {
+		res[i] = Result{Path: r.Path, Value: maskValue(r.Path, r.Value, mask)}
+	}
+	return res
+}
+
+func maskValue(p Path, v interface{}, mask string) interface{} {
+	if len(p) > 0 {
+		if last := p[len(p)-1]; !last.IsIndex && containsString(SensitiveKeys, last.Key) {
+			return mask
+		}
+	}
+	switch v.(type) {
+	case *lib.Tree, []*lib.Tree:
+	default:
+		return v
+	}
+	v = cloneValue(v)
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch n := v.(type) {
+		case *lib.Tree:
+			for _, k := range n.Keys() {
+				if containsString(SensitiveKeys, k) {
+					n.SetPath([]string{k}, mask)
+					continue
+				}
+				walk(n.GetPath([]string{k}))
+			}
+		case []*lib.Tree:
+			for _, e := range n {
+				walk(e)
+			}
+		}
+	}
+	walk(v)
+	return v
+}
+
+func appendAudit(path, action string, keys []AuditKey, values []interface{}) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	rec := AuditRecord{
+		Time:    time.Now(),
+		User:    currentUser(),
+		Command: redactCommand(values),
+		File:    abs,
+		Action:  action,
+		Keys:    keys,
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(AuditLogPath(path), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ReadAudit returns the audit log of the file at path, oldest first.
+func ReadAudit(path string) ([]AuditRecord, error) {
+	f, err := os.Open(AuditLogPath(path))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []AuditRecord
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<24)
+	for line := 1; sc.Scan(); line++ {
+		if len(strings.TrimSpace(sc.Text())) == 0 {
+			continue
+		}
+		var rec AuditRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", AuditLogPath(path), line, err)
+		}
+		records = append(records, rec)
+	}
+	return records, sc.Err()
+}
+
+// leafChanges splits changes of whole tables into changes of their keys, so
+// every affected key path is listed.
+func leafChanges(c Change) []Change {
+	ot, oIsTree := c.Old.(*lib.Tree)
+	nt, nIsTree := c.New.(*lib.Tree)
+	if !oIsTree && !nIsTree {
+		return []Change{c}
+	}
+	var res []Change
+	if c.Old != nil && !oIsTree {
+		res = append(res, Change{Kind: ChangeRemove, Path: c.Path, Old: c.Old})
+	}
+	if c.New != nil && !nIsTree {
+		res = append(res, Change{Kind: ChangeAdd, Path: c.Path, New: c.New})
+	}
+	if ot == nil {
+		ot = newTree()
+	}
+	if nt == nil {
+		nt = newTree()
+	}
+	for _, sub := range diffTrees(ot, nt) {
+		sub.Path = append(append(Path{}, c.Path...), sub.Path...)
+		res = append(res, leafChanges(sub)...)
+	}
+	return res
+}
+
+// hashValue identifies a value without revealing it to whoever does not have
+// secret.
+func hashValue(secret []byte, v interface{}) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(TypeOf(v) + ":" + FormatValue(v)))
+	return "hmac-sha256:" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func currentUser() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	for _, env := range []string{"USER", "USERNAME"} {
+		if name := os.Getenv(env); name != "" {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+// redactCommand returns the command line with the arguments that carry one
+// of values masked, so values given on the command line are not logged.
+func redactCommand(values []interface{}) string {
+	var secrets []string
+	for _, v := range values {
+		for _, s := range valueStrings(v) {
+			if s != "" {
+				secrets = append(secrets, s)
+			}
+		}
+	}
+	parts := []string{Command}
+	for _, arg := range CommandArgs {
+		for _, s := range secrets {
+			if arg == s || strings.HasSuffix(arg, "="+s) || (len(s) >= 6 && strings.Contains(arg, s)) {
+				arg = "***"
+				break
+			}
+		}
+		parts = append(parts, arg)
+	}
+	return strings.TrimSpace(strings.Join(parts, " "))
+}
+
+// valueStrings lists how the scalars in v may have been typed on a command
+// line.
+func valueStrings(v interface{}) []string {
+	switch n := v.(type) {
+	case *lib.Tree:
+		var res []string
+		for _, k := range n.Keys() {
+			res = append(res, valueStrings(n.GetPath([]string{k}))...)
+		}
+		return res
+	case []*lib.Tree:
+		var res []string
+		for _, e := range n {
+			res = append(res, valueStrings(e)...)
+		}
+		return res
+	case []interface{}:
+		var res []string
+		for _, e := range n {
+			res = append(res, valueStrings(e)...)
+		}
+		return res
+	}
+	return []string{scalarString(v)}
+}
@@ -0,0 +1,133 @@
+package toml
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditWrite(t *testing.T) {
+	path := writeSample(t, "[web]\nport = 22\n")
+	toml, err := NewToml(path)
+	require.Nil(t, err)
+	defer toml.Close()
+
+	Command, CommandArgs = "cm set", []string{"web", "private_key", "BEGIN-SECRET", "port=2222"}
+	defer func() { Command, CommandArgs = "", nil }()
+	require.Nil(t, toml.Set("web", "private_key", "BEGIN-SECRET"))
+	require.Nil(t, toml.Set("web", "port", int64(2222)))
+	require.Nil(t, toml.Write())
+
+	data, err := os.ReadFile(AuditLogPath(path))
+	require.Nil(t, err)
+	require.NotContains(t, string(data), "BEGIN-SECRET")
+	require.NotContains(t, string(data), "2222")
+
+	records, err := ReadAudit(path)
+	require.Nil(t, err)
+	require.Len(t, records, 1)
+	rec := records[0]
+	require.Equal(t, AuditWrite, rec.Action)
+	require.Equal(t, "cm set web private_key *** ***", rec.Command)
+	require.NotEmpty(t, rec.User)
+	require.Len(t, rec.Keys, 2)
+	require.Equal(t, "replace", rec.Keys[0].Op)
+	secret, err := os.ReadFile(AuditKeyPath(path))
+	require.Nil(t, err)
+	require.Len(t, secret, 32)
+	info, err := os.Stat(AuditKeyPath(path))
+	require.Nil(t, err)
+	if runtime.GOOS != "windows" {
+		require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+	require.Equal(t, hashValue(secret, int64(22)), rec.Keys[0].OldHash)
+	require.True(t, strings.HasPrefix(rec.Keys[0].NewHash, "hmac-sha256:"))
+	require.NotEqual(t, hashValue([]byte("another log"), int64(22)), rec.Keys[0].OldHash)
+	require.Equal(t, AuditKey{Op: "add", Path: "web.private_key", NewHash: hashValue(secret, "BEGIN-SECRET")}, rec.Keys[1])
+
+	// Removing a table lists every key in it.
+	require.Nil(t, toml.Clear("web"))
+	require.Nil(t, toml.Write())
+	records, err = ReadAudit(path)
+	require.Nil(t, err)
+	require.Len(t, records, 2)
+	require.Equal(t, []AuditKey{
+		{Op: "remove", Path: "web.port", OldHash: hashValue(secret, int64(2222))},
+		{Op: "remove", Path: "web.private_key", OldHash: hashValue(secret, "BEGIN-SECRET")},
+	}, records[1].Keys)
+}
+
+func TestAuditRead(t *testing.T) {
+	path := writeSample(t, "[web]\nport = 22\nprivate_key = \"k\"\n[[keys]]\nprivate_key = \"k2\"\n")
+	toml, err := NewToml(path)
+	require.Nil(t, err)
+	defer toml.Close()
+
+	results, err := toml.Query(".")
+	require.Nil(t, err)
+	paths := SensitivePaths(results)
+	require.Equal(t, []string{"keys[0].private_key", "web.private_key"}, []string{paths[0].String(), paths[1].String()})
+	require.Nil(t, LogRead(path, paths))
+
+	records, err := ReadAudit(path)
+	require.Nil(t, err)
+	require.Len(t, records, 1)
+	require.Equal(t, AuditRead, records[0].Action)
+	require.Equal(t, "read", records[0].Keys[0].Op)
+}
+
+func TestMaskResults(t *testing.T) {
+	path := writeSample(t, "[web]\nport = 22\nprivate_key = \"k\"\n[[keys]]\nprivate_key = \"k2\"\n")
+	toml, err := NewToml(path)
+	require.Nil(t, err)
+	defer toml.Close()
+
+	results, err := toml.Query(".")
+	require.Nil(t, err)
+	out, err := FormatResults(MaskResults(results, "***"), FormatJson)
+	require.Nil(t, err)
+	require.NotContains(t, out, `"k"`)
+	require.NotContains(t, out, `"k2"`)
+	require.Contains(t, out, `"port": 22`)
+
+	results, err = toml.Query("..private_key")
+	require.Nil(t, err)
+	for _, r := range MaskResults(results, "***") {
+		require.Equal(t, "***", r.Value)
+	}
+	// The file is not changed.
+	require.Equal(t, "k", toml.Get("web.private_key"))
+}
+
+func TestAuditKeyMatches(t *testing.T) {
+	k := AuditKey{Path: `"ns:host:web".ssh.port`}
+	require.True(t, k.Matches("ns:host:web"))
+	require.True(t, k.Matches("*:host:*"))
+	require.True(t, k.Matches(`"ns:host:web".ssh`))
+	require.False(t, k.Matches("ns:host:db"))
+}
+
+func TestAuditFailedWrite(t *testing.T) {
+	if runtime.GOOS == "windows" || os.Geteuid() == 0 {
+		t.Skip("needs directory permissions to apply")
+	}
+	path := writeSample(t, "[web]\nport = 22\n")
+	toml, err := NewToml(path)
+	require.Nil(t, err)
+	defer toml.Close()
+
+	// A write that fails is not logged.
+	dir := filepath.Dir(path)
+	require.Nil(t, os.WriteFile(AuditLogPath(path), nil, 0600))
+	require.Nil(t, os.Chmod(dir, 0500))
+	defer os.Chmod(dir, 0700)
+	require.Nil(t, toml.Set("web", "port", int64(2222)))
+	require.NotNil(t, toml.Write())
+	records, err := ReadAudit(path)
+	require.Nil(t, err)
+	require.Empty(t, records)
+}
@@ -0,0 +1,60 @@
+package toml
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	lib "github.com/pelletier/go-toml"
+)
+
+// Diff lists the changes turning this file into other.
+func (t *Toml) Diff(other *Toml) []Change {
+	return diffTrees(t.tree, other.tree)
+}
+
+// Diff lists the changes turning old into new, two values such as those
+// returned by Get. Paths are relative to the values; when they are not both
+// tables and differ, the single change has an empty path.
+func Diff(old, new interface{}) []Change {
+	ot, oIsTree := old.(*lib.Tree)
+	nt, nIsTree := new.(*lib.Tree)
+	if oIsTree && nIsTree {
+		return diffTrees(ot, nt)
+	}
+	if valuesEqual(old, new) {
+		return nil
+	}
+	return []Change{{Kind: ChangeReplace, Old: old, New: new}}
+}
+
+// TypeChanged reports whether a replaced value changed its type, such as the
+// string "22" becoming the integer 22.
+func (c Change) TypeChanged() bool {
+	return c.Kind == ChangeReplace && TypeOf(c.Old) != TypeOf(c.New)
+}
+
+// MarshalJSON writes the change as {"op": ..., "path": ..., "old": ...,
+// "new": ...}, leaving out the side that does not exist.
+func (c Change) MarshalJSON() ([]byte, error) {
+	m := map[string]interface{}{"op": c.Kind.String(), "path": c.Path.String()}
+	if c.Kind != ChangeAdd {
+		m["old"] = plainValue(c.Old)
+	}
+	if c.Kind != ChangeRemove {
+		m["new"] = plainValue(c.New)
+	}
+	if c.TypeChanged() {
+		m["old_type"], m["new_type"] = TypeOf(c.Old), TypeOf(c.New)
+	}
+	return json.Marshal(m)
+}
+
+// FormatValue renders a value as an inline TOML literal.
+func FormatValue(v interface{}) string {
+	s, err := renderValue(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return strings.ReplaceAll(s, "\n", " ")
+}
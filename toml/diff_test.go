@@ -0,0 +1,49 @@
+package toml
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiff(t *testing.T) {
+	old := loadSample(t, "[web]\nport = \"22\"\nuser = \"root\"\n")
+	new := loadSample(t, "[web]\nport = 22\n[db]\nport = 5432\n")
+
+	changes := old.Diff(new)
+	require.Len(t, changes, 3)
+	require.Equal(t, ChangeAdd, changes[0].Kind)
+	require.Equal(t, "db", changes[0].Path.String())
+	require.Equal(t, ChangeRemove, changes[1].Kind)
+	require.Equal(t, "web.user", changes[1].Path.String())
+	require.Equal(t, ChangeReplace, changes[2].Kind)
+	require.True(t, changes[2].TypeChanged())
+
+	data, err := json.Marshal(changes[2])
+	require.Nil(t, err)
+	require.JSONEq(t, `{"op": "replace", "path": "web.port", "old": "22", "new": 22,
+		"old_type": "string", "new_type": "int"}`, string(data))
+
+	require.Len(t, Diff(old.Get("web"), new.Get("web")), 2)
+	require.Nil(t, Diff(int64(1), int64(1)))
+	changes = Diff("a", int64(1))
+	require.Len(t, changes, 1)
+	require.Empty(t, changes[0].Path)
+}
+
+func TestMarshalPatch(t *testing.T) {
+	p, err := ParsePath(`"a/b~c".servers[1]`)
+	require.Nil(t, err)
+	require.Equal(t, "/a~1b~0c/servers/1", p.JSONPointer())
+
+	old := loadSample(t, "[web]\nport = 22\nuser = \"root\"\n")
+	new := loadSample(t, "[web]\nport = 2222\nenabled = false\n")
+	data, err := MarshalPatch(old.Diff(new))
+	require.Nil(t, err)
+	require.JSONEq(t, `[
+		{"op": "remove", "path": "/web/user"},
+		{"op": "add", "path": "/web/enabled", "value": false},
+		{"op": "replace", "path": "/web/port", "value": 2222}
+	]`, string(data))
+}
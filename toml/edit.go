@@ -0,0 +1,913 @@
+package toml
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	lib "github.com/pelletier/go-toml"
+)
+
+// The edit engine keeps the bytes a file was loaded from and, on write,
+// patches only the spans whose values changed. Comments, key order, quoting
+// and blank lines of untouched entries survive a set/del/rename unchanged.
+//
+// The [[array]] sections of an array of tables are indexed by element, as
+// array[0], array[1], ..., so that an edit of one element patches that
+// element only; elements removed or appended are cut out or inserted after
+// the last one. When a change cannot be mapped onto the original text
+// (implicit tables created by dotted keys, ...) or the patched text does not
+// parse back into the expected tree, the whole tree is re-serialized instead.
+
+// entrySpan locates a `key = value` line.
+type entrySpan struct {
+	lineStart, lineEnd   int
+	valueStart, valueEnd int
+	indent               string
+}
+
+// tableSpan locates a [table] header and its body.
+type tableSpan struct {
+	leadStart    int // first leading comment line, or the header itself
+	headerStart  int
+	headerEnd    int
+	lastEntryEnd int
+	regionEnd    int // start of the next header block, or EOF
+	indent       string
+	entryIndent  string
+}
+
+// document indexes the entries and tables of a TOML text by path. Sections
+// of arrays of tables are tables at the path of their element, such as
+// servers[1] or servers[1].disks[0].
+type document struct {
+	raw     []byte
+	entries map[string]*entrySpan
+	tables  map[string]*tableSpan
+	indent  string // indentation unit used below headers, "" if flush
+}
+
+// scanDocument indexes raw.
+func scanDocument(raw []byte) (*document, error) {
+	doc := &document{
+		raw:     raw,
+		entries: make(map[string]*entrySpan),
+		tables:  make(map[string]*tableSpan),
+	}
+	root := &tableSpan{headerStart: -1, leadStart: -1, regionEnd: len(raw)}
+	doc.tables[""] = root
+
+	// elements counts the elements of each array of tables seen so far.
+	elements := make(map[string]int)
+	current, currentPath := root, Path(nil)
+	blockStart := -1
+	indentFound := false
+
+	pos := 0
+	for pos < len(raw) {
+		lineStart := pos
+		i := skipSpace(raw, pos)
+		if i >= len(raw) || raw[i] == '\n' || raw[i] == '\r' {
+			pos = nextLine(raw, i)
+			blockStart = -1
+			continue
+		}
+		if raw[i] == '#' {
+			if blockStart < 0 {
+				blockStart = lineStart
+			}
+			pos = nextLine(raw, i)
+			continue
+		}
+
+		if raw[i] == '[' {
+			isArray := i+1 < len(raw) && raw[i+1] == '['
+			start := i + 1
+			if isArray {
+				start++
+			}
+			keys, end, err := scanTomlKeys(raw, start)
+			if err != nil {
+				return nil, err
+			}
+			if end >= len(raw) || raw[end] != ']' || (isArray && (end+1 >= len(raw) || raw[end+1] != ']')) {
+				return nil, fmt.Errorf("malformed table header at offset %d", lineStart)
+			}
+			lead := lineStart
+			if blockStart >= 0 {
+				lead = blockStart
+			}
+			if current != nil {
+				current.regionEnd = lead
+			}
+			lineEnd := nextLine(raw, end)
+			var p Path
+			if isArray {
+				// A new element of the array at p
+				p = elementPath(keys[:len(keys)-1], elements).Append(PathSegment{Key: keys[len(keys)-1]})
+				n := elements[p.String()]
+				elements[p.String()] = n + 1
+				p = p.Append(PathSegment{Index: n, IsIndex: true})
+			} else {
+				p = elementPath(keys, elements)
+			}
+			span := &tableSpan{
+				leadStart:    lead,
+				headerStart:  lineStart,
+				headerEnd:    lineEnd,
+				lastEntryEnd: lineEnd,
+				regionEnd:    len(raw),
+				indent:       string(raw[lineStart:i]),
+			}
+			doc.tables[p.String()] = span
+			current, currentPath = span, p
+			blockStart = -1
+			pos = lineEnd
+			continue
+		}
+
+		keys, end, err := scanTomlKeys(raw, i)
+		if err != nil {
+			return nil, err
+		}
+		if end >= len(raw) || raw[end] != '=' {
+			return nil, fmt.Errorf("expected '=' at offset %d", end)
+		}
+		valueStart := skipSpace(raw, end+1)
+		valueEnd, stop, err := scanValueEnd(raw, valueStart)
+		if err != nil {
+			return nil, err
+		}
+		lineEnd := nextLine(raw, stop)
+		entry := &entrySpan{
+			lineStart:  lineStart,
+			lineEnd:    lineEnd,
+			valueStart: valueStart,
+			valueEnd:   valueEnd,
+			indent:     string(raw[lineStart:i]),
+		}
+		doc.entries[currentPath.Append(keyPath(keys)...).String()] = entry
+		current.lastEntryEnd = lineEnd
+		if current != root && !indentFound {
+			doc.indent = strings.TrimPrefix(entry.indent, current.indent)
+			indentFound = true
+		}
+		if current.entryIndent == "" {
+			current.entryIndent = entry.indent
+		}
+		blockStart = -1
+		pos = lineEnd
+	}
+	return doc, nil
+}
+
+func keyPath(keys []string) Path {
+	p := make(Path, len(keys))
+	for i, k := range keys {
+		p[i] = PathSegment{Key: k}
+	}
+	return p
+}
+
+// elementPath returns the path of the header keys: a key naming an array of
+// tables seen so far stands for its last element, as in TOML.
+func elementPath(keys []string, elements map[string]int) Path {
+	var p Path
+	for _, k := range keys {
+		p = p.Append(PathSegment{Key: k})
+		if n, ok := elements[p.String()]; ok {
+			p = p.Append(PathSegment{Index: n - 1, IsIndex: true})
+		}
+	}
+	return p
+}
+
+func hasPrefix(p, prefix Path) bool {
+	if len(prefix) > len(p) {
+		return false
+	}
+	for i := range prefix {
+		if p[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func skipSpace(raw []byte, i int) int {
+	for i < len(raw) && (raw[i] == ' ' || raw[i] == '\t') {
+		i++
+	}
+	return i
+}
+
+// nextLine returns the offset just after the newline ending the line at i.
+func nextLine(raw []byte, i int) int {
+	if j := bytes.IndexByte(raw[i:], '\n'); j >= 0 {
+		return i + j + 1
+	}
+	return len(raw)
+}
+
+// scanTomlKeys reads a dotted key starting at i and returns its parts and the
+// offset of the first byte after it (and after trailing whitespace).
+func scanTomlKeys(raw []byte, i int) ([]string, int, error) {
+	var keys []string
+	for {
+		i = skipSpace(raw, i)
+		if i >= len(raw) {
+			return nil, i, fmt.Errorf("unexpected end of file in key")
+		}
+		switch raw[i] {
+		case '"', '\'':
+			key, n, err := scanPathKey(lineAt(raw, i))
+			if err != nil {
+				return nil, i, fmt.Errorf("offset %d: %w", i, err)
+			}
+			keys = append(keys, key)
+			i += n
+		default:
+			j := i
+			for j < len(raw) && isBareKeyChar(raw[j]) {
+				j++
+			}
+			if j == i {
+				return nil, i, fmt.Errorf("offset %d: invalid key", i)
+			}
+			keys = append(keys, string(raw[i:j]))
+			i = j
+		}
+		i = skipSpace(raw, i)
+		if i < len(raw) && raw[i] == '.' {
+			i++
+			continue
+		}
+		return keys, i, nil
+	}
+}
+
+func lineAt(raw []byte, i int) string {
+	end := bytes.IndexByte(raw[i:], '\n')
+	if end < 0 {
+		return string(raw[i:])
+	}
+	return string(raw[i : i+end])
+}
+
+func isBareKeyChar(c byte) bool {
+	return c == '_' || c == '-' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// scanValueEnd finds the end of the value starting at i. It returns the end of
+// the value itself and the offset where scanning stopped (a comment or the
+// newline ending the value).
+func scanValueEnd(raw []byte, i int) (int, int, error) {
+	depth := 0
+	for i < len(raw) {
+		switch c := raw[i]; {
+		case bytes.HasPrefix(raw[i:], []byte(`"""`)):
+			end := closingQuote(raw, i+3, `"""`, true)
+			if end < 0 {
+				return 0, 0, fmt.Errorf("unterminated multi-line string")
+			}
+			i = end
+		case bytes.HasPrefix(raw[i:], []byte(`'''`)):
+			end := closingQuote(raw, i+3, `'''`, false)
+			if end < 0 {
+				return 0, 0, fmt.Errorf("unterminated multi-line string")
+			}
+			i = end
+		case c == '"':
+			end := closingQuote(raw, i+1, `"`, true)
+			if end < 0 {
+				return 0, 0, fmt.Errorf("unterminated string")
+			}
+			i = end
+		case c == '\'':
+			end := closingQuote(raw, i+1, `'`, false)
+			if end < 0 {
+				return 0, 0, fmt.Errorf("unterminated string")
+			}
+			i = end
+		case c == '[' || c == '{':
+			depth++
+			i++
+		case c == ']' || c == '}':
+			depth--
+			i++
+		case c == '#':
+			if depth == 0 {
+				return trimRight(raw, i), i, nil
+			}
+			i = nextLine(raw, i)
+		case c == '\n':
+			if depth == 0 {
+				return trimRight(raw, i), i, nil
+			}
+			i++
+		default:
+			i++
+		}
+	}
+	return trimRight(raw, i), i, nil
+}
+
+// closingQuote returns the offset after the delimiter closing a string body
+// that starts at i.
+func closingQuote(raw []byte, i int, delim string, escapes bool) int {
+	for i < len(raw) {
+		if escapes && raw[i] == '\\' {
+			i += 2
+			continue
+		}
+		if bytes.HasPrefix(raw[i:], []byte(delim)) {
+			end := i + len(delim)
+			// A multi-line string may end with up to two extra quotes.
+			for len(delim) == 3 && end < len(raw) && raw[end] == delim[0] && end-i < 5 {
+				end++
+			}
+			return end
+		}
+		if len(delim) == 1 && raw[i] == '\n' {
+			return -1
+		}
+		i++
+	}
+	return -1
+}
+
+func trimRight(raw []byte, i int) int {
+	for i > 0 && (raw[i-1] == ' ' || raw[i-1] == '\t' || raw[i-1] == '\r') {
+		i--
+	}
+	return i
+}
+
+// ChangeKind tells how a value differs between two trees.
+type ChangeKind int
+
+const (
+	ChangeAdd ChangeKind = iota
+	ChangeRemove
+	ChangeReplace
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case ChangeAdd:
+		return "add"
+	case ChangeRemove:
+		return "remove"
+	}
+	return "replace"
+}
+
+// Change is a single difference between two trees. Tables are compared key by
+// key; any other value, including arrays, is compared as a whole.
+type Change struct {
+	Kind ChangeKind
+	Path Path
+	Old  interface{}
+	New  interface{}
+}
+
+// diffTrees lists the changes turning old into new, sorted by path.
+func diffTrees(old, new *lib.Tree) []Change {
+	var changes []Change
+	diffTree(nil, old, new, &changes)
+	return changes
+}
+
+func diffTree(p Path, old, new *lib.Tree, changes *[]Change) {
+	for _, k := range sortedKeys(old) {
+		if !new.HasPath([]string{k}) {
+			*changes = append(*changes, Change{Kind: ChangeRemove, Path: p.Append(PathSegment{Key: k}), Old: old.GetPath([]string{k})})
+		}
+	}
+	for _, k := range sortedKeys(new) {
+		kp := p.Append(PathSegment{Key: k})
+		nv := new.GetPath([]string{k})
+		if !old.HasPath([]string{k}) {
+			*changes = append(*changes, Change{Kind: ChangeAdd, Path: kp, New: nv})
+			continue
+		}
+		ov := old.GetPath([]string{k})
+		ot, oIsTree := ov.(*lib.Tree)
+		nt, nIsTree := nv.(*lib.Tree)
+		if oIsTree && nIsTree {
+			diffTree(kp, ot, nt, changes)
+			continue
+		}
+		if !valuesEqual(ov, nv) {
+			*changes = append(*changes, Change{Kind: ChangeReplace, Path: kp, Old: ov, New: nv})
+		}
+	}
+}
+
+func sortedKeys(t *lib.Tree) []string {
+	keys := t.Keys()
+	sort.Strings(keys)
+	return keys
+}
+
+// valuesEqual compares two tree values by content.
+func valuesEqual(a, b interface{}) bool {
+	return reflect.DeepEqual(plainValue(a), plainValue(b))
+}
+
+// plainValue converts tables into maps so values can be compared deeply.
+func plainValue(v interface{}) interface{} {
+	switch n := v.(type) {
+	case *lib.Tree:
+		return n.ToMap()
+	case []*lib.Tree:
+		res := make([]interface{}, len(n))
+		for i, t := range n {
+			res[i] = t.ToMap()
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, len(n))
+		for i, e := range n {
+			res[i] = plainValue(e)
+		}
+		return res
+	}
+	return v
+}
+
+// edit replaces raw[start:end] with text.
+type edit struct {
+	start, end int
+	text       string
+}
+
+// patchDocument rewrites raw so it parses into tree, touching only the spans
+// that changed. ok is false when the changes could not be applied in place.
+func patchDocument(raw []byte, tree *lib.Tree) (out []byte, ok bool) {
+	old, err := lib.LoadBytes(raw)
+	if err != nil {
+		return nil, false
+	}
+	doc, err := scanDocument(raw)
+	if err != nil {
+		return nil, false
+	}
+	changes := doc.elementChanges(diffTrees(old, tree))
+	if len(changes) == 0 {
+		return raw, true
+	}
+
+	var edits []edit
+	var appended strings.Builder
+	rerendered := make(map[string]bool)
+	for _, c := range changes {
+		if anc, span := doc.entryAncestor(c.Path); span != nil {
+			// The change is inside an inline table: rewrite the whole value.
+			if !rerendered[anc.String()] {
+				rerendered[anc.String()] = true
+				text, err := renderValue(getPath(tree, anc))
+				if err != nil {
+					return nil, false
+				}
+				edits = append(edits, edit{span.valueStart, span.valueEnd, text})
+			}
+			continue
+		}
+
+		if c.Kind == ChangeReplace {
+			if span, found := doc.entries[c.Path.String()]; found {
+				text, err := renderValue(c.New)
+				if err != nil {
+					return nil, false
+				}
+				edits = append(edits, edit{span.valueStart, span.valueEnd, text})
+				continue
+			}
+		}
+
+		if c.Kind != ChangeAdd {
+			removals, found := doc.removals(c.Path)
+			if !found {
+				return nil, false
+			}
+			edits = append(edits, removals...)
+		}
+		if c.Kind != ChangeRemove {
+			e, text, err := doc.addition(c.Path, c.New)
+			if err != nil {
+				return nil, false
+			}
+			if e != nil {
+				edits = append(edits, *e)
+			}
+			appended.WriteString(text)
+		}
+	}
+
+	out, ok = applyEdits(raw, edits)
+	if !ok {
+		return nil, false
+	}
+	if appended.Len() > 0 {
+		text := appended.String()
+		if len(out) == 0 || bytes.HasSuffix(out, []byte("\n\n")) {
+			text = strings.TrimPrefix(text, "\n")
+		} else if out[len(out)-1] != '\n' {
+			out = append(out, '\n')
+		}
+		out = append(out, text...)
+	}
+
+	// Never trust the patch blindly: it has to load back into the same tree.
+	check, err := lib.LoadBytes(out)
+	if err != nil || !valuesEqual(check, tree) {
+		return nil, false
+	}
+	return out, true
+}
+
+// elementChanges splits the replacement of an array of tables written as
+// [[array]] sections into changes of its elements, so that only the elements
+// that changed are patched. Elements that were removed, or appended, are
+// removed or added as a whole.
+func (d *document) elementChanges(changes []Change) []Change {
+	var res []Change
+	for _, c := range changes {
+		ov, oIsArray := c.Old.([]*lib.Tree)
+		nv, nIsArray := c.New.([]*lib.Tree)
+		_, sections := d.tables[c.Path.Append(PathSegment{Index: 0, IsIndex: true}).String()]
+		if c.Kind != ChangeReplace || !oIsArray || !nIsArray || !sections {
+			res = append(res, c)
+			continue
+		}
+		element := func(i int) Path { return c.Path.Append(PathSegment{Index: i, IsIndex: true}) }
+
+		// Elements removed, the others left as they are
+		if kept := keptElements(ov, nv); kept != nil {
+			for i := range ov {
+				if !kept[i] {
+					res = append(res, Change{Kind: ChangeRemove, Path: element(i), Old: ov[i]})
+				}
+			}
+			continue
+		}
+		n := len(ov)
+		if len(nv) < n {
+			n = len(nv)
+		}
+		var sub []Change
+		for i := 0; i < n; i++ {
+			diffTree(element(i), ov[i], nv[i], &sub)
+		}
+		res = append(res, d.elementChanges(sub)...)
+		for i := n; i < len(ov); i++ {
+			res = append(res, Change{Kind: ChangeRemove, Path: element(i), Old: ov[i]})
+		}
+		for i := n; i < len(nv); i++ {
+			res = append(res, Change{Kind: ChangeAdd, Path: element(i), New: nv[i]})
+		}
+	}
+	return res
+}
+
+// keptElements returns which elements of old are kept if new is old with
+// some elements removed, nil otherwise.
+func keptElements(old, new []*lib.Tree) []bool {
+	if len(new) >= len(old) {
+		return nil
+	}
+	kept := make([]bool, len(old))
+	j := 0
+	for i := range old {
+		if j < len(new) && valuesEqual(old[i], new[j]) {
+			kept[i] = true
+			j++
+		}
+	}
+	if j < len(new) {
+		return nil
+	}
+	return kept
+}
+
+// sectionEnd returns the end of the sections at or below p.
+func (d *document) sectionEnd(p Path) (int, bool) {
+	end, found := 0, false
+	for key, span := range d.tables {
+		if key == "" {
+			continue
+		}
+		tp, _ := ParsePath(key)
+		if hasPrefix(tp, p) && span.regionEnd > end {
+			end, found = span.regionEnd, true
+		}
+	}
+	return end, found
+}
+
+// entryAncestor returns the closest proper ancestor of p that is an entry
+// (an inline table or array written on a `key = value` line).
+func (d *document) entryAncestor(p Path) (Path, *entrySpan) {
+	for i := len(p) - 1; i > 0; i-- {
+		if span, ok := d.entries[p[:i].String()]; ok {
+			return p[:i], span
+		}
+	}
+	return nil, nil
+}
+
+// removals returns the edits deleting everything located at or below p.
+func (d *document) removals(p Path) ([]edit, bool) {
+	if span, ok := d.entries[p.String()]; ok {
+		return []edit{{span.lineStart, span.lineEnd, ""}}, true
+	}
+
+	var regions []edit
+	for key, span := range d.tables {
+		if key == "" {
+			continue
+		}
+		tp, _ := ParsePath(key)
+		if hasPrefix(tp, p) {
+			regions = append(regions, edit{span.leadStart, span.regionEnd, ""})
+		}
+	}
+	if len(regions) == 0 {
+		return nil, false
+	}
+	edits := append([]edit{}, regions...)
+	for key, span := range d.entries {
+		ep, _ := ParsePath(key)
+		if !hasPrefix(ep, p) {
+			continue
+		}
+		inside := false
+		for _, r := range regions {
+			if span.lineStart >= r.start && span.lineEnd <= r.end {
+				inside = true
+				break
+			}
+		}
+		if !inside {
+			edits = append(edits, edit{span.lineStart, span.lineEnd, ""})
+		}
+	}
+	return edits, true
+}
+
+// addition returns either an insertion into an existing table or the text of
+// new table sections to append at the end of the document. Sections within
+// an element of an array of tables, and new elements, are inserted after the
+// sections of that element, or array.
+func (d *document) addition(p Path, value interface{}) (*edit, string, error) {
+	var text string
+	var err error
+	switch v := value.(type) {
+	case *lib.Tree:
+		if p[len(p)-1].IsIndex {
+			text, err = renderArrayTables(p[:len(p)-1], []*lib.Tree{v}, d.indent)
+		} else {
+			text, err = renderTable(p, v, d.indent)
+		}
+	case []*lib.Tree:
+		text, err = renderArrayTables(p, v, d.indent)
+	default:
+		return d.entryAddition(p, value)
+	}
+	if err != nil || !inArray(p[:len(p)-1]) && !p[len(p)-1].IsIndex {
+		return nil, text, err
+	}
+	at, ok := d.sectionEnd(p[:len(p)-1])
+	if !ok {
+		return nil, "", fmt.Errorf("sections of %s are not located", p[:len(p)-1])
+	}
+	if at == len(d.raw) {
+		return nil, text, nil
+	}
+	if at >= 2 && string(d.raw[at-2:at]) == "\n\n" {
+		// Keep the blank line before the next section
+		text = strings.TrimPrefix(text, "\n") + "\n"
+	}
+	return &edit{at, at, text}, "", nil
+}
+
+// inArray reports whether p is within an element of an array of tables.
+func inArray(p Path) bool {
+	for _, seg := range p {
+		if seg.IsIndex {
+			return true
+		}
+	}
+	return false
+}
+
+// entryAddition returns the insertion of the entry p into its table.
+func (d *document) entryAddition(p Path, value interface{}) (*edit, string, error) {
+
+	parent, ok := d.tables[p[:len(p)-1].String()]
+	if !ok {
+		return nil, "", fmt.Errorf("table %s is not located", p[:len(p)-1])
+	}
+	text, err := renderValue(value)
+	if err != nil {
+		return nil, "", err
+	}
+	indent := parent.entryIndent
+	if indent == "" && parent.headerStart >= 0 {
+		indent = parent.indent + d.indent
+	}
+	line := indent + renderKey(p[len(p)-1].Key) + " = " + text + "\n"
+
+	at := parent.lastEntryEnd
+	if parent.headerStart < 0 && parent.lastEntryEnd == 0 {
+		// Nothing at the top level yet: put it before the first table.
+		at = parent.regionEnd
+	}
+	if at > 0 && d.raw[at-1] != '\n' {
+		line = "\n" + line
+	}
+	return &edit{at, at, line}, "", nil
+}
+
+// applyEdits applies non-overlapping edits. Insertions go before a removal
+// starting at the same offset and otherwise keep their order.
+func applyEdits(raw []byte, edits []edit) ([]byte, bool) {
+	sort.SliceStable(edits, func(i, j int) bool {
+		if edits[i].start != edits[j].start {
+			return edits[i].start < edits[j].start
+		}
+		return edits[i].start == edits[i].end && edits[j].start != edits[j].end
+	})
+	var out bytes.Buffer
+	pos := 0
+	for _, e := range edits {
+		if e.start < pos {
+			// Removal regions may be listed twice (e.g. a table and its
+			// parent); anything else overlapping is a conflict.
+			if e.text == "" && e.end <= pos {
+				continue
+			}
+			return nil, false
+		}
+		out.Write(raw[pos:e.start])
+		out.WriteString(e.text)
+		pos = e.end
+	}
+	out.Write(raw[pos:])
+	return out.Bytes(), true
+}
+
+// renderKey writes a key bare when possible and quoted otherwise.
+func renderKey(k string) string {
+	bare := k != ""
+	for i := 0; i < len(k); i++ {
+		if !isBareKeyChar(k[i]) {
+			bare = false
+			break
+		}
+	}
+	if bare {
+		return k
+	}
+	s, _ := renderScalar(k)
+	return s
+}
+
+// renderKeyPath writes p as a header. Indexes are left out: in a header an
+// array of tables stands for its last element, where the section is put.
+func renderKeyPath(p Path) string {
+	parts := make([]string, 0, len(p))
+	for _, seg := range p {
+		if !seg.IsIndex {
+			parts = append(parts, renderKey(seg.Key))
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// renderScalar lets go-toml format a single value.
+func renderScalar(v interface{}) (string, error) {
+	t, err := lib.TreeFromMap(map[string]interface{}{"v": v})
+	if err != nil {
+		return "", err
+	}
+	s, err := t.ToTomlString()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(s, "v = "), "\n"), nil
+}
+
+// renderValue formats a value for the right hand side of `key = value`;
+// tables are written inline.
+func renderValue(v interface{}) (string, error) {
+	switch n := v.(type) {
+	case *lib.Tree:
+		keys := sortedKeys(n)
+		if len(keys) == 0 {
+			return "{}", nil
+		}
+		parts := make([]string, len(keys))
+		for i, k := range keys {
+			s, err := renderValue(n.GetPath([]string{k}))
+			if err != nil {
+				return "", err
+			}
+			parts[i] = renderKey(k) + " = " + s
+		}
+		return "{ " + strings.Join(parts, ", ") + " }", nil
+	case []*lib.Tree:
+		parts := make([]string, len(n))
+		for i, t := range n {
+			s, err := renderValue(t)
+			if err != nil {
+				return "", err
+			}
+			parts[i] = s
+		}
+		return "[" + strings.Join(parts, ", ") + "]", nil
+	case []interface{}:
+		parts := make([]string, len(n))
+		for i, e := range n {
+			s, err := renderValue(e)
+			if err != nil {
+				return "", err
+			}
+			parts[i] = s
+		}
+		return "[" + strings.Join(parts, ", ") + "]", nil
+	}
+	return renderScalar(v)
+}
+
+// keyDepth returns the number of keys in p, which headers are indented by.
+func keyDepth(p Path) int {
+	n := 0
+	for _, seg := range p {
+		if !seg.IsIndex {
+			n++
+		}
+	}
+	return n
+}
+
+// renderTable writes tree as a [p] section followed by its sub-tables.
+func renderTable(p Path, tree *lib.Tree, indent string) (string, error) {
+	var b strings.Builder
+	pad := strings.Repeat(indent, keyDepth(p)-1)
+	fmt.Fprintf(&b, "\n%s[%s]\n", pad, renderKeyPath(p))
+	if err := renderBody(&b, p, tree, indent); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func renderArrayTables(p Path, trees []*lib.Tree, indent string) (string, error) {
+	var b strings.Builder
+	pad := strings.Repeat(indent, keyDepth(p)-1)
+	for _, t := range trees {
+		fmt.Fprintf(&b, "\n%s[[%s]]\n", pad, renderKeyPath(p))
+		if err := renderBody(&b, p, t, indent); err != nil {
+			return "", err
+		}
+	}
+	return b.String(), nil
+}
+
+// renderBody writes the plain entries of tree, then its nested sections.
+func renderBody(b *strings.Builder, p Path, tree *lib.Tree, indent string) error {
+	pad := strings.Repeat(indent, keyDepth(p))
+	keys := sortedKeys(tree)
+	for _, k := range keys {
+		switch tree.GetPath([]string{k}).(type) {
+		case *lib.Tree, []*lib.Tree:
+			continue
+		}
+		s, err := renderValue(tree.GetPath([]string{k}))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(b, "%s%s = %s\n", pad, renderKey(k), s)
+	}
+	for _, k := range keys {
+		kp := p.Append(PathSegment{Key: k})
+		var text string
+		var err error
+		switch v := tree.GetPath([]string{k}).(type) {
+		case *lib.Tree:
+			text, err = renderTable(kp, v, indent)
+		case []*lib.Tree:
+			text, err = renderArrayTables(kp, v, indent)
+		default:
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		b.WriteString(text)
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package toml
+
+import (
+	"strings"
+	"testing"
+
+	lib "github.com/pelletier/go-toml"
+	"github.com/stretchr/testify/require"
+)
+
+const editSample = `# team cmdb
+title = 'hosts'   # kept as literal string
+
+# web frontends
+["ns:host:web"]
+hostname = "10.0.0.1"  # primary
+port     = 22
+tags = [ "web",
+         "edge" ]  # multi-line array
+opts = { agent = true }
+
+# databases
+["ns:host:db"]
+hostname = "10.0.0.2"
+
+[misc]
+note = """
+multi
+line"""
+`
+
+func loadSample(t *testing.T, raw string) *Toml {
+	tree, err := lib.Load(raw)
+	require.Nil(t, err)
+	return &Toml{raw: []byte(raw), tree: tree}
+}
+
+func render(t *testing.T, toml *Toml) string {
+	out, err := toml.Render()
+	require.Nil(t, err)
+	return string(out)
+}
+
+func TestRenderUnchanged(t *testing.T) {
+	toml := loadSample(t, editSample)
+	require.Equal(t, editSample, render(t, toml))
+}
+
+func TestRenderReplaceKeepsLayout(t *testing.T) {
+	toml := loadSample(t, editSample)
+	require.Nil(t, toml.Set("ns:host:web", "port", int64(2222)))
+	require.Nil(t, toml.Set("ns:host:web", "hostname", "10.0.0.9"))
+	require.Nil(t, toml.Set("ns:host:web", "opts.agent", false))
+
+	want := `# team cmdb
+title = 'hosts'   # kept as literal string
+
+# web frontends
+["ns:host:web"]
+hostname = "10.0.0.9"  # primary
+port     = 2222
+tags = [ "web",
+         "edge" ]  # multi-line array
+opts = { agent = false }
+
+# databases
+["ns:host:db"]
+hostname = "10.0.0.2"
+
+[misc]
+note = """
+multi
+line"""
+`
+	require.Equal(t, want, render(t, toml))
+}
+
+func TestRenderAddAndDelete(t *testing.T) {
+	toml := loadSample(t, editSample)
+	require.Nil(t, toml.Delete("ns:host:web", "tags"))
+	require.Nil(t, toml.Set("ns:host:db", "user", "admin"))
+	require.Nil(t, toml.Clear("misc"))
+	require.Nil(t, toml.Set("ns:host:new", "hostname", "10.0.0.3"))
+
+	want := `# team cmdb
+title = 'hosts'   # kept as literal string
+
+# web frontends
+["ns:host:web"]
+hostname = "10.0.0.1"  # primary
+port     = 22
+opts = { agent = true }
+
+# databases
+["ns:host:db"]
+hostname = "10.0.0.2"
+user = "admin"
+
+["ns:host:new"]
+hostname = "10.0.0.3"
+`
+	require.Equal(t, want, render(t, toml))
+}
+
+func TestRenderDeleteTableKeepsNeighbourComments(t *testing.T) {
+	toml := loadSample(t, editSample)
+	require.Nil(t, toml.Clear("ns:host:web"))
+
+	want := `# team cmdb
+title = 'hosts'   # kept as literal string
+
+# databases
+["ns:host:db"]
+hostname = "10.0.0.2"
+
+[misc]
+note = """
+multi
+line"""
+`
+	require.Equal(t, want, render(t, toml))
+}
+
+func TestRenderFallback(t *testing.T) {
+	// The implicit table a cannot be located to add c to.
+	raw := "a.b = 1\n"
+	toml := loadSample(t, raw)
+	require.Nil(t, toml.Set("a", "c", int64(2)))
+
+	out := render(t, toml)
+	tree, err := lib.Load(out)
+	require.Nil(t, err)
+	require.Equal(t, toml.tree.ToMap(), tree.ToMap())
+}
+
+func TestRenderReplaceArrayOfTables(t *testing.T) {
+	raw := "# cmdb\ntitle = \"x\" # kept\n\n[[servers]]\nip = \"1\"\n\n[[servers]]\nip = \"2\"\n"
+	toml := loadSample(t, raw)
+	require.Nil(t, toml.Delete("servers[0]", ""))
+
+	require.Equal(t, "# cmdb\ntitle = \"x\" # kept\n\n[[servers]]\nip = \"2\"\n", render(t, toml))
+}
+
+const arraySample = `# servers
+[[servers]]
+# first
+ip = "10.0.0.1"
+
+# second
+[[servers]]
+ip   = "10.0.0.2"  # keep
+name = "db"
+
+[servers.ssh]
+port = 22
+
+[misc]
+note = "x"
+`
+
+func TestRenderEditArrayElement(t *testing.T) {
+	toml := loadSample(t, arraySample)
+	require.Nil(t, toml.Set("servers[1]", "ip", "10.0.0.3"))
+	require.Nil(t, toml.Set("servers[1]", "ssh.port", int64(2222)))
+	require.Nil(t, toml.Set("servers[0]", "name", "web"))
+
+	want := strings.Replace(arraySample, `ip   = "10.0.0.2"`, `ip   = "10.0.0.3"`, 1)
+	want = strings.Replace(want, "port = 22", "port = 2222", 1)
+	want = strings.Replace(want, "ip = \"10.0.0.1\"\n", "ip = \"10.0.0.1\"\nname = \"web\"\n", 1)
+	require.Equal(t, want, render(t, toml))
+}
+
+func TestRenderAppendArrayElement(t *testing.T) {
+	toml := loadSample(t, arraySample)
+	servers := toml.Get("servers").([]*lib.Tree)
+	added, err := lib.TreeFromMap(map[string]interface{}{"ip": "10.0.0.9"})
+	require.Nil(t, err)
+	require.Nil(t, toml.Set("servers", "", append(servers, added)))
+
+	want := strings.Replace(arraySample, "port = 22\n\n", "port = 22\n\n[[servers]]\nip = \"10.0.0.9\"\n\n", 1)
+	require.Equal(t, want, render(t, toml))
+
+	// A table added to an element goes with its sections.
+	toml = loadSample(t, arraySample)
+	require.Nil(t, toml.Set("servers[0]", "ssh.port", int64(22)))
+	out := render(t, toml)
+	require.True(t, strings.HasPrefix(out, "# servers\n[[servers]]\n# first\nip = \"10.0.0.1\"\n\n[servers.ssh]\nport = 22\n\n# second\n"), out)
+}
+
+func TestRenderEmptyFile(t *testing.T) {
+	toml := loadSample(t, "")
+	require.Nil(t, toml.Set("ns:host:web", "port", int64(22)))
+	require.Equal(t, "[\"ns:host:web\"]\nport = 22\n", render(t, toml))
+}
+
+func TestChanges(t *testing.T) {
+	toml := loadSample(t, editSample)
+	require.Nil(t, toml.Set("ns:host:web", "port", "22"))
+	require.Nil(t, toml.Clear("misc"))
+
+	changes, err := toml.Changes()
+	require.Nil(t, err)
+	require.Len(t, changes, 2)
+	require.Equal(t, ChangeRemove, changes[0].Kind)
+	require.Equal(t, "misc", changes[0].Path.String())
+	require.Equal(t, ChangeReplace, changes[1].Kind)
+	require.Equal(t, "ns:host:web.port", changes[1].Path.String())
+	require.Equal(t, int64(22), changes[1].Old)
+	require.Equal(t, "22", changes[1].New)
+}
@@ -0,0 +1,493 @@
+package toml
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	lib "github.com/pelletier/go-toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Formats of Export. Decode reads json, yaml, toml and csv as well.
+const (
+	FormatToml       = "toml"
+	FormatJson       = "json"
+	FormatRaw        = "raw"
+	FormatYaml       = "yaml"
+	FormatDotenv     = "dotenv"
+	FormatProperties = "properties"
+	FormatIni        = "ini"
+	FormatCsv        = "csv"
+)
+
+// ExportOptions tune the output of Export.
+type ExportOptions struct {
+	// Columns are the attribute paths written by the csv format, in order.
+	// By default every attribute of the exported entries is written.
+	Columns []string
+}
+
+// Exporter writes query results in one output format.
+type Exporter func(w io.Writer, results []Result, opts ExportOptions) error
+
+var exporters = map[string]Exporter{
+	FormatToml:       exportToml,
+	FormatJson:       exportJson,
+	FormatRaw:        exportRaw,
+	FormatYaml:       exportYaml,
+	FormatDotenv:     exportDotenv,
+	FormatProperties: exportProperties,
+	FormatIni:        exportIni,
+	FormatCsv:        exportCsv,
+}
+
+// ExportFormats returns the names of the formats of Export.
+func ExportFormats() []string {
+	formats := make([]string, 0, len(exporters))
+	for f := range exporters {
+		formats = append(formats, f)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// Export writes query results to w in format.
+//
+// toml, yaml, dotenv, properties and ini place every result at its path in a
+// new document, so a whole file, a single key or the matches of a query can
+// be exported alike. json writes one JSON document per result, raw writes
+// scalars as plain lines (tables and arrays as compact JSON) and csv writes a
+// row per table.
+func Export(w io.Writer, results []Result, format string, opts ExportOptions) error {
+	if format == "" {
+		format = FormatToml
+	}
+	export, ok := exporters[format]
+	if !ok {
+		return fmt.Errorf("unknown format %q, expected one of %s", format, strings.Join(ExportFormats(), ", "))
+	}
+	return export(w, results, opts)
+}
+
+// FormatResults renders query results, see Export.
+func FormatResults(results []Result, format string) (string, error) {
+	var b strings.Builder
+	if err := Export(&b, results, format, ExportOptions{}); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// resultTree places every result at its path in a new tree. Results whose
+// path does not fit in a tree are stored under the path text; a scalar result
+// without a path is stored as value.
+func resultTree(results []Result) (*lib.Tree, error) {
+	tree := newTree()
+	for _, r := range results {
+		p := r.Path
+		if _, ok := p.Keys(); !ok {
+			p = Path{{Key: p.String()}}
+		}
+		if len(p) == 0 {
+			sub, ok := r.Value.(*lib.Tree)
+			if !ok {
+				p = Path{{Key: "value"}}
+			} else {
+				for _, k := range sub.Keys() {
+					tree.SetPath([]string{k}, sub.GetPath([]string{k}))
+				}
+				continue
+			}
+		}
+		if err := setPath(tree, p, r.Value); err != nil {
+			return nil, err
+		}
+	}
+	return tree, nil
+}
+
+func exportToml(w io.Writer, results []Result, _ ExportOptions) error {
+	tree, err := resultTree(results)
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	if err := renderBody(&b, nil, tree, ""); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, strings.TrimPrefix(b.String(), "\n"))
+	return err
+}
+
+func exportJson(w io.Writer, results []Result, _ ExportOptions) error {
+	for _, r := range results {
+		data, err := json.MarshalIndent(plainValue(r.Value), "", " ")
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(append(data, '\n')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func exportRaw(w io.Writer, results []Result, _ ExportOptions) error {
+	for _, r := range results {
+		var line string
+		switch r.Value.(type) {
+		case *lib.Tree, []*lib.Tree, []interface{}:
+			data, err := json.Marshal(plainValue(r.Value))
+			if err != nil {
+				return err
+			}
+			line = string(data)
+		default:
+			line = scalarString(r.Value)
+		}
+		if _, err := io.WriteString(w, line+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func exportYaml(w io.Writer, results []Result, _ ExportOptions) error {
+	tree, err := resultTree(results)
+	if err != nil {
+		return err
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(yamlValue(tree.ToMap())); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// yamlValue replaces the local dates and times of TOML, which YAML lacks, by
+// their text.
+func yamlValue(v interface{}) interface{} {
+	switch n := v.(type) {
+	case map[string]interface{}:
+		for k, e := range n {
+			n[k] = yamlValue(e)
+		}
+	case []interface{}:
+		for i, e := range n {
+			n[i] = yamlValue(e)
+		}
+	case lib.LocalDate, lib.LocalDateTime, lib.LocalTime:
+		return fmt.Sprint(n)
+	}
+	return v
+}
+
+// leaf is a scalar, or an array of scalars, and its path.
+type leaf struct {
+	Path  Path
+	Value interface{}
+}
+
+// leaves lists the values below tree in key order. Arrays are split into
+// their elements when splitArrays is set.
+func leaves(tree *lib.Tree, splitArrays bool) []leaf {
+	var res []leaf
+	var walk func(p Path, v interface{})
+	walk = func(p Path, v interface{}) {
+		switch n := v.(type) {
+		case *lib.Tree:
+			for _, k := range sortedKeys(n) {
+				walk(p.Append(PathSegment{Key: k}), n.GetPath([]string{k}))
+			}
+		case []*lib.Tree:
+			for i, e := range n {
+				walk(p.Append(PathSegment{Index: i, IsIndex: true}), e)
+			}
+		case []interface{}:
+			if !splitArrays {
+				res = append(res, leaf{p, n})
+				return
+			}
+			for i, e := range n {
+				walk(p.Append(PathSegment{Index: i, IsIndex: true}), e)
+			}
+		default:
+			res = append(res, leaf{p, v})
+		}
+	}
+	walk(nil, tree)
+	return res
+}
+
+// exportScalar returns the text of a scalar for the flat formats.
+func exportScalar(v interface{}) string {
+	if t, ok := v.(time.Time); ok {
+		return t.Format(time.RFC3339Nano)
+	}
+	return scalarString(v)
+}
+
+// exportDotenv writes KEY="value" lines. Names are the upper-cased path with
+// every other character than letters and digits replaced by an underscore,
+// e.g. ns:web.ssh.port becomes NS_WEB_SSH_PORT and tags[0] TAGS_0.
+func exportDotenv(w io.Writer, results []Result, _ ExportOptions) error {
+	tree, err := resultTree(results)
+	if err != nil {
+		return err
+	}
+	seen := map[string]Path{}
+	var b strings.Builder
+	for _, l := range leaves(tree, true) {
+		name := envName(l.Path)
+		if prev, ok := seen[name]; ok {
+			return fmt.Errorf("%s and %s both export as %s", prev, l.Path, name)
+		}
+		seen[name] = l.Path
+		value := exportScalar(l.Value)
+		if _, ok := l.Value.(string); ok {
+			value = envQuote(value)
+		}
+		fmt.Fprintf(&b, "%s=%s\n", name, value)
+	}
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+func envName(p Path) string {
+	parts := make([]string, len(p))
+	for i, seg := range p {
+		if seg.IsIndex {
+			parts[i] = strconv.Itoa(seg.Index)
+			continue
+		}
+		parts[i] = strings.Map(func(r rune) rune {
+			switch {
+			case r >= 'a' && r <= 'z':
+				return r - 'a' + 'A'
+			case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+				return r
+			}
+			return '_'
+		}, seg.Key)
+	}
+	name := strings.Join(parts, "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "_" + name
+	}
+	return name
+}
+
+var envEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`", "\n", `\n`, "\r", `\r`)
+
+func envQuote(s string) string {
+	return `"` + envEscaper.Replace(s) + `"`
+}
+
+// exportProperties writes a Java properties file keyed by the paths of the
+// values, e.g. ns\:web.ssh.port=22 and tags[0]=a. Text outside of ASCII is
+// written as \u escapes.
+func exportProperties(w io.Writer, results []Result, _ ExportOptions) error {
+	tree, err := resultTree(results)
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	for _, l := range leaves(tree, true) {
+		b.WriteString(propertiesEscape(l.Path.String(), true))
+		b.WriteByte('=')
+		b.WriteString(propertiesEscape(exportScalar(l.Value), false))
+		b.WriteByte('\n')
+	}
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+func propertiesEscape(s string, key bool) string {
+	var b strings.Builder
+	for i, r := range s {
+		switch {
+		case r == '\\':
+			b.WriteString(`\\`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\f':
+			b.WriteString(`\f`)
+		case r == ' ' && (key || i == 0):
+			b.WriteString(`\ `)
+		case key && strings.ContainsRune("=:#!", r):
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 0x20 || r > 0x7e:
+			for _, u := range utf16.Encode([]rune{r}) {
+				fmt.Fprintf(&b, `\u%04x`, u)
+			}
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// exportIni writes every table with values as an INI section named by its
+// path. Values at the top level come first, without a section. Arrays of
+// scalars are written as TOML arrays.
+func exportIni(w io.Writer, results []Result, _ ExportOptions) error {
+	tree, err := resultTree(results)
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	var writeTable func(p Path, t *lib.Tree)
+	writeTable = func(p Path, t *lib.Tree) {
+		var subs []Path
+		var subTrees []*lib.Tree
+		header := len(p) > 0
+		if header && len(t.Keys()) == 0 {
+			fmt.Fprintf(&b, "\n[%s]\n", p)
+		}
+		for _, k := range sortedKeys(t) {
+			kp := p.Append(PathSegment{Key: k})
+			switch n := t.GetPath([]string{k}).(type) {
+			case *lib.Tree:
+				subs, subTrees = append(subs, kp), append(subTrees, n)
+			case []*lib.Tree:
+				for i, e := range n {
+					subs, subTrees = append(subs, kp.Append(PathSegment{Index: i, IsIndex: true})), append(subTrees, e)
+				}
+			default:
+				if header {
+					fmt.Fprintf(&b, "\n[%s]\n", p)
+					header = false
+				}
+				fmt.Fprintf(&b, "%s = %s\n", k, iniValue(n))
+			}
+		}
+		for i, sp := range subs {
+			writeTable(sp, subTrees[i])
+		}
+	}
+	writeTable(nil, tree)
+	_, err = io.WriteString(w, strings.TrimPrefix(b.String(), "\n"))
+	return err
+}
+
+func iniValue(v interface{}) string {
+	if _, ok := v.([]interface{}); ok {
+		return FormatValue(v)
+	}
+	s, ok := v.(string)
+	if !ok {
+		return exportScalar(v)
+	}
+	if s == "" || strings.TrimSpace(s) != s || strings.ContainsAny(s, ";#=\"\\\n\r") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// exportCsv writes a row per table: the entries of a whole file, the tables
+// selected by a query or the elements of an array of tables. The first column
+// is the path of the row and the others are the values at opts.Columns.
+// Strings are written as they are and other values as in TOML, which is how
+// Decode reads them back.
+func exportCsv(w io.Writer, results []Result, opts ExportOptions) error {
+	type row struct {
+		name string
+		tree *lib.Tree
+	}
+	var rows []row
+	add := func(p Path, v interface{}) error {
+		switch n := v.(type) {
+		case *lib.Tree:
+			rows = append(rows, row{p.String(), n})
+		case []*lib.Tree:
+			for i, e := range n {
+				rows = append(rows, row{p.Append(PathSegment{Index: i, IsIndex: true}).String(), e})
+			}
+		default:
+			return fmt.Errorf("csv rows must be tables, %s is %s", p, TypeOf(v))
+		}
+		return nil
+	}
+	for _, r := range results {
+		t, ok := r.Value.(*lib.Tree)
+		if len(r.Path) > 0 || !ok {
+			if err := add(r.Path, r.Value); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, k := range sortedKeys(t) {
+			v := t.GetPath([]string{k})
+			if _, ok := v.(*lib.Tree); !ok {
+				if _, ok := v.([]*lib.Tree); !ok {
+					continue
+				}
+			}
+			if err := add(Path{{Key: k}}, v); err != nil {
+				return err
+			}
+		}
+	}
+
+	var columns []Path
+	if len(opts.Columns) > 0 {
+		for _, c := range opts.Columns {
+			p, err := ParsePath(c)
+			if err != nil {
+				return fmt.Errorf("column %q: %w", c, err)
+			}
+			columns = append(columns, p)
+		}
+	} else {
+		seen := map[string]bool{}
+		for _, r := range rows {
+			for _, l := range leaves(r.tree, false) {
+				if s := l.Path.String(); !seen[s] {
+					seen[s] = true
+					columns = append(columns, l.Path)
+				}
+			}
+		}
+		sort.Slice(columns, func(i, j int) bool { return columns[i].String() < columns[j].String() })
+	}
+
+	cw := csv.NewWriter(w)
+	header := []string{"key"}
+	for _, c := range columns {
+		header = append(header, c.String())
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, r := range rows {
+		record := []string{r.name}
+		for _, c := range columns {
+			switch v := getPath(r.tree, c).(type) {
+			case nil:
+				record = append(record, "")
+			case string:
+				record = append(record, v)
+			default:
+				record = append(record, FormatValue(v))
+			}
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,106 @@
+package toml
+
+import (
+	"strings"
+	"testing"
+
+	lib "github.com/pelletier/go-toml"
+	"github.com/stretchr/testify/require"
+)
+
+const exportSample = `
+title = "demo"
+tags = ["a", "b c"]
+
+["ns:web"]
+hostname = "10.0.0.1"
+port = 22
+note = "say \"hi\" to $USER"
+
+["ns:web".ssh]
+user = "root"
+
+["ns:db"]
+hostname = "10.0.0.2"
+`
+
+func exportResults(t *testing.T, query string) []Result {
+	tree, err := lib.Load(exportSample)
+	require.Nil(t, err)
+	toml := Toml{tree: tree}
+	results, err := toml.Query(query)
+	require.Nil(t, err)
+	return results
+}
+
+func export(t *testing.T, query, format string, opts ExportOptions) string {
+	var b strings.Builder
+	require.Nil(t, Export(&b, exportResults(t, query), format, opts))
+	return b.String()
+}
+
+func TestExportFlat(t *testing.T) {
+	require.Equal(t, `NS_DB_HOSTNAME="10.0.0.2"
+NS_WEB_HOSTNAME="10.0.0.1"
+NS_WEB_NOTE="say \"hi\" to \$USER"
+NS_WEB_PORT=22
+NS_WEB_SSH_USER="root"
+TAGS_0="a"
+TAGS_1="b c"
+TITLE="demo"
+`, export(t, ".", FormatDotenv, ExportOptions{}))
+
+	require.Equal(t, `ns\:web.hostname=10.0.0.1
+ns\:web.note=say "hi" to $USER
+ns\:web.port=22
+ns\:web.ssh.user=root
+`, export(t, "ns:web", FormatProperties, ExportOptions{}))
+
+	require.Equal(t, `tags = ["a", "b c"]
+title = demo
+
+[ns:db]
+hostname = 10.0.0.2
+
+[ns:web]
+hostname = 10.0.0.1
+note = "say \"hi\" to $USER"
+port = 22
+
+[ns:web.ssh]
+user = root
+`, export(t, ".", FormatIni, ExportOptions{}))
+}
+
+func TestExportDotenvCollision(t *testing.T) {
+	tree, err := lib.Load("a-b = 1\na_b = 2\n")
+	require.Nil(t, err)
+	var b strings.Builder
+	require.NotNil(t, Export(&b, []Result{{Value: tree}}, FormatDotenv, ExportOptions{}))
+}
+
+func TestExportYaml(t *testing.T) {
+	require.Equal(t, "ns:web:\n  port: 22\n", export(t, "ns:web.port", FormatYaml, ExportOptions{}))
+}
+
+func TestExportCsv(t *testing.T) {
+	out := export(t, "ns:*", FormatCsv, ExportOptions{Columns: []string{"hostname", "ssh.user"}})
+	require.Equal(t, "key,hostname,ssh.user\nns:db,10.0.0.2,\nns:web,10.0.0.1,root\n", out)
+
+	// Decode reads the export back.
+	out = export(t, ".", FormatCsv, ExportOptions{})
+	back, err := Decode([]byte(out), FormatCsv)
+	require.Nil(t, err)
+	want := exportResults(t, ".")[0].Value.(*lib.Tree)
+	want.Delete("title")
+	want.Delete("tags")
+	require.Equal(t, want.ToMap(), back.tree.ToMap())
+
+	var b strings.Builder
+	require.NotNil(t, Export(&b, exportResults(t, "ns:web.port"), FormatCsv, ExportOptions{}))
+}
+
+func TestExportUnknownFormat(t *testing.T) {
+	var b strings.Builder
+	require.NotNil(t, Export(&b, nil, "xml", ExportOptions{}))
+}
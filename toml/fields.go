@@ -0,0 +1,480 @@
+package toml
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/MinseokOh/toml-cli/encrypt"
+	lib "github.com/pelletier/go-toml"
+)
+
+// A file in field mode stays plaintext TOML except for the values of secret
+// attributes, which are encrypted one by one and read "enc:v1:...". Keys,
+// hostnames and the structure can still be diffed, grepped and reviewed.
+//
+// The FieldsKey table of the file holds the salt the key is derived from and
+// how (PBKDF2 with encrypt.KeyIterations when kdf is missing), a check value
+// to tell a wrong password early and the secret key patterns:
+//
+//	[__encryption__]
+//	salt  = "..."
+//	check = "enc:v1:..."
+//	keys  = ["password", "private_key"]
+//	kdf   = { name = "argon2id", time = 3, memory = 65536, threads = 4 }
+//
+// Values stay encrypted in the tree. Get and Reveal decrypt them on demand
+// and Write encrypts new secret values, so the password is only asked for
+// when a secret is read or written.
+
+// FieldsKey is the table describing the field encryption of a file.
+const FieldsKey = "__encryption__"
+
+// DefaultSecretKeys are the attributes encrypted in field mode unless other
+// patterns are given.
+var DefaultSecretKeys = []string{"password", "private_key"}
+
+const fieldCheck = "cmdb"
+
+type fieldCrypt struct {
+	kdf   *encrypt.KDF
+	check string
+	keys  []string
+	// key is derived once a secret is read or written.
+	key []byte
+	// plain caches decrypted values by ciphertext.
+	plain map[string]interface{}
+}
+
+// readFields returns the field encryption described in tree, or nil.
+func readFields(tree *lib.Tree) (*fieldCrypt, error) {
+	meta, ok := tree.GetPath([]string{FieldsKey}).(*lib.Tree)
+	if !ok {
+		if tree.Has(FieldsKey) {
+			return nil, fmt.Errorf("%s must be a table", FieldsKey)
+		}
+		return nil, nil
+	}
+	salt, _ := meta.GetPath([]string{"salt"}).(string)
+	check, _ := meta.GetPath([]string{"check"}).(string)
+	f := &fieldCrypt{check: check, plain: make(map[string]interface{})}
+	raw, err := base64.StdEncoding.DecodeString(salt)
+	if err != nil || len(raw) == 0 {
+		return nil, fmt.Errorf("%s: invalid salt", FieldsKey)
+	}
+	if f.kdf, err = readKDF(meta.GetPath([]string{"kdf"}), raw); err != nil {
+		return nil, fmt.Errorf("%s: %w", FieldsKey, err)
+	}
+	if !encrypt.IsEncryptedField(check) {
+		return nil, fmt.Errorf("%s: invalid check value", FieldsKey)
+	}
+	switch keys := meta.GetPath([]string{"keys"}).(type) {
+	case nil:
+		f.keys = DefaultSecretKeys
+	case []interface{}:
+		for _, k := range keys {
+			s, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s: keys must be strings", FieldsKey)
+			}
+			f.keys = append(f.keys, s)
+		}
+	default:
+		return nil, fmt.Errorf("%s: keys must be an array", FieldsKey)
+	}
+	return f, nil
+}
+
+// readKDF reads the kdf table of the FieldsKey table.
+func readKDF(v interface{}, salt []byte) (*encrypt.KDF, error) {
+	if v == nil {
+		return encrypt.LegacyKDF(salt), nil
+	}
+	t, ok := v.(*lib.Tree)
+	if !ok {
+		return nil, fmt.Errorf("kdf must be a table")
+	}
+	kdf := &encrypt.KDF{Salt: salt}
+	kdf.Name, _ = t.GetPath([]string{"name"}).(string)
+	number := func(name string) int64 {
+		n, _ := t.GetPath([]string{name}).(int64)
+		return n
+	}
+	kdf.Iterations = int(number("iterations"))
+	kdf.N, kdf.R, kdf.P = int(number("n")), int(number("r")), int(number("p"))
+	kdf.Time, kdf.Memory, kdf.Threads = uint32(number("time")), uint32(number("memory")), uint8(number("threads"))
+	return kdf, kdf.Check()
+}
+
+// kdfTree returns the kdf table for kdf.
+func kdfTree(kdf *encrypt.KDF) *lib.Tree {
+	t := newTree()
+	t.SetPath([]string{"name"}, kdf.Name)
+	for _, p := range []struct {
+		name  string
+		value int64
+	}{
+		{"iterations", int64(kdf.Iterations)},
+		{"n", int64(kdf.N)}, {"r", int64(kdf.R)}, {"p", int64(kdf.P)},
+		{"time", int64(kdf.Time)}, {"memory", int64(kdf.Memory)}, {"threads", int64(kdf.Threads)},
+	} {
+		if p.value != 0 {
+			t.SetPath([]string{p.name}, p.value)
+		}
+	}
+	return t
+}
+
+// newFields sets up field encryption with password for a file, deriving the
+// key with the KDF name.
+func newFields(password, name string, keys []string) (*fieldCrypt, error) {
+	if len(keys) == 0 {
+		keys = DefaultSecretKeys
+	}
+	if name == "" {
+		name = encrypt.DefaultKDF
+	}
+	kdf, err := encrypt.NewKDF(name)
+	if err != nil {
+		return nil, err
+	}
+	f := &fieldCrypt{kdf: kdf, keys: keys, plain: make(map[string]interface{})}
+	if f.key, err = kdf.Derive(password); err != nil {
+		return nil, err
+	}
+	if f.check, err = encrypt.EncryptField(f.key, []byte(fieldCheck)); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// meta returns the FieldsKey table for f.
+func (f *fieldCrypt) meta() *lib.Tree {
+	meta := newTree()
+	meta.SetPath([]string{"salt"}, base64.StdEncoding.EncodeToString(f.kdf.Salt))
+	meta.SetPath([]string{"check"}, f.check)
+	keys := make([]interface{}, len(f.keys))
+	for i, k := range f.keys {
+		keys[i] = k
+	}
+	meta.SetPath([]string{"keys"}, keys)
+	meta.SetPath([]string{"kdf"}, kdfTree(f.kdf))
+	return meta
+}
+
+// open derives the key from password, failing if it is not the file's.
+func (f *fieldCrypt) open(password string) error {
+	key, err := f.kdf.Derive(password)
+	if err != nil {
+		return err
+	}
+	if _, err := encrypt.DecryptField(key, f.check); err != nil {
+		return err
+	}
+	f.key = key
+	return nil
+}
+
+// unlock makes sure the key is known, asking for the password if needed.
+func (f *fieldCrypt) unlock() error {
+	if f.key != nil {
+		return nil
+	}
+	key, err := unlockKey(f.kdf.ID(), f.kdf, func(key []byte) error {
+		_, err := encrypt.DecryptField(key, f.check)
+		return err
+	})
+	f.key = key
+	return err
+}
+
+// isSecret reports whether the value at p is encrypted in field mode.
+func (f *fieldCrypt) isSecret(p Path) bool {
+	if len(p) == 0 || p[len(p)-1].IsIndex {
+		return false
+	}
+	name := p[len(p)-1].Key
+	for _, pattern := range f.keys {
+		if globMatch(pattern, name) || globMatch(pattern, p.String()) {
+			return true
+		}
+	}
+	return false
+}
+
+// reveal returns v with the encrypted values in it decrypted. v is returned
+// as is when it has none. The check value is left alone.
+func (f *fieldCrypt) reveal(v interface{}) (interface{}, error) {
+	if !hasEncrypted(v) {
+		return v, nil
+	}
+	return transformValue(nil, v, func(_ Path, v interface{}) (interface{}, bool, error) {
+		s, ok := v.(string)
+		if !ok || !encrypt.IsEncryptedField(s) {
+			return nil, false, nil
+		}
+		if s == f.check {
+			return s, true, nil
+		}
+		if plain, ok := f.plain[s]; ok {
+			return plain, true, nil
+		}
+		if err := f.unlock(); err != nil {
+			return nil, true, err
+		}
+		text, err := encrypt.DecryptField(f.key, s)
+		if err != nil {
+			return nil, true, err
+		}
+		tree, err := lib.Load("v = " + string(text))
+		if err != nil {
+			return nil, true, fmt.Errorf("corrupt encrypted value: %w", err)
+		}
+		plain := tree.GetPath([]string{"v"})
+		f.plain[s] = plain
+		return plain, true, nil
+	})
+}
+
+// seal returns tree with the plaintext values of secret attributes
+// encrypted. A value that is unchanged from the ciphertext at its path in
+// old keeps that ciphertext, so files do not change needlessly.
+func (f *fieldCrypt) seal(tree, old *lib.Tree) (*lib.Tree, error) {
+	sealed, err := transformValue(nil, tree, func(p Path, v interface{}) (interface{}, bool, error) {
+		if len(p) == 1 && p[0].Key == FieldsKey {
+			return v, true, nil
+		}
+		if !f.isSecret(p) {
+			return nil, false, nil
+		}
+		if s, ok := v.(string); ok && encrypt.IsEncryptedField(s) {
+			return v, true, nil
+		}
+		switch v.(type) {
+		case *lib.Tree, []*lib.Tree:
+			return nil, false, nil
+		}
+		if old != nil {
+			if prev, ok := getPath(old, p).(string); ok && encrypt.IsEncryptedField(prev) {
+				if plain, err := f.reveal(prev); err == nil && valuesEqual(plain, v) {
+					return prev, true, nil
+				}
+			}
+		}
+		if err := f.unlock(); err != nil {
+			return nil, true, err
+		}
+		text, err := renderValue(v)
+		if err != nil {
+			return nil, true, err
+		}
+		s, err := encrypt.EncryptField(f.key, []byte(text))
+		if err != nil {
+			return nil, true, err
+		}
+		f.plain[s] = v
+		return s, true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sealed.(*lib.Tree), nil
+}
+
+// hasEncrypted reports whether v holds an encrypted value.
+func hasEncrypted(v interface{}) bool {
+	switch n := v.(type) {
+	case string:
+		return encrypt.IsEncryptedField(n)
+	case *lib.Tree:
+		for _, k := range n.Keys() {
+			if hasEncrypted(n.GetPath([]string{k})) {
+				return true
+			}
+		}
+	case []*lib.Tree:
+		for _, e := range n {
+			if hasEncrypted(e) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, e := range n {
+			if hasEncrypted(e) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// transformValue rebuilds v, replacing the values fn handles.
+func transformValue(p Path, v interface{}, fn func(Path, interface{}) (interface{}, bool, error)) (interface{}, error) {
+	if res, ok, err := fn(p, v); ok || err != nil {
+		return res, err
+	}
+	switch n := v.(type) {
+	case *lib.Tree:
+		t := newTree()
+		for _, k := range n.Keys() {
+			e, err := transformValue(p.Append(PathSegment{Key: k}), n.GetPath([]string{k}), fn)
+			if err != nil {
+				return nil, err
+			}
+			t.SetPath([]string{k}, e)
+		}
+		return t, nil
+	case []*lib.Tree:
+		res := make([]*lib.Tree, len(n))
+		for i, e := range n {
+			r, err := transformValue(p.Append(PathSegment{Index: i, IsIndex: true}), e, fn)
+			if err != nil {
+				return nil, err
+			}
+			res[i] = r.(*lib.Tree)
+		}
+		return res, nil
+	case []interface{}:
+		res := make([]interface{}, len(n))
+		for i, e := range n {
+			r, err := transformValue(p.Append(PathSegment{Index: i, IsIndex: true}), e, fn)
+			if err != nil {
+				return nil, err
+			}
+			res[i] = r
+		}
+		return res, nil
+	}
+	return v, nil
+}
+
+// Reveal returns v, a value of t, with its encrypted fields decrypted.
+func (t *Toml) Reveal(v interface{}) (interface{}, error) {
+	if t.fields == nil {
+		return v, nil
+	}
+	return t.fields.reveal(v)
+}
+
+// RevealResults decrypts the encrypted fields of query results.
+func (t *Toml) RevealResults(results []Result) ([]Result, error) {
+	if t.fields == nil {
+		return results, nil
+	}
+	res := make([]Result, len(results))
+	for i, r := range results {
+		v, err := t.fields.reveal(r.Value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", r.Path, err)
+		}
+		res[i] = Result{Path: r.Path, Value: v}
+	}
+	return res, nil
+}
+
+// FieldEncrypted reports whether t is in field mode.
+func (t *Toml) FieldEncrypted() bool {
+	return t.fields != nil
+}
+
+// treeFor returns the tree of t for merging into dst. The FieldsKey table is
+// left out, and encrypted values are decrypted unless dst shares t's key.
+func (t *Toml) treeFor(dst *Toml) (*lib.Tree, error) {
+	if t.fields == nil {
+		return t.tree, nil
+	}
+	tree := cloneValue(t.tree).(*lib.Tree)
+	tree.Delete(FieldsKey)
+	if dst.fields != nil && dst.fields.kdf.ID() == t.fields.kdf.ID() {
+		return tree, nil
+	}
+	v, err := t.fields.reveal(tree)
+	if err != nil {
+		return nil, err
+	}
+	return v.(*lib.Tree), nil
+}
+
+// IsFieldEncrypted reports whether doc is a TOML document in field mode.
+func IsFieldEncrypted(doc []byte) bool {
+	tree, err := lib.LoadBytes(doc)
+	return err == nil && tree.Has(FieldsKey)
+}
+
+// EncryptFields converts a plaintext TOML document to field mode with
+// password, deriving the key with the KDF name (encrypt.DefaultKDF if empty).
+// keys are the secret attribute patterns, DefaultSecretKeys if empty; they
+// match attribute names or whole paths and may contain globs.
+func EncryptFields(doc []byte, password, kdf string, keys []string) ([]byte, error) {
+	out, _, err := encryptFields(doc, password, kdf, keys)
+	return out, err
+}
+
+func encryptFields(doc []byte, password, kdf string, keys []string) ([]byte, *fieldCrypt, error) {
+	tree, err := lib.LoadBytes(doc)
+	if err != nil {
+		return nil, nil, err
+	}
+	if tree.Has(FieldsKey) {
+		return nil, nil, fmt.Errorf("already in field mode")
+	}
+	f, err := newFields(password, kdf, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+	if tree, err = f.seal(tree, nil); err != nil {
+		return nil, nil, err
+	}
+	tree.SetPath([]string{FieldsKey}, f.meta())
+	out, err := renderTree(doc, tree)
+	return out, f, err
+}
+
+// DecryptFields converts a document in field mode back to plaintext.
+func DecryptFields(doc []byte, password string) ([]byte, error) {
+	tree, err := lib.LoadBytes(doc)
+	if err != nil {
+		return nil, err
+	}
+	f, err := readFields(tree)
+	if err != nil {
+		return nil, err
+	}
+	if f == nil {
+		return nil, fmt.Errorf("not in field mode")
+	}
+	if err := f.open(password); err != nil {
+		return nil, err
+	}
+	return f.plaintext(doc)
+}
+
+// plaintext converts doc, in field mode with the unlocked f, back to
+// plaintext.
+func (f *fieldCrypt) plaintext(doc []byte) ([]byte, error) {
+	tree, err := lib.LoadBytes(doc)
+	if err != nil {
+		return nil, err
+	}
+	v, err := f.reveal(tree)
+	if err != nil {
+		return nil, err
+	}
+	tree = v.(*lib.Tree)
+	tree.Delete(FieldsKey)
+	out, err := renderTree(doc, tree)
+	if err != nil {
+		return nil, err
+	}
+	// Drop the blank line that separated the FieldsKey table.
+	return append(bytes.TrimRight(out, "\n"), '\n'), nil
+}
+
+// renderTree renders tree, keeping the formatting of doc where possible.
+func renderTree(doc []byte, tree *lib.Tree) ([]byte, error) {
+	if out, ok := patchDocument(doc, tree); ok {
+		return out, nil
+	}
+	s, err := tree.ToTomlString()
+	return []byte(s), err
+}
@@ -0,0 +1,78 @@
+package toml
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/MinseokOh/toml-cli/encrypt"
+	lib "github.com/pelletier/go-toml"
+	"github.com/stretchr/testify/require"
+)
+
+const fieldsSample = `# team cmdb
+["ns:host:web"]
+hostname = "10.0.0.1"  # primary
+password = "s3cret"
+port = 22
+
+["ns:host:db"]
+hostname = "10.0.0.2"
+db_token = 42
+`
+
+func TestEncryptFields(t *testing.T) {
+	doc, err := EncryptFields([]byte(fieldsSample), "pw", "", []string{"password", "*_token"})
+	require.Nil(t, err)
+	require.True(t, IsFieldEncrypted(doc))
+	require.False(t, IsFieldEncrypted([]byte(fieldsSample)))
+	require.Contains(t, string(doc), `hostname = "10.0.0.1"  # primary`)
+	require.NotContains(t, string(doc), "s3cret")
+
+	tree, err := lib.LoadBytes(doc)
+	require.Nil(t, err)
+	require.True(t, encrypt.IsEncryptedField(tree.GetPath([]string{"ns:host:web", "password"}).(string)))
+	require.True(t, encrypt.IsEncryptedField(tree.GetPath([]string{"ns:host:db", "db_token"}).(string)))
+
+	_, err = EncryptFields(doc, "pw", "", nil)
+	require.NotNil(t, err)
+	_, err = DecryptFields(doc, "wrong")
+	require.ErrorIs(t, err, encrypt.ErrWrongPassword)
+
+	plain, err := DecryptFields(doc, "pw")
+	require.Nil(t, err)
+	require.Equal(t, fieldsSample, string(plain))
+}
+
+func TestFieldsGetAndWrite(t *testing.T) {
+	doc, err := EncryptFields([]byte(fieldsSample), "pw", "", nil)
+	require.Nil(t, err)
+	path := writeSample(t, string(doc))
+	toml, err := NewToml(path)
+	require.Nil(t, err)
+	defer toml.Close()
+	require.True(t, toml.FieldEncrypted())
+	require.NotContains(t, toml.Keys(), FieldsKey)
+
+	// Unlock without prompting.
+	require.Nil(t, toml.fields.open("pw"))
+	require.Equal(t, "s3cret", toml.Get("ns:host:web.password"))
+
+	require.Nil(t, toml.Set("ns:host:db", "password", "other"))
+	require.Nil(t, toml.Write())
+
+	data, err := os.ReadFile(path)
+	require.Nil(t, err)
+	require.NotContains(t, string(data), "other")
+	// The unchanged secret keeps its ciphertext.
+	web := strings.Split(string(doc), "\n")[3]
+	require.True(t, strings.HasPrefix(web, "password = "))
+	require.Contains(t, string(data), web)
+
+	plain, err := DecryptFields(data, "pw")
+	require.Nil(t, err)
+	tree, err := lib.LoadBytes(plain)
+	require.Nil(t, err)
+	require.Equal(t, "other", tree.GetPath([]string{"ns:host:db", "password"}))
+	require.Equal(t, "s3cret", tree.GetPath([]string{"ns:host:web", "password"}))
+}
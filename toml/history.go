@@ -0,0 +1,278 @@
+package toml
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MinseokOh/toml-cli/encrypt"
+)
+
+// Every write keeps the content it replaces in a history store next to the
+// file, .<name>.history/, as gzip compressed copies of the bytes that were on
+// disk. Versions of an encrypted file are therefore encrypted too. An index
+// lists the versions with the time and the command that replaced them.
+
+// Command and CommandArgs describe the running command, e.g. "cm set" and its
+// arguments, for the records kept by Write. The arguments may contain values,
+// so they are not recorded for encrypted files.
+var (
+	Command     string
+	CommandArgs []string
+)
+
+// MaxVersions is the number of untagged versions kept per file.
+var MaxVersions = 100
+
+// Version is a prior content of a file.
+type Version struct {
+	ID int `json:"id"`
+	// Time the content was replaced, or the snapshot was taken.
+	Time    time.Time `json:"time"`
+	Command string    `json:"command,omitempty"`
+	Tags    []string  `json:"tags,omitempty"`
+}
+
+// History is the history store of a file.
+type History struct {
+	dir      string
+	Versions []Version // oldest first
+}
+
+const historyIndex = "index.json"
+
+// historyDir returns the history store of path.
+func historyDir(path string) string {
+	dir, name := filepath.Split(path)
+	return filepath.Join(dir, "."+name+".history")
+}
+
+// OpenHistory reads the history of the file at path. A file without history
+// has no versions.
+func OpenHistory(path string) (*History, error) {
+	h := &History{dir: historyDir(path)}
+	data, err := os.ReadFile(filepath.Join(h.dir, historyIndex))
+	if os.IsNotExist(err) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &h.Versions); err != nil {
+		return nil, fmt.Errorf("corrupt history index %s: %w", h.dir, err)
+	}
+	return h, nil
+}
+
+// History returns the history of the file t was loaded from.
+func (t *Toml) History() (*History, error) {
+	return OpenHistory(t.path)
+}
+
+// saveVersion adds data, the content about to be replaced, to the history of
+// path.
+func saveVersion(path string, data []byte) error {
+	h, err := OpenHistory(path)
+	if err != nil {
+		return err
+	}
+	_, err = h.add(data, describeCommand(data))
+	return err
+}
+
+// describeCommand returns the command recorded for a version of data.
+func describeCommand(data []byte) string {
+	if len(CommandArgs) == 0 || encrypt.IsEncrypted(data) || bytes.Contains(data, []byte(encrypt.FieldPrefix)) {
+		return Command
+	}
+	return strings.TrimSpace(Command + " " + strings.Join(CommandArgs, " "))
+}
+
+func (h *History) add(data []byte, command string) (Version, error) {
+	if err := os.MkdirAll(h.dir, 0700); err != nil {
+		return Version{}, err
+	}
+	v := Version{ID: 1, Time: time.Now(), Command: command}
+	if n := len(h.Versions); n > 0 {
+		v.ID = h.Versions[n-1].ID + 1
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return v, err
+	}
+	if err := zw.Close(); err != nil {
+		return v, err
+	}
+	if err := WriteFileAtomic(h.file(v), buf.Bytes()); err != nil {
+		return v, err
+	}
+	h.Versions = append(h.Versions, v)
+	h.prune()
+	return v, h.save()
+}
+
+// prune drops the oldest untagged versions beyond MaxVersions.
+func (h *History) prune() {
+	untagged := 0
+	for _, v := range h.Versions {
+		if len(v.Tags) == 0 {
+			untagged++
+		}
+	}
+	kept := h.Versions[:0]
+	for _, v := range h.Versions {
+		if len(v.Tags) == 0 && untagged > MaxVersions {
+			untagged--
+			os.Remove(h.file(v))
+			continue
+		}
+		kept = append(kept, v)
+	}
+	h.Versions = kept
+}
+
+func (h *History) save() error {
+	data, err := json.MarshalIndent(h.Versions, "", "  ")
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(filepath.Join(h.dir, historyIndex), data)
+}
+
+func (h *History) file(v Version) string {
+	return filepath.Join(h.dir, strconv.Itoa(v.ID)+".gz")
+}
+
+// Find returns the version with the given id or tag.
+func (h *History) Find(ref string) (Version, error) {
+	for i := len(h.Versions) - 1; i >= 0; i-- {
+		v := h.Versions[i]
+		if strconv.Itoa(v.ID) == ref || containsString(v.Tags, ref) {
+			return v, nil
+		}
+	}
+	return Version{}, fmt.Errorf("no version %q in the history", ref)
+}
+
+// Latest returns the most recent version.
+func (h *History) Latest() (Version, bool) {
+	if len(h.Versions) == 0 {
+		return Version{}, false
+	}
+	return h.Versions[len(h.Versions)-1], true
+}
+
+// Read returns the file content stored for v, as it was on disk.
+func (h *History) Read(v Version) ([]byte, error) {
+	f, err := os.Open(h.file(v))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(zr)
+}
+
+// Tag names version id. A tag names a single version, so it is moved if
+// another version has it. Tagged versions are never pruned.
+func (h *History) Tag(name string, id int) error {
+	if name == "" {
+		return fmt.Errorf("empty tag")
+	}
+	if _, err := strconv.Atoi(name); err == nil {
+		return fmt.Errorf("tag %q would be mistaken for a version number", name)
+	}
+	found := false
+	for i := range h.Versions {
+		v := &h.Versions[i]
+		v.Tags = removeString(v.Tags, name)
+		if v.ID == id {
+			v.Tags = append(v.Tags, name)
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("no version %d in the history", id)
+	}
+	return h.save()
+}
+
+// Snapshot stores the current content of the file t was loaded from as a
+// version tagged name.
+func (t *Toml) Snapshot(name string) (Version, error) {
+	h, err := t.History()
+	if err != nil {
+		return Version{}, err
+	}
+	current, err := t.checkUnchanged()
+	if err != nil {
+		return Version{}, err
+	}
+	v, err := h.add(current, "snapshot")
+	if err != nil {
+		return v, err
+	}
+	if err := h.Tag(name, v.ID); err != nil {
+		return v, err
+	}
+	v.Tags = []string{name}
+	return v, nil
+}
+
+// LoadVersion returns the content of the file at version v, decrypted.
+func (t *Toml) LoadVersion(h *History, v Version) (*Toml, error) {
+	data, err := h.Read(v)
+	if err != nil {
+		return nil, err
+	}
+	old := &Toml{path: t.path}
+	if old.raw, err = decode(data); err != nil {
+		return nil, err
+	}
+	if err := old.load(); err != nil {
+		return nil, fmt.Errorf("version %d: %w", v.ID, err)
+	}
+	return old, nil
+}
+
+// Restore replaces the content of t with that of old, as loaded by
+// LoadVersion. The next Write stores it exactly as it was; the content it
+// replaces is kept in the history like any other write.
+func (t *Toml) Restore(old *Toml) {
+	t.layout = old.raw
+	t.tree = old.tree
+}
+
+func containsString(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+func removeString(list []string, s string) []string {
+	res := list[:0]
+	for _, e := range list {
+		if e != s {
+			res = append(res, e)
+		}
+	}
+	if len(res) == 0 {
+		return nil
+	}
+	return res
+}
@@ -0,0 +1,88 @@
+package toml
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistory(t *testing.T) {
+	const original = "# hosts\n[web]\nport = 22  # ssh\n"
+	path := writeSample(t, original)
+	toml, err := NewToml(path)
+	require.Nil(t, err)
+	defer toml.Close()
+
+	Command, CommandArgs = "cm set", []string{"web", "port", "2222"}
+	require.Nil(t, toml.Set("web", "port", int64(2222)))
+	require.Nil(t, toml.Write())
+	// Writing unchanged content adds no version.
+	require.Nil(t, toml.Write())
+	Command, CommandArgs = "cm clear", []string{"web"}
+	require.Nil(t, toml.Clear("web"))
+	require.Nil(t, toml.Write())
+
+	h, err := toml.History()
+	require.Nil(t, err)
+	require.Len(t, h.Versions, 2)
+	require.Equal(t, "cm set web port 2222", h.Versions[0].Command)
+	require.Equal(t, "cm clear web", h.Versions[1].Command)
+
+	v, err := h.Find("1")
+	require.Nil(t, err)
+	old, err := toml.LoadVersion(h, v)
+	require.Nil(t, err)
+	require.Equal(t, int64(22), old.Get("web.port"))
+
+	toml.Restore(old)
+	require.Nil(t, toml.Write())
+	data, err := os.ReadFile(path)
+	require.Nil(t, err)
+	require.Equal(t, original, string(data))
+
+	h, err = toml.History()
+	require.Nil(t, err)
+	require.Len(t, h.Versions, 3)
+	last, ok := h.Latest()
+	require.True(t, ok)
+	require.Equal(t, 3, last.ID)
+}
+
+func TestHistoryTags(t *testing.T) {
+	path := writeSample(t, "a = 1\n")
+	toml, err := NewToml(path)
+	require.Nil(t, err)
+	defer toml.Close()
+
+	v, err := toml.Snapshot("release")
+	require.Nil(t, err)
+	require.Equal(t, 1, v.ID)
+
+	max := MaxVersions
+	MaxVersions = 2
+	defer func() { MaxVersions = max }()
+	for i := int64(2); i <= 5; i++ {
+		require.Nil(t, toml.Set("a", "", i))
+		require.Nil(t, toml.Write())
+	}
+
+	h, err := toml.History()
+	require.Nil(t, err)
+	ids := []int{}
+	for _, v := range h.Versions {
+		ids = append(ids, v.ID)
+	}
+	require.Equal(t, []int{1, 4, 5}, ids)
+
+	v, err = h.Find("release")
+	require.Nil(t, err)
+	require.Equal(t, 1, v.ID)
+	require.Nil(t, h.Tag("release", 5))
+	v, err = h.Find("release")
+	require.Nil(t, err)
+	require.Equal(t, 5, v.ID)
+	require.NotNil(t, h.Tag("7", 5))
+	_, err = h.Find("missing")
+	require.NotNil(t, err)
+}
@@ -0,0 +1,254 @@
+package toml
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	lib "github.com/pelletier/go-toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Conflict policies of Import, for entries that already exist.
+const (
+	ImportSkip      = "skip"      // keep the existing entry
+	ImportOverwrite = "overwrite" // replace it with the imported one
+	ImportMerge     = "merge"     // merge the imported entry into it
+	ImportFail      = "fail"      // import nothing
+)
+
+// DetectFormat guesses the format of data read from the file name.
+func DetectFormat(name string, data []byte) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json":
+		return FormatJson
+	case ".yaml", ".yml":
+		return FormatYaml
+	case ".toml":
+		return FormatToml
+	case ".csv":
+		return FormatCsv
+	}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		return FormatJson
+	}
+	if _, err := lib.LoadBytes(data); err == nil {
+		return FormatToml
+	}
+	return FormatYaml
+}
+
+// Decode reads JSON, YAML, TOML or CSV data into a Toml that is not backed
+// by a file. Objects become tables at any depth. Encrypted TOML is decrypted,
+// and the Toml is encrypted as it was.
+//
+// CSV data has a header row; the first column names the entry and the others
+// are its attributes. Headers may be paths such as ssh.port, and cells are
+// typed like the values of "cm set". Empty cells are left out.
+func Decode(data []byte, format string) (*Toml, error) {
+	var tree *lib.Tree
+	var err error
+	src := &Toml{raw: []byte{}}
+	switch format {
+	case FormatToml:
+		if err = src.decrypt(data); err != nil {
+			return nil, err
+		}
+		if tree, err = lib.LoadBytes(src.raw); err == nil {
+			src.fields, err = readFields(tree)
+		}
+	case FormatJson:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		var v interface{}
+		if err = dec.Decode(&v); err == nil {
+			tree, err = plainTree(v)
+		}
+	case FormatYaml:
+		var v interface{}
+		if err = yaml.Unmarshal(data, &v); err == nil {
+			tree, err = plainTree(v)
+		}
+	case FormatCsv:
+		tree, err = csvTree(data)
+	default:
+		return nil, fmt.Errorf("unknown format %q, expected json, yaml, toml or csv", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", format, err)
+	}
+	src.raw, src.tree = []byte{}, tree
+	return src, nil
+}
+
+func plainTree(v interface{}) (*lib.Tree, error) {
+	if v == nil {
+		return newTree(), nil
+	}
+	tree, ok := fromPlain(v).(*lib.Tree)
+	if !ok {
+		return nil, fmt.Errorf("the document has to be an object, not %T", v)
+	}
+	return tree, nil
+}
+
+// fromPlain converts a decoded JSON or YAML value into a tree value. Nulls
+// have no TOML equivalent and are dropped.
+func fromPlain(v interface{}) interface{} {
+	switch n := v.(type) {
+	case json.Number:
+		if i, err := n.Int64(); err == nil {
+			return i
+		}
+		f, _ := n.Float64()
+		return f
+	case int:
+		return int64(n)
+	case uint64:
+		return int64(n)
+	case map[string]interface{}:
+		t := newTree()
+		for k, e := range n {
+			if e = fromPlain(e); e != nil {
+				t.SetPath([]string{k}, e)
+			}
+		}
+		return t
+	case map[interface{}]interface{}:
+		t := newTree()
+		for k, e := range n {
+			if e = fromPlain(e); e != nil {
+				t.SetPath([]string{fmt.Sprint(k)}, e)
+			}
+		}
+		return t
+	case []interface{}:
+		res := make([]interface{}, 0, len(n))
+		for _, e := range n {
+			if e = fromPlain(e); e != nil {
+				res = append(res, e)
+			}
+		}
+		tables := make([]*lib.Tree, len(res))
+		for i, e := range res {
+			if tables[i], _ = e.(*lib.Tree); tables[i] == nil {
+				return res
+			}
+		}
+		if len(tables) > 0 {
+			return tables
+		}
+		return res
+	}
+	return v
+}
+
+func csvTree(data []byte) (*lib.Tree, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.TrimLeadingSpace = true
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	tree := newTree()
+	if len(rows) == 0 {
+		return tree, nil
+	}
+	header := rows[0]
+	paths := make([]Path, len(header))
+	for i, h := range header[1:] {
+		if paths[i+1], err = ParsePath(strings.TrimSpace(h)); err != nil {
+			return nil, fmt.Errorf("column %q: %w", h, err)
+		}
+	}
+	for n, row := range rows[1:] {
+		key := strings.TrimSpace(row[0])
+		if key == "" {
+			return nil, fmt.Errorf("line %d: empty %s", n+2, header[0])
+		}
+		if tree.HasPath([]string{key}) {
+			return nil, fmt.Errorf("line %d: duplicate %s %q", n+2, header[0], key)
+		}
+		entry := newTree()
+		for i, cell := range row[1:] {
+			if cell == "" {
+				continue
+			}
+			v, _ := ParseValue(cell, TypeAuto)
+			if err := setPath(entry, paths[i+1], v); err != nil {
+				return nil, fmt.Errorf("line %d: %w", n+2, err)
+			}
+		}
+		tree.SetPath([]string{key}, entry)
+	}
+	return tree, nil
+}
+
+// ValidateImportPolicy checks a conflict policy name.
+func ValidateImportPolicy(policy string) error {
+	switch policy {
+	case ImportSkip, ImportOverwrite, ImportMerge, ImportFail:
+		return nil
+	}
+	return fmt.Errorf("unknown conflict policy %q, expected skip, overwrite, merge or fail", policy)
+}
+
+// Import adds the top-level entries of src to t, named prefix:key when a
+// prefix is given. An entry that already exists with a different value is a
+// conflict, resolved by policy; with ImportFail nothing is imported when there
+// are conflicts. Import returns the conflicting entries. If only src is
+// encrypted, t is encrypted as it from now on.
+func (t *Toml) Import(src *Toml, prefix, policy string) ([]string, error) {
+	if err := ValidateImportPolicy(policy); err != nil {
+		return nil, err
+	}
+	prefix = strings.TrimSuffix(prefix, ":")
+	t.adoptEncryption(src)
+	tree, err := src.treeFor(t)
+	if err != nil {
+		return nil, err
+	}
+
+	var conflicts []string
+	conflicting := make(map[string]bool)
+	keys := sortedKeys(tree)
+	names := make([]string, len(keys))
+	for i, k := range keys {
+		names[i] = k
+		if prefix != "" {
+			names[i] = prefix + ":" + k
+		}
+		if old := t.tree.GetPath([]string{names[i]}); old != nil && !valuesEqual(old, tree.GetPath([]string{k})) {
+			conflicts = append(conflicts, names[i])
+			conflicting[names[i]] = true
+		}
+	}
+	if len(conflicts) > 0 && policy == ImportFail {
+		return conflicts, fmt.Errorf("%d entries already exist: %s", len(conflicts), strings.Join(conflicts, ", "))
+	}
+
+	for i, k := range keys {
+		value := tree.GetPath([]string{k})
+		if conflicting[names[i]] {
+			switch policy {
+			case ImportSkip:
+				continue
+			case ImportMerge:
+				target, ok1 := t.tree.GetPath([]string{names[i]}).(*lib.Tree)
+				source, ok2 := value.(*lib.Tree)
+				if ok1 && ok2 {
+					opts := MergeOptions{Arrays: MergeReplace}
+					if err := opts.mergeTree(Path{{Key: names[i]}}, target, source); err != nil {
+						return conflicts, err
+					}
+					continue
+				}
+			}
+		}
+		t.tree.SetPath([]string{names[i]}, value)
+	}
+	return conflicts, nil
+}
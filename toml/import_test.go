@@ -0,0 +1,97 @@
+package toml
+
+import (
+	"testing"
+
+	lib "github.com/pelletier/go-toml"
+	"github.com/stretchr/testify/require"
+)
+
+const importBase = `
+["ns:web"]
+hostname = "a"
+port = 22
+`
+
+func importSample(t *testing.T, data, format, policy string) (map[string]interface{}, []string, error) {
+	tree, err := lib.Load(importBase)
+	require.Nil(t, err)
+	dst := Toml{tree: tree}
+	src, err := Decode([]byte(data), format)
+	require.Nil(t, err)
+	conflicts, err := dst.Import(src, "ns", policy)
+	return dst.tree.ToMap(), conflicts, err
+}
+
+func TestDecode(t *testing.T) {
+	want := map[string]interface{}{
+		"web": map[string]interface{}{
+			"hostname": "b",
+			"ssh":      map[string]interface{}{"port": int64(2222)},
+			"tags":     []interface{}{"x", "y"},
+		},
+	}
+	inputs := map[string]string{
+		FormatJson: `{"web": {"hostname": "b", "ssh": {"port": 2222}, "tags": ["x", "y"], "none": null}}`,
+		FormatYaml: "web:\n  hostname: b\n  ssh:\n    port: 2222\n  tags: [x, y]\n",
+		FormatToml: "[web]\nhostname = \"b\"\ntags = [\"x\", \"y\"]\n[web.ssh]\nport = 2222\n",
+		FormatCsv:  "name,hostname,ssh.port,tags,empty\nweb,b,2222,\"[\"\"x\"\", \"\"y\"\"]\",\n",
+	}
+	for format, data := range inputs {
+		toml, err := Decode([]byte(data), format)
+		require.Nil(t, err, format)
+		require.Equal(t, want, toml.tree.ToMap(), format)
+		_, isTree := toml.tree.GetPath([]string{"web", "ssh"}).(*lib.Tree)
+		require.True(t, isTree, format)
+	}
+
+	_, err := Decode([]byte("name,hostname\nweb,a\nweb,b\n"), FormatCsv)
+	require.NotNil(t, err)
+	_, err = Decode([]byte("[1, 2]"), FormatJson)
+	require.NotNil(t, err)
+}
+
+func TestDetectFormat(t *testing.T) {
+	require.Equal(t, FormatYaml, DetectFormat("hosts.yml", nil))
+	require.Equal(t, FormatCsv, DetectFormat("hosts.CSV", nil))
+	require.Equal(t, FormatJson, DetectFormat("-", []byte(` {"a": 1}`)))
+	require.Equal(t, FormatToml, DetectFormat("-", []byte(`a = 1`)))
+	require.Equal(t, FormatYaml, DetectFormat("-", []byte("a: 1\n")))
+}
+
+func TestImport(t *testing.T) {
+	data := `{"web": {"hostname": "b", "ssh": {"port": 2222}}, "db": {"hostname": "c"}}`
+	db := map[string]interface{}{"hostname": "c"}
+
+	m, conflicts, err := importSample(t, data, FormatJson, ImportFail)
+	require.NotNil(t, err)
+	require.Equal(t, []string{"ns:web"}, conflicts)
+	require.Equal(t, map[string]interface{}{"hostname": "a", "port": int64(22)}, m["ns:web"])
+	require.Nil(t, m["ns:db"])
+
+	m, _, err = importSample(t, data, FormatJson, ImportSkip)
+	require.Nil(t, err)
+	require.Equal(t, map[string]interface{}{"hostname": "a", "port": int64(22)}, m["ns:web"])
+	require.Equal(t, db, m["ns:db"])
+
+	m, _, err = importSample(t, data, FormatJson, ImportOverwrite)
+	require.Nil(t, err)
+	require.Equal(t, map[string]interface{}{
+		"hostname": "b",
+		"ssh":      map[string]interface{}{"port": int64(2222)},
+	}, m["ns:web"])
+	require.Equal(t, db, m["ns:db"])
+
+	m, _, err = importSample(t, data, FormatJson, ImportMerge)
+	require.Nil(t, err)
+	require.Equal(t, map[string]interface{}{
+		"hostname": "b",
+		"port":     int64(22),
+		"ssh":      map[string]interface{}{"port": int64(2222)},
+	}, m["ns:web"])
+
+	// An entry equal to the existing one is no conflict.
+	_, conflicts, err = importSample(t, `{"web": {"hostname": "a", "port": 22}}`, FormatJson, ImportFail)
+	require.Nil(t, err)
+	require.Empty(t, conflicts)
+}
@@ -0,0 +1,162 @@
+package toml
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// A loaded file is protected by an advisory lock on a sidecar file,
+// .<name>.lock next to it; the file itself cannot carry the lock because
+// Write replaces it. A shared lock is taken before the file is read and held
+// until Close, or until the process exits, so any number of cm invocations
+// may read the file at once. Write upgrades it to an exclusive lock and then
+// checks that the file is still what was read: of two concurrent
+// load-modify-write cycles, the later write fails with ErrModified instead of
+// losing the earlier one.
+//
+// Locks are shared within a process: loading the same file twice does not
+// deadlock, and the lock is released with the last Close.
+
+// LockTimeout bounds how long loading waits for another process.
+var LockTimeout = 30 * time.Second
+
+var errLocked = errors.New("locked")
+
+type fileLock struct {
+	f         *os.File
+	refs      int
+	exclusive bool
+}
+
+var (
+	locksMu sync.Mutex
+	locks   = make(map[string]*fileLock)
+)
+
+// lockPath returns the sidecar lock file of path.
+func lockPath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	dir, name := filepath.Split(abs)
+	return filepath.Join(dir, "."+name+".lock"), nil
+}
+
+// acquireLock locks path, shared or exclusive, and returns the key to release
+// it with. An empty key means the lock could not be created, e.g. in a
+// read-only directory, and the file is used unlocked.
+func acquireLock(path string, exclusive bool) (string, error) {
+	key, err := lockPath(path)
+	if err != nil {
+		return "", err
+	}
+
+	locksMu.Lock()
+	defer locksMu.Unlock()
+	if l, ok := locks[key]; ok {
+		if exclusive && !l.exclusive {
+			if err := upgrade(l, path, key); err != nil {
+				return "", err
+			}
+		}
+		l.refs++
+		return key, nil
+	}
+
+	f, err := os.OpenFile(key, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		if os.IsPermission(err) || os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	if err := waitLock(f, path, key, exclusive); err != nil {
+		f.Close()
+		return "", err
+	}
+	locks[key] = &fileLock{f: f, refs: 1, exclusive: exclusive}
+	return key, nil
+}
+
+// LockFile locks path exclusively, as Write does, for commands that read and
+// replace a file without loading it. The returned function releases the lock.
+func LockFile(path string) (func(), error) {
+	key, err := acquireLock(path, true)
+	if err != nil {
+		return nil, err
+	}
+	return func() { releaseLock(key) }, nil
+}
+
+// upgradeLock makes the lock taken with key exclusive. The shared lock is
+// dropped while waiting, so the file may change meanwhile: callers check it
+// again once this returns.
+func upgradeLock(path, key string) error {
+	if key == "" {
+		return nil
+	}
+	locksMu.Lock()
+	defer locksMu.Unlock()
+	l, ok := locks[key]
+	if !ok || l.exclusive {
+		return nil
+	}
+	return upgrade(l, path, key)
+}
+
+func upgrade(l *fileLock, path, key string) error {
+	// Two processes upgrading at once would wait for each other if they
+	// kept their shared locks.
+	if err := unlock(l.f); err != nil {
+		return fmt.Errorf("failed to unlock %s: %w", path, err)
+	}
+	if err := waitLock(l.f, path, key, true); err != nil {
+		// Keep reading under the shared lock if it can be had back.
+		tryLock(l.f, false)
+		return err
+	}
+	l.exclusive = true
+	return nil
+}
+
+// waitLock locks f, waiting up to LockTimeout for other processes.
+func waitLock(f *os.File, path, key string, exclusive bool) error {
+	deadline := time.Now().Add(LockTimeout)
+	for {
+		err := tryLock(f, exclusive)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, errLocked) {
+			return fmt.Errorf("failed to lock %s: %w", path, err)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s is locked by another process (%s)", path, key)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// releaseLock drops a reference taken by acquireLock.
+func releaseLock(key string) {
+	if key == "" {
+		return
+	}
+	locksMu.Lock()
+	defer locksMu.Unlock()
+	l, ok := locks[key]
+	if !ok {
+		return
+	}
+	if l.refs--; l.refs > 0 {
+		return
+	}
+	unlock(l.f)
+	l.f.Close()
+	delete(locks, key)
+}
@@ -0,0 +1,18 @@
+//go:build !unix && !windows
+
+package toml
+
+import "os"
+
+// Advisory locks are not available here; files are used unlocked.
+func tryLock(f *os.File, exclusive bool) error {
+	return nil
+}
+
+func unlock(f *os.File) error {
+	return nil
+}
+
+func syncDir(dir string) error {
+	return nil
+}
@@ -0,0 +1,263 @@
+package toml
+
+import (
+	"fmt"
+	"strings"
+
+	lib "github.com/pelletier/go-toml"
+)
+
+// Merge strategies. Tables are merged key by key unless a path is set to
+// MergeReplace; arrays follow MergeOptions.Arrays unless overridden per path.
+const (
+	MergeReplace = "replace"
+	MergeAppend  = "append"
+	MergeUnion   = "union"
+	// MergeKeyed ("keyed:<field>") merges arrays of tables element by element,
+	// matching elements on the value of field.
+	MergeKeyed = "keyed:"
+)
+
+// DeleteMarker used as a value in an overlay removes the key from the result.
+// In a keyed array, an element with __delete__ = true removes its match.
+const DeleteMarker = "__delete__"
+
+// MergeSection is the overlay table holding merge options:
+//
+//	[__merge__]
+//	arrays = "union"
+//	[__merge__.paths]
+//	"servers" = "keyed:name"
+//	"*:host:*.tags" = "append"
+//
+// It is never copied into the result.
+const MergeSection = "__merge__"
+
+// MergeOptions selects how Merge combines values.
+type MergeOptions struct {
+	// Arrays is the default strategy for arrays, MergeReplace if empty.
+	Arrays string
+	// Paths overrides the strategy for values whose path matches a key; keys
+	// are paths as printed by Path.String and may contain * and ? globs.
+	Paths map[string]string
+}
+
+// ValidateStrategy checks a strategy name.
+func ValidateStrategy(s string) error {
+	switch {
+	case s == MergeReplace, s == MergeAppend, s == MergeUnion:
+		return nil
+	case strings.HasPrefix(s, MergeKeyed) && len(s) > len(MergeKeyed):
+		return nil
+	}
+	return fmt.Errorf("unknown merge strategy %q, expected replace, append, union or keyed:<field>", s)
+}
+
+// Merge merges another TOML file into this one. The overlay's [__merge__]
+// section, if any, selects the strategies.
+func (t *Toml) Merge(other *Toml) error {
+	return t.MergeWith(other, MergeOptions{})
+}
+
+// MergeWith merges another TOML file into this one. Options given here take
+// precedence over the overlay's [__merge__] section. If only the other file
+// is encrypted, this one is encrypted as it from now on.
+func (t *Toml) MergeWith(other *Toml, opts MergeOptions) error {
+	t.adoptEncryption(other)
+	source, err := other.treeFor(t)
+	if err != nil {
+		return err
+	}
+	merged, err := overlayOptions(source, opts)
+	if err != nil {
+		return err
+	}
+	return merged.mergeTree(nil, t.tree, source)
+}
+
+// overlayOptions combines the [__merge__] section of source with opts.
+func overlayOptions(source *lib.Tree, opts MergeOptions) (MergeOptions, error) {
+	res := MergeOptions{Arrays: MergeReplace, Paths: make(map[string]string)}
+	if section, ok := source.GetPath([]string{MergeSection}).(*lib.Tree); ok {
+		if arrays, ok := section.GetPath([]string{"arrays"}).(string); ok {
+			res.Arrays = arrays
+		}
+		if paths, ok := section.GetPath([]string{"paths"}).(*lib.Tree); ok {
+			for _, k := range paths.Keys() {
+				res.Paths[k] = fmt.Sprint(paths.GetPath([]string{k}))
+			}
+		}
+	}
+	if opts.Arrays != "" {
+		res.Arrays = opts.Arrays
+	}
+	for k, v := range opts.Paths {
+		res.Paths[k] = v
+	}
+
+	if err := ValidateStrategy(res.Arrays); err != nil {
+		return res, err
+	}
+	for k, v := range res.Paths {
+		if err := ValidateStrategy(v); err != nil {
+			return res, fmt.Errorf("%s: %w", k, err)
+		}
+	}
+	return res, nil
+}
+
+// strategy returns the strategy set for p, or "" if none matches.
+func (o MergeOptions) strategy(p Path) string {
+	s := p.String()
+	if v, ok := o.Paths[s]; ok {
+		return v
+	}
+	for pattern, v := range o.Paths {
+		if globMatch(pattern, s) {
+			return v
+		}
+	}
+	return ""
+}
+
+// mergeTree recursively merges source tree into target tree
+func (o MergeOptions) mergeTree(p Path, target, source *lib.Tree) error {
+	for _, key := range sortedKeys(source) {
+		if len(p) == 0 && key == MergeSection {
+			continue
+		}
+		kp := p.Append(PathSegment{Key: key})
+		sourceValue := source.GetPath([]string{key})
+
+		if s, ok := sourceValue.(string); ok && s == DeleteMarker {
+			target.DeletePath([]string{key})
+			continue
+		}
+
+		strategy := o.strategy(kp)
+		targetValue := target.GetPath([]string{key})
+		if _, ok := sourceValue.(*lib.Tree); ok && targetValue == nil && strategy != MergeReplace {
+			// Merge into an empty table so nested markers are applied too.
+			targetValue = newTree()
+			target.SetPath([]string{key}, targetValue)
+		}
+		if targetValue == nil || strategy == MergeReplace {
+			target.SetPath([]string{key}, sourceValue)
+			continue
+		}
+
+		// If both are trees (nested objects), merge recursively
+		if sourceTree, ok := sourceValue.(*lib.Tree); ok {
+			if targetTree, ok := targetValue.(*lib.Tree); ok {
+				if err := o.mergeTree(kp, targetTree, sourceTree); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		if strategy == "" {
+			strategy = o.Arrays
+		}
+		merged, err := o.mergeArrays(kp, targetValue, sourceValue, strategy)
+		if err != nil {
+			return err
+		}
+		target.SetPath([]string{key}, merged)
+	}
+	return nil
+}
+
+// mergeArrays combines two values with an array strategy. Anything that is
+// not a pair of arrays is replaced by the source value.
+func (o MergeOptions) mergeArrays(p Path, target, source interface{}, strategy string) (interface{}, error) {
+	switch tv := target.(type) {
+	case []interface{}:
+		sv, ok := source.([]interface{})
+		if !ok {
+			return source, nil
+		}
+		switch strategy {
+		case MergeAppend:
+			return append(append([]interface{}{}, tv...), sv...), nil
+		case MergeUnion:
+			res := append([]interface{}{}, tv...)
+			for _, v := range sv {
+				if !containsValue(res, v) {
+					res = append(res, v)
+				}
+			}
+			return res, nil
+		}
+	case []*lib.Tree:
+		sv, ok := source.([]*lib.Tree)
+		if !ok {
+			return source, nil
+		}
+		switch {
+		case strategy == MergeAppend:
+			return append(append([]*lib.Tree{}, tv...), sv...), nil
+		case strategy == MergeUnion:
+			res := append([]*lib.Tree{}, tv...)
+			for _, v := range sv {
+				if !containsValue(res, v) {
+					res = append(res, v)
+				}
+			}
+			return res, nil
+		case strings.HasPrefix(strategy, MergeKeyed):
+			return o.mergeKeyed(p, tv, sv, strings.TrimPrefix(strategy, MergeKeyed))
+		}
+	}
+	return source, nil
+}
+
+// mergeKeyed merges arrays of tables on the value of field. Source elements
+// without a match are appended.
+func (o MergeOptions) mergeKeyed(p Path, target, source []*lib.Tree, field string) ([]*lib.Tree, error) {
+	res := append([]*lib.Tree{}, target...)
+	for _, s := range source {
+		key := s.GetPath([]string{field})
+		if key == nil {
+			return nil, fmt.Errorf("%s: element without %q cannot be merged by key", p, field)
+		}
+		del, _ := s.GetPath([]string{DeleteMarker}).(bool)
+		idx := -1
+		for i, t := range res {
+			if valuesEqual(t.GetPath([]string{field}), key) {
+				idx = i
+				break
+			}
+		}
+		switch {
+		case del && idx >= 0:
+			res = append(res[:idx], res[idx+1:]...)
+		case del:
+		case idx >= 0:
+			if err := o.mergeTree(p.Append(PathSegment{Index: idx, IsIndex: true}), res[idx], s); err != nil {
+				return nil, err
+			}
+		default:
+			res = append(res, s)
+		}
+	}
+	return res, nil
+}
+
+func containsValue(list interface{}, v interface{}) bool {
+	switch l := list.(type) {
+	case []interface{}:
+		for _, e := range l {
+			if valuesEqual(e, v) {
+				return true
+			}
+		}
+	case []*lib.Tree:
+		for _, e := range l {
+			if valuesEqual(e, v) {
+				return true
+			}
+		}
+	}
+	return false
+}
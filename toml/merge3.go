@@ -0,0 +1,176 @@
+package toml
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	lib "github.com/pelletier/go-toml"
+)
+
+// Conflict is a key changed differently on both sides of a three-way merge.
+// A nil value means the key is absent on that side.
+type Conflict struct {
+	Path   Path
+	Base   interface{}
+	Ours   interface{}
+	Theirs interface{}
+}
+
+func (c Conflict) String() string {
+	return fmt.Sprintf("%s: base %s, ours %s, theirs %s", c.Path, conflictValue(c.Base), conflictValue(c.Ours), conflictValue(c.Theirs))
+}
+
+// MarshalJSON writes the conflict as {"path": ..., "base": ..., "ours": ...,
+// "theirs": ...}, leaving out the sides where the key is absent.
+func (c Conflict) MarshalJSON() ([]byte, error) {
+	m := map[string]interface{}{"path": c.Path.String()}
+	for name, v := range map[string]interface{}{"base": c.Base, "ours": c.Ours, "theirs": c.Theirs} {
+		if v != nil {
+			m[name] = plainValue(v)
+		}
+	}
+	return json.Marshal(m)
+}
+
+// conflictValue renders one side of a conflict for humans.
+func conflictValue(v interface{}) string {
+	if v == nil {
+		return "(absent)"
+	}
+	return FormatValue(v)
+}
+
+// Merge3 applies the changes made between base and theirs to t (ours).
+// Changes touching different keys are merged; tables changed on both sides
+// are merged key by key. A key changed differently on both sides keeps our
+// value and is reported as a conflict.
+func (t *Toml) Merge3(base, theirs *Toml) []Conflict {
+	var conflicts []Conflict
+	merge3Tree(nil, base.tree, t.tree, theirs.tree, &conflicts)
+	return conflicts
+}
+
+func merge3Tree(p Path, base, ours, theirs *lib.Tree, conflicts *[]Conflict) {
+	for _, key := range unionKeys(base, ours, theirs) {
+		kp := p.Append(PathSegment{Key: key})
+		b, o, th := base.GetPath([]string{key}), ours.GetPath([]string{key}), theirs.GetPath([]string{key})
+		switch {
+		case valuesEqual(o, th), valuesEqual(b, th):
+			// Same result on both sides, or only we changed it.
+		case valuesEqual(b, o):
+			if th == nil {
+				ours.DeletePath([]string{key})
+			} else {
+				ours.SetPath([]string{key}, th)
+			}
+		default:
+			ot, oIsTree := o.(*lib.Tree)
+			tt, tIsTree := th.(*lib.Tree)
+			if oIsTree && tIsTree {
+				bt, ok := b.(*lib.Tree)
+				if !ok {
+					bt = newTree()
+				}
+				merge3Tree(kp, bt, ot, tt, conflicts)
+				continue
+			}
+			*conflicts = append(*conflicts, Conflict{Path: kp, Base: b, Ours: o, Theirs: th})
+		}
+	}
+}
+
+func unionKeys(trees ...*lib.Tree) []string {
+	seen := make(map[string]bool)
+	var keys []string
+	for _, t := range trees {
+		for _, k := range t.Keys() {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Annotate makes the next Write of t store it with the comment blocks of
+// AnnotateConflicts.
+func (t *Toml) Annotate(conflicts []Conflict) error {
+	doc, err := t.Render()
+	if err != nil {
+		return err
+	}
+	t.layout, err = AnnotateConflicts(doc, conflicts)
+	return err
+}
+
+// AnnotateConflicts inserts a comment block describing each conflict above
+// the key it concerns in doc, a rendered TOML document. Conflicts on keys
+// that are not in doc are annotated in their parent table, or at the end.
+// The result is still valid TOML.
+func AnnotateConflicts(doc []byte, conflicts []Conflict) ([]byte, error) {
+	d, err := scanDocument(doc)
+	if err != nil {
+		return nil, err
+	}
+	var edits []edit
+	var trailer strings.Builder
+	for _, c := range conflicts {
+		at, indent, found := d.conflictAnchor(c.Path)
+		text := conflictComment(c, indent)
+		if !found {
+			trailer.WriteString(text)
+			continue
+		}
+		if at > 0 && doc[at-1] != '\n' {
+			text = "\n" + text
+		}
+		edits = append(edits, edit{at, at, text})
+	}
+	out, ok := applyEdits(doc, edits)
+	if !ok {
+		return nil, fmt.Errorf("cannot annotate conflicts")
+	}
+	if trailer.Len() > 0 {
+		if len(out) > 0 && out[len(out)-1] != '\n' {
+			out = append(out, '\n')
+		}
+		out = append(out, trailer.String()...)
+	}
+	return out, nil
+}
+
+// conflictAnchor returns where the annotation for p goes.
+func (d *document) conflictAnchor(p Path) (int, string, bool) {
+	if span, ok := d.entries[p.String()]; ok {
+		return span.lineStart, span.indent, true
+	}
+	if span, ok := d.tables[p.String()]; ok && span.headerStart >= 0 {
+		return span.headerStart, span.indent, true
+	}
+	// An array of tables, at its first element
+	if span, ok := d.tables[p.Append(PathSegment{Index: 0, IsIndex: true}).String()]; ok {
+		return span.headerStart, span.indent, true
+	}
+	if len(p) > 1 {
+		if span, ok := d.tables[p[:len(p)-1].String()]; ok && span.headerStart >= 0 {
+			return span.lastEntryEnd, span.entryIndent, true
+		}
+	}
+	return 0, "", false
+}
+
+func conflictComment(c Conflict, indent string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s# CONFLICT %s (keeping ours)\n", indent, c.Path)
+	for _, side := range []struct {
+		name  string
+		value interface{}
+	}{{"base", c.Base}, {"ours", c.Ours}, {"theirs", c.Theirs}} {
+		fmt.Fprintf(&b, "%s#   %-7s %s\n", indent, side.name+":", conflictValue(side.value))
+	}
+	return b.String()
+}
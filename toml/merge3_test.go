@@ -0,0 +1,101 @@
+package toml
+
+import (
+	"encoding/json"
+	"testing"
+
+	lib "github.com/pelletier/go-toml"
+	"github.com/stretchr/testify/require"
+)
+
+const merge3Base = `# cmdb
+["ns:host:web"]
+hostname = "10.0.0.1"  # primary
+port = 22
+user = "root"
+
+["ns:host:db"]
+hostname = "10.0.0.2"
+`
+
+func TestMerge3(t *testing.T) {
+	base := loadSample(t, merge3Base)
+	ours := loadSample(t, `# cmdb
+["ns:host:web"]
+hostname = "10.0.0.1"  # primary
+port = 2222
+user = "root"
+
+["ns:host:db"]
+hostname = "10.0.0.2"
+tags = ["a"]
+`)
+	theirs := loadSample(t, `["ns:host:web"]
+hostname = "10.0.0.9"
+port = 2200
+
+["ns:host:db"]
+hostname = "10.0.0.2"
+tags = ["a"]
+
+["ns:host:new"]
+hostname = "10.0.0.3"
+`)
+
+	conflicts := ours.Merge3(base, theirs)
+	require.Len(t, conflicts, 1)
+	require.Equal(t, "ns:host:web.port", conflicts[0].Path.String())
+	require.Equal(t, int64(22), conflicts[0].Base)
+	require.Equal(t, int64(2222), conflicts[0].Ours)
+	require.Equal(t, int64(2200), conflicts[0].Theirs)
+
+	require.Equal(t, "10.0.0.9", ours.Get(`"ns:host:web".hostname`))
+	require.Nil(t, ours.Get(`"ns:host:web".user`))
+	require.Equal(t, "10.0.0.3", ours.Get(`"ns:host:new".hostname`))
+
+	out, err := ours.Render()
+	require.Nil(t, err)
+	out, err = AnnotateConflicts(out, conflicts)
+	require.Nil(t, err)
+	want := `# cmdb
+["ns:host:web"]
+hostname = "10.0.0.9"  # primary
+# CONFLICT ns:host:web.port (keeping ours)
+#   base:   22
+#   ours:   2222
+#   theirs: 2200
+port = 2222
+
+["ns:host:db"]
+hostname = "10.0.0.2"
+tags = ["a"]
+
+["ns:host:new"]
+hostname = "10.0.0.3"
+`
+	require.Equal(t, want, string(out))
+	_, err = lib.LoadBytes(out)
+	require.Nil(t, err)
+
+	// Annotate makes Render, and so Write, keep the comment blocks.
+	require.Nil(t, ours.Annotate(conflicts))
+	require.Equal(t, want, render(t, ours))
+}
+
+func TestMerge3DeleteConflict(t *testing.T) {
+	base := loadSample(t, merge3Base)
+	ours := loadSample(t, merge3Base)
+	require.Nil(t, ours.Delete("ns:host:db", ""))
+	theirs := loadSample(t, merge3Base)
+	require.Nil(t, theirs.Set("ns:host:db", "port", int64(5432)))
+
+	conflicts := ours.Merge3(base, theirs)
+	require.Len(t, conflicts, 1)
+	require.Equal(t, "ns:host:db", conflicts[0].Path.String())
+	require.Nil(t, conflicts[0].Ours)
+
+	data, err := json.Marshal(conflicts)
+	require.Nil(t, err)
+	require.JSONEq(t, `[{"path": "ns:host:db", "base": {"hostname": "10.0.0.2"},
+		"theirs": {"hostname": "10.0.0.2", "port": 5432}}]`, string(data))
+}
@@ -0,0 +1,82 @@
+package toml
+
+import (
+	"testing"
+
+	lib "github.com/pelletier/go-toml"
+	"github.com/stretchr/testify/require"
+)
+
+func mergeSample(t *testing.T, base, overlay string, opts MergeOptions) map[string]interface{} {
+	bt, err := lib.Load(base)
+	require.Nil(t, err)
+	ot, err := lib.Load(overlay)
+	require.Nil(t, err)
+	b, o := Toml{tree: bt}, Toml{tree: ot}
+	require.Nil(t, b.MergeWith(&o, opts))
+	return b.tree.ToMap()
+}
+
+const mergeBase = `
+tags = ["web", "db"]
+
+[app]
+name = "demo"
+debug = true
+
+[[servers]]
+name = "a"
+ip = "10.0.0.1"
+
+[[servers]]
+name = "b"
+ip = "10.0.0.2"
+`
+
+func TestMergeArrayStrategies(t *testing.T) {
+	overlay := `tags = ["db", "cache"]`
+
+	res := mergeSample(t, mergeBase, overlay, MergeOptions{})
+	require.Equal(t, []interface{}{"db", "cache"}, res["tags"])
+
+	res = mergeSample(t, mergeBase, overlay, MergeOptions{Arrays: MergeAppend})
+	require.Equal(t, []interface{}{"web", "db", "db", "cache"}, res["tags"])
+
+	res = mergeSample(t, mergeBase, overlay, MergeOptions{Arrays: MergeUnion})
+	require.Equal(t, []interface{}{"web", "db", "cache"}, res["tags"])
+
+	res = mergeSample(t, mergeBase, overlay, MergeOptions{Arrays: MergeUnion, Paths: map[string]string{"t*": MergeReplace}})
+	require.Equal(t, []interface{}{"db", "cache"}, res["tags"])
+
+	_, err := overlayOptions(newTree(), MergeOptions{Arrays: "keyed:"})
+	require.NotNil(t, err)
+}
+
+func TestMergeKeyedAndDelete(t *testing.T) {
+	overlay := `
+[__merge__.paths]
+servers = "keyed:name"
+
+[app]
+debug = "__delete__"
+
+[[servers]]
+name = "b"
+ip = "10.0.0.20"
+
+[[servers]]
+name = "a"
+__delete__ = true
+
+[[servers]]
+name = "c"
+ip = "10.0.0.3"
+`
+	res := mergeSample(t, mergeBase, overlay, MergeOptions{})
+	require.Equal(t, map[string]interface{}{"name": "demo"}, res["app"])
+	require.Equal(t, []interface{}{
+		map[string]interface{}{"name": "b", "ip": "10.0.0.20"},
+		map[string]interface{}{"name": "c", "ip": "10.0.0.3"},
+	}, res["servers"])
+	require.NotContains(t, res, MergeSection)
+}
@@ -0,0 +1,300 @@
+package toml
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	lib "github.com/pelletier/go-toml"
+)
+
+// PatchOp is one operation of an RFC 6902 JSON Patch.
+type PatchOp struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	From  string      `json:"from,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// JSONPointer formats p as an RFC 6901 JSON pointer, e.g. /servers/0/ip.
+func (p Path) JSONPointer() string {
+	var b strings.Builder
+	for _, seg := range p {
+		b.WriteByte('/')
+		if seg.IsIndex {
+			b.WriteString(strconv.Itoa(seg.Index))
+		} else {
+			b.WriteString(pointerEscaper.Replace(seg.Key))
+		}
+	}
+	return b.String()
+}
+
+// PatchOps converts changes into JSON Patch operations.
+func PatchOps(changes []Change) []PatchOp {
+	ops := make([]PatchOp, len(changes))
+	for i, c := range changes {
+		ops[i] = PatchOp{Op: c.Kind.String(), Path: c.Path.JSONPointer()}
+		if c.Kind != ChangeRemove {
+			ops[i].Value = plainValue(c.New)
+		}
+	}
+	return ops
+}
+
+// MarshalPatch encodes changes as an indented RFC 6902 JSON Patch document.
+func MarshalPatch(changes []Change) ([]byte, error) {
+	return json.MarshalIndent(PatchOps(changes), "", "  ")
+}
+
+// ParsePatch reads a patch document: either an RFC 6902 JSON Patch or a TOML
+// document with the same operations as an array of tables:
+//
+//	[[op]]
+//	op    = "replace"
+//	path  = "ns:host:web.port"   # a path, or a JSON pointer like /ns:host:web/port
+//	value = 2222
+func ParsePatch(data []byte) ([]PatchOp, error) {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' && !bytes.HasPrefix(trimmed, []byte("[[")) {
+		return parseJSONPatch(data)
+	}
+	if json.Valid(data) {
+		return parseJSONPatch(data)
+	}
+	tree, err := lib.LoadBytes(data)
+	if err != nil {
+		return nil, fmt.Errorf("patch is neither JSON nor TOML: %w", err)
+	}
+	var ops []PatchOp
+	switch list := tree.GetPath([]string{"op"}).(type) {
+	case nil:
+	case []*lib.Tree:
+		for i, t := range list {
+			op := PatchOp{Value: t.GetPath([]string{"value"})}
+			var ok bool
+			if op.Op, ok = t.GetPath([]string{"op"}).(string); !ok {
+				return nil, fmt.Errorf("operation %d: op is required", i+1)
+			}
+			if op.Path, ok = t.GetPath([]string{"path"}).(string); !ok {
+				return nil, fmt.Errorf("operation %d: path is required", i+1)
+			}
+			op.From, _ = t.GetPath([]string{"from"}).(string)
+			ops = append(ops, op)
+		}
+	default:
+		return nil, fmt.Errorf("op must be an array of tables ([[op]])")
+	}
+	return ops, nil
+}
+
+func parseJSONPatch(data []byte) ([]PatchOp, error) {
+	var raw []map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("invalid JSON patch: %w", err)
+	}
+	ops := make([]PatchOp, len(raw))
+	for i, m := range raw {
+		var ok bool
+		if ops[i].Op, ok = m["op"].(string); !ok {
+			return nil, fmt.Errorf("operation %d: op is required", i+1)
+		}
+		if ops[i].Path, ok = m["path"].(string); !ok {
+			return nil, fmt.Errorf("operation %d: path is required", i+1)
+		}
+		ops[i].From, _ = m["from"].(string)
+		if v, ok := m["value"]; ok {
+			if ops[i].Value = fromPlain(v); ops[i].Value == nil {
+				return nil, fmt.Errorf("operation %d: TOML has no null value", i+1)
+			}
+		}
+	}
+	return ops, nil
+}
+
+// ApplyPatch applies ops in order. Either every operation succeeds or the
+// tree is left untouched.
+func (t *Toml) ApplyPatch(ops []PatchOp) error {
+	tree := cloneValue(t.tree).(*lib.Tree)
+	for i, op := range ops {
+		if err := applyOp(tree, op); err != nil {
+			return fmt.Errorf("operation %d (%s %s): %w", i+1, op.Op, op.Path, err)
+		}
+	}
+	t.tree = tree
+	return nil
+}
+
+func applyOp(tree *lib.Tree, op PatchOp) error {
+	p, err := patchPath(tree, op.Path)
+	if err != nil {
+		return err
+	}
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return fmt.Errorf("value is required")
+		}
+	}
+
+	switch op.Op {
+	case "add":
+		return addPath(tree, p, op.Value)
+	case "remove":
+		if getPath(tree, p) == nil {
+			return fmt.Errorf("path does not exist")
+		}
+		return deletePath(tree, p)
+	case "replace":
+		if getPath(tree, p) == nil {
+			return fmt.Errorf("path does not exist")
+		}
+		return setPath(tree, p, op.Value)
+	case "move", "copy":
+		if op.From == "" {
+			return fmt.Errorf("from is required")
+		}
+		from, err := patchPath(tree, op.From)
+		if err != nil {
+			return err
+		}
+		v := getPath(tree, from)
+		if v == nil {
+			return fmt.Errorf("from %s does not exist", op.From)
+		}
+		if op.Op == "move" {
+			if hasPrefix(p, from) && len(p) > len(from) {
+				return fmt.Errorf("cannot move %s into itself", op.From)
+			}
+			if err := deletePath(tree, from); err != nil {
+				return err
+			}
+			// Removing an earlier array element shifts the target.
+			if p, err = patchPath(tree, op.Path); err != nil {
+				return err
+			}
+		} else {
+			v = cloneValue(v)
+		}
+		return addPath(tree, p, v)
+	case "test":
+		if v := getPath(tree, p); !valuesEqual(v, normalizeValue(op.Value)) {
+			return fmt.Errorf("test failed: value is %s", FormatValue(v))
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown operation %q", op.Op)
+}
+
+// patchPath resolves a JSON pointer, or a path in ParsePath syntax, against
+// tree. In a pointer, numeric segments index arrays and "-" stands for the
+// end of an array.
+func patchPath(tree *lib.Tree, s string) (Path, error) {
+	if !strings.HasPrefix(s, "/") {
+		if s == "" {
+			return nil, fmt.Errorf("cannot change the whole document")
+		}
+		return ParsePath(s)
+	}
+	var p Path
+	var cur interface{} = tree
+	for _, raw := range strings.Split(s[1:], "/") {
+		key := pointerUnescaper.Replace(raw)
+		seg := PathSegment{Key: key}
+		var n int
+		switch a := cur.(type) {
+		case []interface{}:
+			n = len(a)
+		case []*lib.Tree:
+			n = len(a)
+		default:
+			p = append(p, seg)
+			cur = step(cur, seg)
+			continue
+		}
+		if key == "-" {
+			seg = PathSegment{Index: n, IsIndex: true}
+		} else if i, err := strconv.Atoi(key); err == nil && i >= 0 && key == strconv.Itoa(i) {
+			seg = PathSegment{Index: i, IsIndex: true}
+		} else {
+			return nil, fmt.Errorf("%q is not an array index", key)
+		}
+		p = append(p, seg)
+		cur = step(cur, seg)
+	}
+	return p, nil
+}
+
+var pointerUnescaper = strings.NewReplacer("~1", "/", "~0", "~")
+
+// addPath implements the add operation: the parent has to exist, a table
+// key is set, and a value is inserted into an array before index.
+func addPath(tree *lib.Tree, p Path, value interface{}) error {
+	if len(p) == 0 {
+		return fmt.Errorf("cannot change the whole document")
+	}
+	parent := getPath(tree, p[:len(p)-1])
+	last := p[len(p)-1]
+	value = normalizeValue(value)
+	var n int
+	switch a := parent.(type) {
+	case nil:
+		return fmt.Errorf("%s does not exist", p[:len(p)-1])
+	case *lib.Tree:
+		return setPath(tree, p, value)
+	case []interface{}:
+		n = len(a)
+		if n == 0 {
+			if t, ok := value.(*lib.Tree); ok {
+				return setPath(tree, p[:len(p)-1], []*lib.Tree{t})
+			}
+		}
+		if last.IsIndex && last.Index >= 0 && last.Index <= n {
+			res := append(append(append([]interface{}{}, a[:last.Index]...), value), a[last.Index:]...)
+			return setPath(tree, p[:len(p)-1], res)
+		}
+	case []*lib.Tree:
+		n = len(a)
+		t, ok := value.(*lib.Tree)
+		if !ok {
+			return fmt.Errorf("%s is an array of tables and only accepts tables", p[:len(p)-1])
+		}
+		if last.IsIndex && last.Index >= 0 && last.Index <= n {
+			res := append(append(append([]*lib.Tree{}, a[:last.Index]...), t), a[last.Index:]...)
+			return setPath(tree, p[:len(p)-1], res)
+		}
+	default:
+		return fmt.Errorf("%s is not a table or an array", p[:len(p)-1])
+	}
+	return fmt.Errorf("index %s out of range", p)
+}
+
+// cloneValue returns a deep copy of a tree value.
+func cloneValue(v interface{}) interface{} {
+	switch n := v.(type) {
+	case *lib.Tree:
+		t := newTree()
+		for _, k := range n.Keys() {
+			t.SetPath([]string{k}, cloneValue(n.GetPath([]string{k})))
+		}
+		return t
+	case []*lib.Tree:
+		res := make([]*lib.Tree, len(n))
+		for i, e := range n {
+			res[i] = cloneValue(e).(*lib.Tree)
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, len(n))
+		for i, e := range n {
+			res[i] = cloneValue(e)
+		}
+		return res
+	}
+	return v
+}
@@ -0,0 +1,87 @@
+package toml
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const patchSample = `# cmdb
+["ns:host:web"]
+hostname = "10.0.0.1"  # primary
+port = 22
+tags = ["web"]
+
+[[servers]]
+ip = "10.0.0.5"
+`
+
+func TestApplyJSONPatch(t *testing.T) {
+	toml := loadSample(t, patchSample)
+	ops, err := ParsePatch([]byte(`[
+		{"op": "test", "path": "/ns:host:web/port", "value": 22},
+		{"op": "replace", "path": "/ns:host:web/port", "value": 2222},
+		{"op": "add", "path": "/ns:host:web/tags/-", "value": "edge"},
+		{"op": "add", "path": "/ns:host:web/tags/0", "value": "front"},
+		{"op": "add", "path": "/servers/-", "value": {"ip": "10.0.0.6"}},
+		{"op": "copy", "from": "/ns:host:web", "path": "/ns:host:web2"},
+		{"op": "remove", "path": "/ns:host:web2/tags"},
+		{"op": "move", "from": "/ns:host:web/hostname", "path": "/ns:host:web/ip"}
+	]`))
+	require.Nil(t, err)
+	require.Nil(t, toml.ApplyPatch(ops))
+
+	require.Equal(t, int64(2222), toml.Get(`"ns:host:web".port`))
+	require.Equal(t, []interface{}{"front", "web", "edge"}, toml.Get(`"ns:host:web".tags`))
+	require.Equal(t, "10.0.0.1", toml.Get(`"ns:host:web".ip`))
+	require.Nil(t, toml.Get(`"ns:host:web".hostname`))
+	require.Equal(t, "10.0.0.6", toml.Get("servers[1].ip"))
+	require.Equal(t, "10.0.0.1", toml.Get(`"ns:host:web2".hostname`))
+	require.Nil(t, toml.Get(`"ns:host:web2".tags`))
+}
+
+func TestApplyTomlPatch(t *testing.T) {
+	toml := loadSample(t, patchSample)
+	ops, err := ParsePatch([]byte(`
+[[op]]
+op = "replace"
+path = "ns:host:web.port"
+value = 2222
+
+[[op]]
+op = "add"
+path = "/ns:host:web/enabled"
+value = true
+`))
+	require.Nil(t, err)
+	require.Nil(t, toml.ApplyPatch(ops))
+
+	want := `# cmdb
+["ns:host:web"]
+hostname = "10.0.0.1"  # primary
+port = 2222
+tags = ["web"]
+enabled = true
+
+[[servers]]
+ip = "10.0.0.5"
+`
+	require.Equal(t, want, render(t, toml))
+}
+
+func TestApplyPatchAllOrNothing(t *testing.T) {
+	for _, patch := range []string{
+		`[{"op": "replace", "path": "/ns:host:web/port", "value": 1}, {"op": "test", "path": "/ns:host:web/port", "value": 22}]`,
+		`[{"op": "remove", "path": "/ns:host:web/port"}, {"op": "remove", "path": "/missing"}]`,
+		`[{"op": "add", "path": "/ns:host:web/port", "value": 1}, {"op": "add", "path": "/missing/key", "value": 1}]`,
+		`[{"op": "add", "path": "/ns:host:web/tags/5", "value": "x"}]`,
+		`[{"op": "move", "from": "/ns:host:web", "path": "/ns:host:web/inner"}]`,
+		`[{"op": "frobnicate", "path": "/ns:host:web"}]`,
+	} {
+		toml := loadSample(t, patchSample)
+		ops, err := ParsePatch([]byte(patch))
+		require.Nil(t, err)
+		require.NotNil(t, toml.ApplyPatch(ops), patch)
+		require.Equal(t, patchSample, render(t, toml))
+	}
+}
@@ -0,0 +1,313 @@
+package toml
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	lib "github.com/pelletier/go-toml"
+)
+
+// PathSegment is one step of a key path: either a table key or an array index.
+type PathSegment struct {
+	Key     string
+	Index   int
+	IsIndex bool
+}
+
+// Path addresses a value inside the tree, e.g. servers[2].ip or "ns:host:web".port
+type Path []PathSegment
+
+// ParsePath parses a dotted key path.
+//
+// Segments are separated by dots. A segment may be double quoted (with the
+// usual escapes) or single quoted (literal) when the key itself contains dots,
+// brackets or quotes. Array elements are addressed with [n]; a negative index
+// counts from the end; a path may start with an index when it is relative to
+// an array. Colons are ordinary key characters, so ns:host:web.port
+// addresses the port of the ns:host:web entry.
+func ParsePath(s string) (Path, error) {
+	if s == "" {
+		return nil, fmt.Errorf("empty path")
+	}
+	var p Path
+	i := 0
+	expectKey := true
+	for i < len(s) {
+		c := s[i]
+		switch {
+		case c == '[':
+			if expectKey && len(p) > 0 {
+				return nil, fmt.Errorf("path %q: index after a dot at offset %d", s, i)
+			}
+			end := strings.IndexByte(s[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("path %q: unterminated index at offset %d", s, i)
+			}
+			idx, err := strconv.Atoi(strings.TrimSpace(s[i+1 : i+end]))
+			if err != nil {
+				return nil, fmt.Errorf("path %q: invalid index %q", s, s[i+1:i+end])
+			}
+			p = append(p, PathSegment{Index: idx, IsIndex: true})
+			i += end + 1
+			expectKey = false
+		case c == '.':
+			if expectKey {
+				return nil, fmt.Errorf("path %q: empty segment at offset %d", s, i)
+			}
+			i++
+			expectKey = true
+			if i == len(s) {
+				return nil, fmt.Errorf("path %q: trailing dot", s)
+			}
+		default:
+			if !expectKey {
+				return nil, fmt.Errorf("path %q: unexpected %q at offset %d", s, c, i)
+			}
+			key, n, err := scanPathKey(s[i:])
+			if err != nil {
+				return nil, fmt.Errorf("path %q: %w", s, err)
+			}
+			p = append(p, PathSegment{Key: key})
+			i += n
+			expectKey = false
+		}
+	}
+	return p, nil
+}
+
+// scanPathKey reads one quoted or bare key from the start of s and returns the
+// key and the number of bytes consumed.
+func scanPathKey(s string) (string, int, error) {
+	switch s[0] {
+	case '"':
+		for i := 1; i < len(s); i++ {
+			if s[i] == '\\' {
+				i++
+				continue
+			}
+			if s[i] == '"' {
+				key, err := strconv.Unquote(s[:i+1])
+				if err != nil {
+					return "", 0, fmt.Errorf("invalid quoted key %s", s[:i+1])
+				}
+				return key, i + 1, nil
+			}
+		}
+		return "", 0, fmt.Errorf("unterminated quoted key")
+	case '\'':
+		end := strings.IndexByte(s[1:], '\'')
+		if end < 0 {
+			return "", 0, fmt.Errorf("unterminated quoted key")
+		}
+		return s[1 : end+1], end + 2, nil
+	}
+	end := strings.IndexAny(s, ".[]\"'")
+	if end < 0 {
+		end = len(s)
+	}
+	if end == 0 {
+		return "", 0, fmt.Errorf("unexpected %q", s[0])
+	}
+	return s[:end], end, nil
+}
+
+// Keys returns the key segments of the path, failing if it contains indexes.
+func (p Path) Keys() ([]string, bool) {
+	keys := make([]string, 0, len(p))
+	for _, seg := range p {
+		if seg.IsIndex {
+			return nil, false
+		}
+		keys = append(keys, seg.Key)
+	}
+	return keys, true
+}
+
+// String formats the path so that ParsePath reads it back unchanged.
+func (p Path) String() string {
+	var b strings.Builder
+	for i, seg := range p {
+		if seg.IsIndex {
+			fmt.Fprintf(&b, "[%d]", seg.Index)
+			continue
+		}
+		if i > 0 {
+			b.WriteByte('.')
+		}
+		if seg.Key == "" || strings.ContainsAny(seg.Key, ".[]\"'") {
+			b.WriteString(strconv.Quote(seg.Key))
+		} else {
+			b.WriteString(seg.Key)
+		}
+	}
+	return b.String()
+}
+
+// Append returns a new path with the given segments added.
+func (p Path) Append(segs ...PathSegment) Path {
+	np := make(Path, 0, len(p)+len(segs))
+	np = append(np, p...)
+	return append(np, segs...)
+}
+
+// getPath returns the value at p below tree, or nil if it does not exist.
+func getPath(tree *lib.Tree, p Path) interface{} {
+	var cur interface{} = tree
+	for _, seg := range p {
+		cur = step(cur, seg)
+		if cur == nil {
+			return nil
+		}
+	}
+	return cur
+}
+
+// step descends one segment from node.
+func step(node interface{}, seg PathSegment) interface{} {
+	switch n := node.(type) {
+	case *lib.Tree:
+		if seg.IsIndex {
+			return nil
+		}
+		return n.GetPath([]string{seg.Key})
+	case []*lib.Tree:
+		if i, ok := arrayIndex(seg, len(n)); ok {
+			return n[i]
+		}
+	case []interface{}:
+		if i, ok := arrayIndex(seg, len(n)); ok {
+			return n[i]
+		}
+	}
+	return nil
+}
+
+// arrayIndex resolves an index segment against an array of length n.
+func arrayIndex(seg PathSegment, n int) (int, bool) {
+	if !seg.IsIndex {
+		return 0, false
+	}
+	i := seg.Index
+	if i < 0 {
+		i += n
+	}
+	if i < 0 || i >= n {
+		return 0, false
+	}
+	return i, true
+}
+
+// setPath stores value at p, creating intermediate tables as needed.
+func setPath(tree *lib.Tree, p Path, value interface{}) error {
+	if len(p) == 0 {
+		return fmt.Errorf("empty path")
+	}
+	var cur interface{} = tree
+	for i, seg := range p[:len(p)-1] {
+		next := step(cur, seg)
+		if next == nil {
+			t, ok := cur.(*lib.Tree)
+			if !ok || seg.IsIndex {
+				return fmt.Errorf("path %s does not exist", p[:i+1])
+			}
+			next = newTree()
+			t.SetPath([]string{seg.Key}, next)
+		}
+		cur = next
+	}
+
+	last := p[len(p)-1]
+	switch n := cur.(type) {
+	case *lib.Tree:
+		if last.IsIndex {
+			return fmt.Errorf("%s is a table, not an array", p[:len(p)-1])
+		}
+		n.SetPath([]string{last.Key}, normalizeValue(value))
+		return nil
+	case []*lib.Tree:
+		i, ok := arrayIndex(last, len(n))
+		if !ok {
+			return fmt.Errorf("index %s out of range", p)
+		}
+		sub, ok := value.(*lib.Tree)
+		if !ok {
+			return fmt.Errorf("%s is an array of tables and only accepts tables", p[:len(p)-1])
+		}
+		n[i] = sub
+		return nil
+	case []interface{}:
+		i, ok := arrayIndex(last, len(n))
+		if !ok {
+			return fmt.Errorf("index %s out of range", p)
+		}
+		n[i] = normalizeValue(value)
+		return nil
+	}
+	return fmt.Errorf("%s is not a table or an array", p[:len(p)-1])
+}
+
+// deletePath removes the value at p. Removing an array element shifts the
+// remaining elements down.
+func deletePath(tree *lib.Tree, p Path) error {
+	if len(p) == 0 {
+		return fmt.Errorf("empty path")
+	}
+	parent := getPath(tree, p[:len(p)-1])
+	last := p[len(p)-1]
+	switch n := parent.(type) {
+	case *lib.Tree:
+		if last.IsIndex {
+			return fmt.Errorf("%s is a table, not an array", p[:len(p)-1])
+		}
+		return n.DeletePath([]string{last.Key})
+	case []*lib.Tree:
+		i, ok := arrayIndex(last, len(n))
+		if !ok {
+			return fmt.Errorf("index %s out of range", p)
+		}
+		rest := append(append([]*lib.Tree{}, n[:i]...), n[i+1:]...)
+		return setPath(tree, p[:len(p)-1], rest)
+	case []interface{}:
+		i, ok := arrayIndex(last, len(n))
+		if !ok {
+			return fmt.Errorf("index %s out of range", p)
+		}
+		rest := append(append([]interface{}{}, n[:i]...), n[i+1:]...)
+		return setPath(tree, p[:len(p)-1], rest)
+	}
+	return fmt.Errorf("path %s does not exist", p)
+}
+
+// newTree returns an empty table.
+func newTree() *lib.Tree {
+	t, _ := lib.TreeFromMap(map[string]interface{}{})
+	return t
+}
+
+// normalizeValue converts plain Go values (int, maps, typed slices) into the
+// representation go-toml uses inside a tree.
+func normalizeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case *lib.Tree, []*lib.Tree:
+		return value
+	case []interface{}:
+		// Keep the element type go-toml itself produces when parsing.
+		res := make([]interface{}, len(v))
+		for i, e := range v {
+			res[i] = normalizeValue(e)
+		}
+		return res
+	case map[string]interface{}:
+		t := newTree()
+		for k, e := range v {
+			t.SetPath([]string{k}, normalizeValue(e))
+		}
+		return t
+	}
+	t, err := lib.TreeFromMap(map[string]interface{}{"v": value})
+	if err != nil {
+		return value
+	}
+	return t.GetPath([]string{"v"})
+}
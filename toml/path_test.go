@@ -0,0 +1,96 @@
+package toml
+
+import (
+	"os"
+	"testing"
+
+	lib "github.com/pelletier/go-toml"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePath(t *testing.T) {
+	p, err := ParsePath(`servers[2].ip`)
+	require.Nil(t, err)
+	require.Equal(t, Path{{Key: "servers"}, {Index: 2, IsIndex: true}, {Key: "ip"}}, p)
+
+	p, err = ParsePath(`"ns:host:web.1".port`)
+	require.Nil(t, err)
+	require.Equal(t, Path{{Key: "ns:host:web.1"}, {Key: "port"}}, p)
+
+	p, err = ParsePath(`ns:host:web.'a.b'`)
+	require.Nil(t, err)
+	require.Equal(t, Path{{Key: "ns:host:web"}, {Key: "a.b"}}, p)
+	require.Equal(t, `ns:host:web."a.b"`, p.String())
+
+	for _, bad := range []string{"", "a..b", "a.", "a.[0]", "a[x]", "a[1", `"a`} {
+		_, err = ParsePath(bad)
+		require.NotNil(t, err, bad)
+	}
+}
+
+func TestNestedPaths(t *testing.T) {
+	tree, err := lib.Load(`
+["ns:host:web"]
+hostname = "10.0.0.1"
+
+[[servers]]
+ip = "10.0.0.2"
+
+[[servers]]
+ip = "10.0.0.3"
+`)
+	require.Nil(t, err)
+	toml := Toml{tree: tree}
+
+	require.Equal(t, "10.0.0.1", toml.Get("ns:host:web.hostname"))
+	require.Equal(t, "10.0.0.3", toml.Get("servers[1].ip"))
+	require.Equal(t, "10.0.0.3", toml.Get("servers[-1].ip"))
+	require.Nil(t, toml.Get("servers[2].ip"))
+
+	require.Nil(t, toml.Set("ns:host:web", "ssh.options.port", int64(2222)))
+	require.Equal(t, int64(2222), toml.Get(`"ns:host:web".ssh.options.port`))
+
+	require.Nil(t, toml.Set("servers[0]", "ip", "10.0.0.9"))
+	require.Equal(t, "10.0.0.9", toml.Get("servers[0].ip"))
+
+	require.Nil(t, toml.Delete("servers", "[0]"))
+	require.Equal(t, "10.0.0.3", toml.Get("servers[0].ip"))
+	require.Nil(t, toml.Get("servers[1]"))
+
+	require.Nil(t, toml.Delete("ns:host:web", "ssh.options"))
+	require.NotNil(t, toml.Get("ns:host:web.ssh"))
+	require.Nil(t, toml.Get("ns:host:web.ssh.options"))
+
+	require.Nil(t, toml.Clear("ns:host:web"))
+	require.Nil(t, toml.Get("ns:host:web"))
+}
+
+func TestDottedEntryKey(t *testing.T) {
+	path := writeSample(t, "[\"192.168.11.11\"]\ntitle = \"a\"\n\n[server]\nport = 1\n")
+	toml, err := NewToml(path)
+	require.Nil(t, err)
+	defer toml.Close()
+
+	// A top-level key that literally matches wins, as in Get.
+	require.Nil(t, toml.Set("192.168.11.11", "title", "123456"))
+	require.Nil(t, toml.Set("192.168.11.11", "ssh.port", int64(22)))
+	require.Equal(t, "123456", toml.Get(`"192.168.11.11".title`))
+	require.Nil(t, toml.Delete("192.168.11.11", "title"))
+	require.Nil(t, toml.Get(`"192.168.11.11".title`))
+
+	// Otherwise the entry is a path.
+	require.Nil(t, toml.Set("server.port", "", int64(2)))
+	require.Equal(t, int64(2), toml.Get("server.port"))
+	require.Nil(t, toml.Clear("server.port"))
+	require.Nil(t, toml.Get("server.port"))
+	require.Nil(t, toml.Set(`"10.0.0.7"`, "title", "db"))
+
+	require.Nil(t, toml.Clear("192.168.11.11"))
+	require.Nil(t, toml.Write())
+	data, err := os.ReadFile(path)
+	require.Nil(t, err)
+	require.Contains(t, string(data), `["10.0.0.7"]`)
+	require.NotContains(t, string(data), "192.168")
+	require.NotContains(t, string(data), "[10]")
+	require.ElementsMatch(t, []string{"10.0.0.7", "server"}, toml.Keys())
+}
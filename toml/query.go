@@ -0,0 +1,735 @@
+package toml
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	lib "github.com/pelletier/go-toml"
+)
+
+// A query is a pipeline of stages separated by '|', evaluated left to right
+// over the results of the previous stage:
+//
+//	*:host:*                     top-level keys matching a glob
+//	.hostname  .a.b  .a[0]       relative paths
+//	.*  .a[*]                    every child of a table or array
+//	..port  ..                   recursive descent
+//	select(.env == "prod")       filter (==, !=, <, <=, >, >=, =~, and, or, not)
+//	{hostname, p: .port}         projection into a new table
+//
+// e.g. '*:host:* | select(.environment=="prod") | .hostname'
+
+// Result is a value selected by a query together with where it was found.
+type Result struct {
+	Path  Path
+	Value interface{}
+}
+
+// Query evaluates expr against the tree.
+func (t *Toml) Query(expr string) ([]Result, error) {
+	q, err := parseQuery(expr)
+	if err != nil {
+		return nil, err
+	}
+	return q.eval([]Result{{Value: t.tree}})
+}
+
+// Lookup resolves a single key the way Get does and returns it as a result,
+// or no results if the key does not exist.
+func (t *Toml) Lookup(query string) []Result {
+	p, err := t.resolve(query)
+	if err != nil {
+		return nil
+	}
+	v := getPath(t.tree, p)
+	if v == nil {
+		return nil
+	}
+	return []Result{{Path: p, Value: v}}
+}
+
+// IsQuery reports whether s uses query syntax rather than naming a single key.
+func IsQuery(s string) bool {
+	return strings.ContainsAny(s, "*?|{") || strings.HasPrefix(s, ".") ||
+		strings.Contains(s, "..") || strings.Contains(s, "select(")
+}
+
+type query []stage
+
+func (q query) eval(in []Result) ([]Result, error) {
+	var err error
+	for _, s := range q {
+		if in, err = s.eval(in); err != nil {
+			return nil, err
+		}
+	}
+	return in, nil
+}
+
+type stage interface {
+	eval(in []Result) ([]Result, error)
+}
+
+type stepKind int
+
+const (
+	stepKey stepKind = iota
+	stepIndex
+	stepAll
+	stepRecurse
+)
+
+type queryStep struct {
+	kind  stepKind
+	key   string
+	glob  bool
+	index int
+}
+
+// pathStage walks each input along a list of steps.
+type pathStage struct {
+	steps []queryStep
+}
+
+func (s pathStage) eval(in []Result) ([]Result, error) {
+	cur := in
+	for _, st := range s.steps {
+		var next []Result
+		for _, r := range cur {
+			next = st.apply(r, next)
+		}
+		cur = next
+	}
+	return cur, nil
+}
+
+func (st queryStep) apply(r Result, out []Result) []Result {
+	switch st.kind {
+	case stepKey:
+		tree, ok := r.Value.(*lib.Tree)
+		if !ok {
+			return out
+		}
+		if !st.glob {
+			if v := tree.GetPath([]string{st.key}); v != nil {
+				out = append(out, Result{r.Path.Append(PathSegment{Key: st.key}), v})
+			}
+			return out
+		}
+		for _, k := range sortedKeys(tree) {
+			if globMatch(st.key, k) {
+				out = append(out, Result{r.Path.Append(PathSegment{Key: k}), tree.GetPath([]string{k})})
+			}
+		}
+	case stepIndex:
+		seg := PathSegment{Index: st.index, IsIndex: true}
+		if v := step(r.Value, seg); v != nil {
+			i, _ := arrayIndex(seg, arrayLen(r.Value))
+			out = append(out, Result{r.Path.Append(PathSegment{Index: i, IsIndex: true}), v})
+		}
+	case stepAll:
+		out = append(out, children(r)...)
+	case stepRecurse:
+		out = append(out, r)
+		for _, c := range children(r) {
+			out = st.apply(c, out)
+		}
+	}
+	return out
+}
+
+func arrayLen(v interface{}) int {
+	switch n := v.(type) {
+	case []*lib.Tree:
+		return len(n)
+	case []interface{}:
+		return len(n)
+	}
+	return 0
+}
+
+// children lists the direct children of a table or array.
+func children(r Result) []Result {
+	var out []Result
+	switch n := r.Value.(type) {
+	case *lib.Tree:
+		for _, k := range sortedKeys(n) {
+			out = append(out, Result{r.Path.Append(PathSegment{Key: k}), n.GetPath([]string{k})})
+		}
+	case []*lib.Tree:
+		for i, t := range n {
+			out = append(out, Result{r.Path.Append(PathSegment{Index: i, IsIndex: true}), t})
+		}
+	case []interface{}:
+		for i, v := range n {
+			out = append(out, Result{r.Path.Append(PathSegment{Index: i, IsIndex: true}), v})
+		}
+	}
+	return out
+}
+
+// globMatch matches s against a pattern where * matches any run of
+// characters and ? a single one.
+func globMatch(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(s); i >= 0; i-- {
+				if globMatch(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if s == "" {
+				return false
+			}
+			s, pattern = s[1:], pattern[1:]
+		default:
+			if s == "" || s[0] != pattern[0] {
+				return false
+			}
+			s, pattern = s[1:], pattern[1:]
+		}
+	}
+	return s == ""
+}
+
+// selectStage keeps the inputs for which cond holds.
+type selectStage struct {
+	cond condition
+}
+
+func (s selectStage) eval(in []Result) ([]Result, error) {
+	var out []Result
+	for _, r := range in {
+		ok, err := s.cond.test(r)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
+
+type projectField struct {
+	name string
+	expr pathStage
+}
+
+// projectStage turns each input into a table of the selected fields.
+type projectStage struct {
+	fields []projectField
+}
+
+func (s projectStage) eval(in []Result) ([]Result, error) {
+	out := make([]Result, 0, len(in))
+	for _, r := range in {
+		tree := newTree()
+		for _, f := range s.fields {
+			res, err := f.expr.eval([]Result{r})
+			if err != nil {
+				return nil, err
+			}
+			if len(res) > 0 {
+				tree.SetPath([]string{f.name}, res[0].Value)
+			}
+		}
+		out = append(out, Result{r.Path, tree})
+	}
+	return out, nil
+}
+
+type condition interface {
+	test(r Result) (bool, error)
+}
+
+type orCond []condition
+
+func (c orCond) test(r Result) (bool, error) {
+	for _, sub := range c {
+		if ok, err := sub.test(r); err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+type andCond []condition
+
+func (c andCond) test(r Result) (bool, error) {
+	for _, sub := range c {
+		if ok, err := sub.test(r); err != nil || !ok {
+			return ok, err
+		}
+	}
+	return true, nil
+}
+
+type notCond struct {
+	cond condition
+}
+
+func (c notCond) test(r Result) (bool, error) {
+	ok, err := c.cond.test(r)
+	return !ok, err
+}
+
+// operand is either a relative path or a literal.
+type operand struct {
+	path    *pathStage
+	literal interface{}
+}
+
+func (o operand) value(r Result) (interface{}, error) {
+	if o.path == nil {
+		return o.literal, nil
+	}
+	res, err := o.path.eval([]Result{r})
+	if err != nil || len(res) == 0 {
+		return nil, err
+	}
+	return res[0].Value, nil
+}
+
+type compareCond struct {
+	left, right operand
+	op          string
+	re          *regexp.Regexp
+}
+
+func (c compareCond) test(r Result) (bool, error) {
+	l, err := c.left.value(r)
+	if err != nil {
+		return false, err
+	}
+	if c.op == "" {
+		return l != nil && l != false, nil
+	}
+	if c.re != nil {
+		return l != nil && c.re.MatchString(scalarString(l)), nil
+	}
+	rv, err := c.right.value(r)
+	if err != nil {
+		return false, err
+	}
+	cmp, comparable := compareValues(l, rv)
+	switch c.op {
+	case "==":
+		return comparable && cmp == 0, nil
+	case "!=":
+		return !comparable || cmp != 0, nil
+	case "<":
+		return comparable && cmp < 0, nil
+	case "<=":
+		return comparable && cmp <= 0, nil
+	case ">":
+		return comparable && cmp > 0, nil
+	case ">=":
+		return comparable && cmp >= 0, nil
+	}
+	return false, fmt.Errorf("unknown operator %s", c.op)
+}
+
+// compareValues orders two values. Integers and floats compare numerically,
+// dates and times by their text form.
+func compareValues(a, b interface{}) (int, bool) {
+	if a == nil || b == nil {
+		return 0, a == nil && b == nil
+	}
+	if fa, ok := toFloat(a); ok {
+		if fb, ok := toFloat(b); ok {
+			switch {
+			case fa < fb:
+				return -1, true
+			case fa > fb:
+				return 1, true
+			}
+			return 0, true
+		}
+		return 0, false
+	}
+	switch av := a.(type) {
+	case bool:
+		bv, ok := b.(bool)
+		if !ok {
+			return 0, false
+		}
+		if av == bv {
+			return 0, true
+		}
+		return 1, true
+	case *lib.Tree, []*lib.Tree, []interface{}:
+		if valuesEqual(a, b) {
+			return 0, true
+		}
+		return 1, true
+	}
+	if _, ok := b.(bool); ok {
+		return 0, false
+	}
+	return strings.Compare(scalarString(a), scalarString(b)), true
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// scalarString returns the plain text form of a scalar value.
+func scalarString(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprint(v)
+}
+
+// queryParser is a small recursive descent parser over the query text.
+type queryParser struct {
+	s   string
+	pos int
+}
+
+func parseQuery(expr string) (query, error) {
+	p := &queryParser{s: expr}
+	var q query
+	for {
+		st, err := p.parseStage()
+		if err != nil {
+			return nil, err
+		}
+		q = append(q, st)
+		p.skipSpace()
+		if p.eof() {
+			return q, nil
+		}
+		if !p.consume("|") {
+			return nil, p.errorf("expected '|'")
+		}
+	}
+}
+
+func (p *queryParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("query %q: %s at offset %d", p.s, fmt.Sprintf(format, args...), p.pos)
+}
+
+func (p *queryParser) eof() bool {
+	return p.pos >= len(p.s)
+}
+
+func (p *queryParser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+func (p *queryParser) skipSpace() {
+	for !p.eof() && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *queryParser) consume(tok string) bool {
+	p.skipSpace()
+	if strings.HasPrefix(p.s[p.pos:], tok) {
+		p.pos += len(tok)
+		return true
+	}
+	return false
+}
+
+func (p *queryParser) parseStage() (stage, error) {
+	p.skipSpace()
+	switch {
+	case p.consume("select("):
+		cond, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.consume(")") {
+			return nil, p.errorf("expected ')'")
+		}
+		return selectStage{cond}, nil
+	case p.consume("{"):
+		return p.parseProjection()
+	}
+	st, err := p.parsePath(false)
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+func (p *queryParser) parseProjection() (stage, error) {
+	var s projectStage
+	for {
+		p.skipSpace()
+		name, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		expr := pathStage{steps: []queryStep{{kind: stepKey, key: name}}}
+		if p.consume(":") {
+			if expr, err = p.parsePath(true); err != nil {
+				return nil, err
+			}
+		}
+		s.fields = append(s.fields, projectField{name, expr})
+		if p.consume("}") {
+			return s, nil
+		}
+		if !p.consume(",") {
+			return nil, p.errorf("expected ',' or '}'")
+		}
+	}
+}
+
+func isQueryKeyChar(c byte) bool {
+	return !strings.ContainsRune(" \t.[]\"'|(){},:=!<>~", rune(c))
+}
+
+// parseKey reads a quoted or bare key. Bare keys may contain colons only when
+// they appear inside a path, see parsePathKey.
+func (p *queryParser) parseKey() (string, error) {
+	if c := p.peek(); c == '"' || c == '\'' {
+		key, n, err := scanPathKey(p.s[p.pos:])
+		if err != nil {
+			return "", p.errorf("%v", err)
+		}
+		p.pos += n
+		return key, nil
+	}
+	start := p.pos
+	for !p.eof() && isQueryKeyChar(p.s[p.pos]) {
+		p.pos++
+	}
+	if start == p.pos {
+		return "", p.errorf("expected a key")
+	}
+	return p.s[start:p.pos], nil
+}
+
+// parsePathKey reads one key step; bare keys may contain colons and globs.
+func (p *queryParser) parsePathKey() (queryStep, error) {
+	if c := p.peek(); c == '"' || c == '\'' {
+		key, err := p.parseKey()
+		return queryStep{kind: stepKey, key: key}, err
+	}
+	start := p.pos
+	for !p.eof() && (isQueryKeyChar(p.s[p.pos]) || p.s[p.pos] == ':') {
+		p.pos++
+	}
+	key := p.s[start:p.pos]
+	switch {
+	case key == "":
+		return queryStep{}, p.errorf("expected a key")
+	case key == "*":
+		return queryStep{kind: stepAll}, nil
+	}
+	return queryStep{kind: stepKey, key: key, glob: strings.ContainsAny(key, "*?")}, nil
+}
+
+func (p *queryParser) atKey() bool {
+	c := p.peek()
+	return c == '"' || c == '\'' || (c != 0 && (isQueryKeyChar(c) || c == ':'))
+}
+
+// parsePath reads a path expression. Relative paths (inside select and
+// projections) must start with a dot.
+func (p *queryParser) parsePath(relative bool) (pathStage, error) {
+	p.skipSpace()
+	var s pathStage
+	switch {
+	case p.peek() == '.':
+	case !relative && p.atKey():
+		st, err := p.parsePathKey()
+		if err != nil {
+			return s, err
+		}
+		s.steps = append(s.steps, st)
+	default:
+		return s, p.errorf("expected a path")
+	}
+
+	for !p.eof() {
+		switch {
+		case strings.HasPrefix(p.s[p.pos:], ".."):
+			p.pos += 2
+			s.steps = append(s.steps, queryStep{kind: stepRecurse})
+			if p.atKey() {
+				st, err := p.parsePathKey()
+				if err != nil {
+					return s, err
+				}
+				s.steps = append(s.steps, st)
+			}
+		case p.peek() == '.':
+			p.pos++
+			if p.atKey() {
+				st, err := p.parsePathKey()
+				if err != nil {
+					return s, err
+				}
+				s.steps = append(s.steps, st)
+			} else if p.peek() != '[' && len(s.steps) > 0 {
+				return s, p.errorf("expected a key after '.'")
+			}
+		case p.peek() == '[':
+			end := strings.IndexByte(p.s[p.pos:], ']')
+			if end < 0 {
+				return s, p.errorf("unterminated index")
+			}
+			inner := strings.TrimSpace(p.s[p.pos+1 : p.pos+end])
+			if inner == "*" {
+				s.steps = append(s.steps, queryStep{kind: stepAll})
+			} else {
+				i, err := strconv.Atoi(inner)
+				if err != nil {
+					return s, p.errorf("invalid index %q", inner)
+				}
+				s.steps = append(s.steps, queryStep{kind: stepIndex, index: i})
+			}
+			p.pos += end + 1
+		default:
+			return s, nil
+		}
+	}
+	return s, nil
+}
+
+func (p *queryParser) parseOr() (condition, error) {
+	var c orCond
+	for {
+		sub, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		c = append(c, sub)
+		if !p.consumeWord("or") {
+			break
+		}
+	}
+	if len(c) == 1 {
+		return c[0], nil
+	}
+	return c, nil
+}
+
+func (p *queryParser) parseAnd() (condition, error) {
+	var c andCond
+	for {
+		sub, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		c = append(c, sub)
+		if !p.consumeWord("and") {
+			break
+		}
+	}
+	if len(c) == 1 {
+		return c[0], nil
+	}
+	return c, nil
+}
+
+// consumeWord consumes a keyword followed by a non key character.
+func (p *queryParser) consumeWord(w string) bool {
+	p.skipSpace()
+	rest := p.s[p.pos:]
+	if strings.HasPrefix(rest, w) && (len(rest) == len(w) || !isQueryKeyChar(rest[len(w)])) {
+		p.pos += len(w)
+		return true
+	}
+	return false
+}
+
+func (p *queryParser) parseUnary() (condition, error) {
+	if p.consumeWord("not") {
+		c, err := p.parseUnary()
+		return notCond{c}, err
+	}
+	if p.consume("(") {
+		c, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.consume(")") {
+			return nil, p.errorf("expected ')'")
+		}
+		return c, nil
+	}
+
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	c := compareCond{left: left}
+	for _, op := range []string{"==", "!=", "=~", "<=", ">=", "<", ">"} {
+		if p.consume(op) {
+			c.op = op
+			break
+		}
+	}
+	if c.op == "" {
+		return c, nil
+	}
+	if c.right, err = p.parseOperand(); err != nil {
+		return nil, err
+	}
+	if c.op == "=~" {
+		pattern, ok := c.right.literal.(string)
+		if c.right.path != nil || !ok {
+			return nil, p.errorf("=~ expects a string pattern")
+		}
+		if c.re, err = regexp.Compile(pattern); err != nil {
+			return nil, p.errorf("%v", err)
+		}
+	}
+	return c, nil
+}
+
+func (p *queryParser) parseOperand() (operand, error) {
+	p.skipSpace()
+	switch c := p.peek(); {
+	case c == '.':
+		s, err := p.parsePath(true)
+		return operand{path: &s}, err
+	case c == '"' || c == '\'':
+		s, err := p.parseKey()
+		return operand{literal: s}, err
+	}
+	start := p.pos
+	for !p.eof() && (isQueryKeyChar(p.s[p.pos]) || p.s[p.pos] == '.') {
+		p.pos++
+	}
+	word := p.s[start:p.pos]
+	switch word {
+	case "true":
+		return operand{literal: true}, nil
+	case "false":
+		return operand{literal: false}, nil
+	case "null":
+		return operand{}, nil
+	}
+	if i, err := strconv.ParseInt(word, 10, 64); err == nil {
+		return operand{literal: i}, nil
+	}
+	if f, err := strconv.ParseFloat(word, 64); err == nil {
+		return operand{literal: f}, nil
+	}
+	p.pos = start
+	return operand{}, p.errorf("expected a path or a literal")
+}
@@ -0,0 +1,85 @@
+package toml
+
+import (
+	"testing"
+
+	lib "github.com/pelletier/go-toml"
+	"github.com/stretchr/testify/require"
+)
+
+const querySample = `
+["ns:host:web"]
+hostname = "10.0.0.1"
+port = 22
+environment = "prod"
+
+["ns:host:dev"]
+hostname = "10.0.0.2"
+port = 2222
+environment = "dev"
+
+["ns:db:main"]
+hostname = "10.0.0.3"
+port = 5432
+
+[[servers]]
+ip = "10.0.1.1"
+
+[[servers]]
+ip = "10.0.1.2"
+`
+
+func queryValues(t *testing.T, expr string) []interface{} {
+	tree, err := lib.Load(querySample)
+	require.Nil(t, err)
+	toml := Toml{tree: tree}
+	res, err := toml.Query(expr)
+	require.Nil(t, err, expr)
+	values := make([]interface{}, len(res))
+	for i, r := range res {
+		values[i] = r.Value
+	}
+	return values
+}
+
+func TestQuery(t *testing.T) {
+	require.Equal(t, []interface{}{"10.0.0.2", "10.0.0.1"}, queryValues(t, `*:host:* | .hostname`))
+	require.Equal(t, []interface{}{"10.0.0.1"}, queryValues(t, `*:host:* | select(.environment=="prod") | .hostname`))
+	require.Equal(t, []interface{}{int64(5432)}, queryValues(t, `ns:db:*.port`))
+	require.Equal(t, []interface{}{"10.0.1.2"}, queryValues(t, `servers[1].ip`))
+	require.Equal(t, []interface{}{"10.0.1.1", "10.0.1.2"}, queryValues(t, `servers[*].ip`))
+	require.Equal(t, []interface{}{int64(5432), int64(2222), int64(22)}, queryValues(t, `..port | select(. < 6000)`))
+	require.Equal(t, []interface{}{"10.0.0.2"}, queryValues(t, `.* | select(.port > 1000 and not (.environment == null)) | .hostname`))
+	require.Equal(t, []interface{}{"10.0.0.3"}, queryValues(t, `*:*:* | select(.hostname =~ "\\.3$" or .port == 1) | .hostname`))
+
+	res := queryValues(t, `*:host:web | {hostname, p: .port}`)
+	require.Len(t, res, 1)
+	require.Equal(t, map[string]interface{}{"hostname": "10.0.0.1", "p": int64(22)}, res[0].(*lib.Tree).ToMap())
+
+	toml := Toml{tree: newTree()}
+	for _, bad := range []string{`select(.a ==`, `.a |`, `{a`, `.a[x]`, `select(.a =~ .b)`} {
+		_, err := toml.Query(bad)
+		require.NotNil(t, err, bad)
+	}
+}
+
+func TestFormatResults(t *testing.T) {
+	tree, err := lib.Load(querySample)
+	require.Nil(t, err)
+	toml := Toml{tree: tree}
+
+	res, err := toml.Query(`*:host:* | .port`)
+	require.Nil(t, err)
+
+	out, err := FormatResults(res, FormatRaw)
+	require.Nil(t, err)
+	require.Equal(t, "2222\n22\n", out)
+
+	out, err = FormatResults(res, FormatJson)
+	require.Nil(t, err)
+	require.Equal(t, "2222\n22\n", out)
+
+	out, err = FormatResults(res, FormatToml)
+	require.Nil(t, err)
+	require.Equal(t, "\n[\"ns:host:dev\"]\nport = 2222\n\n[\"ns:host:web\"]\nport = 22\n"[1:], out)
+}
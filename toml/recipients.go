@@ -0,0 +1,32 @@
+package toml
+
+import (
+	"github.com/MinseokOh/toml-cli/encrypt"
+)
+
+// readRecipients returns the recipients data is encrypted to, if any.
+func readRecipients(data []byte) ([]encrypt.Recipient, error) {
+	if !encrypt.IsRecipientEncrypted(data) {
+		return nil, nil
+	}
+	encryptData, err := encrypt.ParseEncryptData(data)
+	if err != nil {
+		return nil, err
+	}
+	recipients := make([]encrypt.Recipient, len(encryptData.Recipients))
+	for i, r := range encryptData.Recipients {
+		recipients[i] = encrypt.Recipient{Name: r.Name, PublicKey: r.PublicKey}
+	}
+	return recipients, nil
+}
+
+// Recipients returns the recipients the file is encrypted to.
+func (t *Toml) Recipients() []encrypt.Recipient {
+	return t.recipients
+}
+
+// SetRecipients makes Write encrypt the file to recipients, with a new file
+// key. With no recipients the file is written as it would be otherwise.
+func (t *Toml) SetRecipients(recipients []encrypt.Recipient) {
+	t.recipients = recipients
+}
@@ -0,0 +1,129 @@
+package toml
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/MinseokOh/toml-cli/agent"
+	"github.com/MinseokOh/toml-cli/encrypt"
+	lib "github.com/pelletier/go-toml"
+)
+
+// ErrVerify is returned by Rekey when the re-encrypted file does not decrypt
+// to the original content.
+var ErrVerify = errors.New("re-encrypted file does not decrypt to the original")
+
+// Rekey re-encrypts the file at path, encrypted whole or in field mode, with
+// newPassword instead of oldPassword, deriving the new key with the KDF kdf
+// (encrypt.DefaultKDF if empty). The plaintext never reaches the disk and the
+// file is replaced atomically. With verify the result is decrypted again
+// before it replaces the file. A key the agent kept for the file is replaced
+// by the new one.
+func Rekey(path, oldPassword, newPassword, kdf string, verify bool) error {
+	if kdf == "" {
+		kdf = encrypt.DefaultKDF
+	}
+	lock, err := acquireLock(path, true)
+	if err != nil {
+		return err
+	}
+	defer releaseLock(lock)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var out, newKey []byte
+	// IDs the old and the new key are kept by
+	var oldID, newID string
+	switch {
+	case encrypt.IsRecipientEncrypted(data):
+		return fmt.Errorf("%s is encrypted to recipients, use \"cm recipients rewrap\"", path)
+	case encrypt.IsEncrypted(data):
+		oldKDF, err := encrypt.PasswordKDF(data)
+		if err != nil {
+			return err
+		}
+		plain, err := encrypt.Decrypt(string(data), oldPassword)
+		if err != nil {
+			return encrypt.ErrWrongPassword
+		}
+		newKDF, err := encrypt.NewKDF(kdf)
+		if err != nil {
+			return err
+		}
+		if newKey, err = newKDF.Derive(newPassword); err != nil {
+			return err
+		}
+		binding, err := encrypt.ReadBinding(data)
+		if err != nil {
+			return err
+		}
+		if err := checkSeen(path, binding); err != nil {
+			return err
+		}
+		oldID = fileKeyID(binding, oldKDF)
+		if binding, err = nextBinding(path, binding); err != nil {
+			return err
+		}
+		newID = fileKeyID(binding, newKDF)
+		enc, err := encrypt.EncryptWithKey(plain, newKey, newKDF, binding)
+		if err != nil {
+			return err
+		}
+		out = []byte(enc)
+		if verify {
+			if again, err := encrypt.Decrypt(enc, newPassword); err != nil || !bytes.Equal(again, plain) {
+				return ErrVerify
+			}
+		}
+	case IsFieldEncrypted(data):
+		tree, err := lib.LoadBytes(data)
+		if err != nil {
+			return err
+		}
+		old, err := readFields(tree)
+		if err != nil {
+			return err
+		}
+		oldID = old.kdf.ID()
+		plain, err := DecryptFields(data, oldPassword)
+		if err != nil {
+			return err
+		}
+		var f *fieldCrypt
+		if out, f, err = encryptFields(plain, newPassword, kdf, old.keys); err != nil {
+			return err
+		}
+		newID, newKey = f.kdf.ID(), f.key
+		if verify {
+			if again, err := DecryptFields(out, newPassword); err != nil || !bytes.Equal(again, plain) {
+				return ErrVerify
+			}
+		}
+	default:
+		return fmt.Errorf("%s is not encrypted with a password", path)
+	}
+
+	if err := WriteFileAtomic(path, out); err != nil {
+		return err
+	}
+	if encrypt.IsEncrypted(out) {
+		if err := RememberFile(path, out); err != nil {
+			return err
+		}
+	}
+
+	// The agent keeps the file unlocked if it was. The IDs are the same for
+	// a file keyed by its binding.
+	held, err := agent.Get(oldID)
+	agent.Forget(oldID)
+	delete(keys, oldID)
+	if err == nil && held != nil {
+		agent.Put(newID, newKey, AgentTTL)
+	}
+	return nil
+}
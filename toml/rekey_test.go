@@ -0,0 +1,47 @@
+package toml
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/MinseokOh/toml-cli/agent"
+	"github.com/MinseokOh/toml-cli/encrypt"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRekey(t *testing.T) {
+	t.Setenv(agent.SocketEnv, filepath.Join(t.TempDir(), "agent.sock"))
+	t.Setenv(SeenEnv, filepath.Join(t.TempDir(), "seen.json"))
+
+	enc, err := encrypt.Encrypt([]byte(fieldsSample), "old")
+	require.Nil(t, err)
+	fields, err := EncryptFields([]byte(fieldsSample), "old", encrypt.KDFPBKDF2, nil)
+	require.Nil(t, err)
+
+	for name, content := range map[string][]byte{"file": []byte(enc), "fields": fields} {
+		path := writeSample(t, string(content))
+		require.ErrorIs(t, Rekey(path, "wrong", "new", "", true), encrypt.ErrWrongPassword, name)
+
+		require.Nil(t, Rekey(path, "old", "new", encrypt.KDFScrypt, true), name)
+		data, err := os.ReadFile(path)
+		require.Nil(t, err)
+		require.NotEqual(t, content, data, name)
+
+		var plain []byte
+		if name == "file" {
+			_, err = encrypt.Decrypt(string(data), "old")
+			require.NotNil(t, err)
+			plain, err = encrypt.Decrypt(string(data), "new")
+		} else {
+			_, err = DecryptFields(data, "old")
+			require.NotNil(t, err)
+			plain, err = DecryptFields(data, "new")
+		}
+		require.Nil(t, err, name)
+		require.Equal(t, fieldsSample, string(plain), name)
+	}
+
+	path := writeSample(t, fieldsSample)
+	require.NotNil(t, Rekey(path, "old", "new", encrypt.KDFScrypt, true))
+}
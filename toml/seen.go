@@ -0,0 +1,154 @@
+package toml
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/MinseokOh/toml-cli/encrypt"
+)
+
+// The binding of every encrypted file read or written is remembered by path
+// in ~/.config/cmdb/seen.json, or $CMDB_SEEN. An encrypted file whose counter
+// is below the one last seen at its path was rolled back, e.g. replaced by an
+// older copy; one with another ID, or none, was replaced by another file
+// encrypted with the same password or to the same recipients. Both decrypt
+// fine, since the binding is authenticated, so only this record tells them
+// apart.
+
+// SeenEnv names the file remembering the bindings seen, if set.
+const SeenEnv = "CMDB_SEEN"
+
+// AllowRollback makes reading a rolled back or replaced file print a warning
+// instead of failing with ErrRollback.
+var AllowRollback bool
+
+// ErrRollback is returned when reading an encrypted file older than the one
+// last seen at its path, or another file.
+var ErrRollback = errors.New("encrypted file was rolled back")
+
+// seenPath returns the path of the file remembering the bindings seen.
+func seenPath() string {
+	if p := os.Getenv(SeenEnv); p != "" {
+		return p
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = os.TempDir()
+	}
+	return filepath.Join(home, ".config", "cmdb", "seen.json")
+}
+
+// loadSeen returns the bindings seen, by absolute path.
+func loadSeen() (map[string]encrypt.Binding, error) {
+	seen := make(map[string]encrypt.Binding)
+	data, err := os.ReadFile(seenPath())
+	if os.IsNotExist(err) {
+		return seen, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &seen); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", seenPath(), err)
+	}
+	return seen, nil
+}
+
+// lastSeen returns the binding last seen at path, empty if none.
+func lastSeen(path string) (encrypt.Binding, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return encrypt.Binding{}, err
+	}
+	seen, err := loadSeen()
+	if err != nil {
+		return encrypt.Binding{}, err
+	}
+	return seen[abs], nil
+}
+
+// remember records b as seen at path, unless a later revision of the same
+// file was seen there, or replace is set.
+func remember(path string, b encrypt.Binding, replace bool) error {
+	if b.ID == "" {
+		return nil
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	file := seenPath()
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	lock, err := acquireLock(file, true)
+	if err != nil {
+		return err
+	}
+	defer releaseLock(lock)
+
+	seen, err := loadSeen()
+	if err != nil {
+		return err
+	}
+	if last, ok := seen[abs]; ok && !replace && last.ID == b.ID && last.Counter >= b.Counter {
+		return nil
+	}
+	seen[abs] = b
+	data, err := json.MarshalIndent(seen, "", "  ")
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(file, append(data, '\n'))
+}
+
+// checkSeen fails with ErrRollback if b, read at path, is not the file or
+// the revision last seen there, and remembers it otherwise. A file without a
+// binding where one was seen is a rollback too. With AllowRollback it warns
+// instead, and a replaced file is remembered.
+func checkSeen(path string, b encrypt.Binding) error {
+	last, err := lastSeen(path)
+	if err != nil {
+		return err
+	}
+	var rollback error
+	switch {
+	case last.ID == "":
+	case b.ID == "":
+		rollback = fmt.Errorf("%w: %s has no file ID, file %s was seen", ErrRollback, path, last.ID)
+	case last.ID != b.ID:
+		rollback = fmt.Errorf("%w: %s is file %s, not %s as last seen", ErrRollback, path, b.ID, last.ID)
+	case b.Counter < last.Counter:
+		rollback = fmt.Errorf("%w: %s is at revision %d, revision %d was seen", ErrRollback, path, b.Counter, last.Counter)
+	}
+	if rollback != nil {
+		if !AllowRollback {
+			return rollback
+		}
+		fmt.Fprintf(os.Stderr, "warning: %v\n", rollback)
+	}
+	return remember(path, b, last.ID != b.ID)
+}
+
+// nextBinding returns the binding to write at path, the next revision of b.
+// It follows the revision last seen there if that is later, so that the
+// counter keeps increasing after a rollback was allowed.
+func nextBinding(path string, b encrypt.Binding) (encrypt.Binding, error) {
+	if last, err := lastSeen(path); err == nil && last.ID == b.ID && last.Counter > b.Counter {
+		b.Counter = last.Counter
+	}
+	return b.Next()
+}
+
+// RememberFile records the binding of data, encrypted content just written to
+// path, as seen there, replacing whatever was, e.g. after "cm encrypt".
+func RememberFile(path string, data []byte) error {
+	b, err := encrypt.ReadBinding(data)
+	if err != nil {
+		return err
+	}
+	return remember(path, b, true)
+}
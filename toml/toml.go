@@ -1,10 +1,13 @@
 package toml
 
 import (
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
+	"os"
 	"strings"
 
+	"github.com/MinseokOh/toml-cli/encrypt"
 	lib "github.com/pelletier/go-toml"
 )
 
@@ -14,19 +17,44 @@ type Toml struct {
 	out  string
 
 	raw []byte
+	// layout, if set, is rendered instead of raw; see Restore
+	layout []byte
+	// hash of the file as read from disk, to detect changes made by others
+	hash [sha256.Size]byte
+	lock string
 
 	tree *lib.Tree
+	// fields is set for files in field mode, see FieldsKey
+	fields *fieldCrypt
+	// recipients is set for files encrypted to recipients
+	recipients []encrypt.Recipient
+	// key and kdf are set for files encrypted with a password
+	key []byte
+	kdf *encrypt.KDF
+	// binding of the file as read, if encrypted whole
+	binding encrypt.Binding
 }
 
-// NewToml returns the Toml
+// NewToml returns the Toml. The file stays locked, shared with other readers,
+// until Close.
 func NewToml(path string) (Toml, error) {
 	toml := Toml{path: path}
 
+	if _, err := os.Stat(path); err != nil {
+		return toml, err
+	}
+	var err error
+	if toml.lock, err = acquireLock(path, false); err != nil {
+		return toml, err
+	}
+
 	if err := toml.readFile(); err != nil {
+		toml.Close()
 		return toml, err
 	}
 
 	if err := toml.load(); err != nil {
+		toml.Close()
 		return toml, err
 	}
 
@@ -35,39 +63,82 @@ func NewToml(path string) (Toml, error) {
 
 func (t *Toml) load() error {
 	var err error
-	t.tree, err = lib.LoadBytes(t.raw)
+	if t.tree, err = lib.LoadBytes(t.raw); err != nil {
+		return err
+	}
+	t.fields, err = readFields(t.tree)
 	return err
 }
 
+// Close releases the lock on the file.
+func (t *Toml) Close() {
+	releaseLock(t.lock)
+	t.lock = ""
+}
+
 // Dest set output given path
 func (t *Toml) Out(path string) {
 	t.out = path
 }
 
-// Get the value at key in the Tree.
-// [Wrapped function go-toml.]
+// Get the value at query in the Tree.
+// query is a path as understood by ParsePath, e.g. server.port,
+// "ns:host:web".port or servers[2].ip. A top-level key that literally
+// matches query (such as an IP address) takes precedence.
+//
+// Encrypted fields are decrypted; if that fails they are returned as stored.
 func (t *Toml) Get(query string) interface{} {
-	v := t.tree.GetPath([]string{query})
+	p, err := t.resolve(query)
+	if err != nil {
+		return nil
+	}
+	v := getPath(t.tree, p)
+	if plain, err := t.Reveal(v); err == nil {
+		return plain
+	}
 	return v
 }
 
-// Set the value at key in the Tree.
-// [Wrapped function go-toml.]
+// Set the value at query.attr in the Tree, creating tables as needed.
+// query is resolved as by Get; attr may be empty, or a path relative to it.
 func (t *Toml) Set(query, attr string, data interface{}) error {
-	path := []string{query}
-	if attr != "" {
-		path = append(path, attr)
+	p, err := t.resolveAttr(query, attr)
+	if err != nil {
+		return err
+	}
+	return setPath(t.tree, p, data)
+}
+
+// resolve turns a user supplied query into a Path.
+func (t *Toml) resolve(query string) (Path, error) {
+	if t.tree.HasPath([]string{query}) {
+		return Path{{Key: query}}, nil
+	}
+	return ParsePath(query)
+}
+
+// resolveAttr resolves the entry query and appends the relative path attr.
+func (t *Toml) resolveAttr(query, attr string) (Path, error) {
+	p, err := t.resolve(query)
+	if err != nil {
+		return nil, err
+	}
+	if attr == "" {
+		return p, nil
+	}
+	sub, err := ParsePath(attr)
+	if err != nil {
+		return nil, err
 	}
-	t.tree.SetPath(path, data)
-	return nil
+	return p.Append(sub...), nil
 }
 
 func (t *Toml) Keys() []string {
-	return t.tree.Keys()
+	return removeString(t.tree.Keys(), FieldsKey)
 }
 func (t *Toml) List(query string) []string {
 	dst := make([]string, 0)
-	for _, k := range t.tree.Keys() {
+	for _, k := range t.Keys() {
 		var business, comment any = "", ""
 		if v := t.tree.GetPath([]string{k, "business"}); v != nil {
 			business = v
@@ -86,20 +157,26 @@ func (t *Toml) List(query string) []string {
 	}
 	return dst
 }
+
+// Delete removes attr (a path relative to query) from the entry at query,
+// resolved as by Get.
+// Missing paths are ignored.
 func (t *Toml) Delete(query, attr string) error {
-	path := []string{query, attr}
-	if !t.tree.HasPath(path) {
+	p, err := t.resolveAttr(query, attr)
+	if err != nil {
+		return err
+	}
+	if getPath(t.tree, p) == nil {
 		return nil
 	}
-	return t.tree.DeletePath(path)
+	return deletePath(t.tree, p)
 }
+
+// Clear removes the whole value at query. Missing paths are ignored.
 func (t *Toml) Clear(query string) error {
-	path := []string{query}
-	if !t.tree.HasPath(path) {
-		return nil
-	}
-	return t.tree.DeletePath(path)
+	return t.Delete(query, "")
 }
+
 func (t *Toml) ToJson() (string, error) {
 	res := t.tree.ToMap()
 	m, err := json.MarshalIndent(res, "", " ")
@@ -112,32 +189,22 @@ func (t *Toml) ToJson() (string, error) {
 func (t *Toml) ToToml() (string, error) {
 	return t.tree.ToTomlString()
 }
-// Merge merges another TOML file into this one
-func (t *Toml) Merge(other *Toml) error {
-	return t.mergeTree(t.tree, other.tree)
-}
-
-// mergeTree recursively merges source tree into target tree
-func (t *Toml) mergeTree(target, source *lib.Tree) error {
-	for _, key := range source.Keys() {
-		sourceValue := source.Get(key)
-
-		if target.Has(key) {
-			targetValue := target.Get(key)
 
-			// If both are trees (nested objects), merge recursively
-			if sourceTree, ok := sourceValue.(*lib.Tree); ok {
-				if targetTree, ok := targetValue.(*lib.Tree); ok {
-					if err := t.mergeTree(targetTree, sourceTree); err != nil {
-						return err
-					}
-					continue
-				}
-			}
-		}
+// Render returns the TOML text of the tree. Entries that did not change since
+// the file was loaded keep their original formatting and comments.
+func (t *Toml) Render() ([]byte, error) {
+	raw := t.raw
+	if t.layout != nil {
+		raw = t.layout
+	}
+	return renderTree(raw, t.tree)
+}
 
-		// For all other cases (primitives, arrays, or new keys), overwrite
-		target.Set(key, sourceValue)
+// Changes lists the differences between the loaded file and the current tree.
+func (t *Toml) Changes() ([]Change, error) {
+	old, err := lib.LoadBytes(t.raw)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	return diffTrees(old, t.tree), nil
 }
@@ -1,53 +1,118 @@
 package toml
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 
 	"github.com/MinseokOh/toml-cli/encrypt"
+	lib "github.com/pelletier/go-toml"
 )
 
+// ErrModified is returned by Write when the file changed on disk after it was
+// loaded, e.g. by an editor that does not honour the lock.
+var ErrModified = errors.New("file was modified since it was loaded")
+
+// ErrPlaintext is returned by Write and CheckPlaintext when writing an
+// encrypted file out in plaintext was not confirmed.
+var ErrPlaintext = errors.New("refusing to write an encrypted file in plaintext")
+
+// Encryption is how Write encrypts what it writes.
+type Encryption int
+
+const (
+	// EncryptAsSource encrypts as the file loaded is, see Write.
+	EncryptAsSource Encryption = iota
+	// EncryptAlways also encrypts a plaintext file, with a new password.
+	EncryptAlways
+	// EncryptNever writes plaintext, once ConfirmPlaintext agrees if
+	// anything was encrypted.
+	EncryptNever
+)
+
+// WriteEncryption is how Write encrypts what it writes.
+var WriteEncryption = EncryptAsSource
+
+// ConfirmPlaintext is asked before Write writes path in plaintext although
+// the file loaded, or the file at path, is encrypted, and by CheckPlaintext.
+// If nil it refuses.
+var ConfirmPlaintext func(path string) (bool, error)
+
 func (t *Toml) readFile() error {
-	var err error
-	t.raw, err = os.ReadFile(t.path)
+	data, err := os.ReadFile(t.path)
 	if err != nil {
 		return err
 	}
+	t.hash = sha256.Sum256(data)
+	if err := t.decrypt(data); err != nil {
+		return err
+	}
+	if encrypt.IsEncrypted(data) {
+		if t.binding, err = encrypt.ReadBinding(data); err != nil {
+			return err
+		}
+		return checkSeen(t.path, t.binding)
+	}
+	return nil
+}
 
-	// Check if file is encrypted and decrypt if necessary
-	if encrypt.IsEncrypted(t.raw) {
-		var password string
-		var prompt bool
-		// Check if password file exists and is not expired
-		passwordData, err := encrypt.ReadPasswordFile()
+// decrypt sets the content of t to data, decrypted if it is encrypted, and
+// keeps how it is encrypted for Write.
+func (t *Toml) decrypt(data []byte) error {
+	var err error
+	if t.recipients, err = readRecipients(data); err != nil {
+		return err
+	}
+	if encrypt.IsEncrypted(data) && t.recipients == nil {
+		if t.key, t.kdf, err = passwordKey(data); err != nil {
+			return fmt.Errorf("failed to decrypt file: %w", err)
+		}
+		t.raw, err = encrypt.DecryptWithKey(string(data), t.key)
+		return err
+	}
+	t.raw, err = decode(data)
+	return err
+}
+
+// decode decrypts file content if it is encrypted.
+func decode(data []byte) ([]byte, error) {
+	if !encrypt.IsEncrypted(data) {
+		return data, nil
+	}
+	if encrypt.IsRecipientEncrypted(data) {
+		id, err := encrypt.LoadIdentity()
 		if err != nil {
-			// No password file or expired, prompt for new password
-			fmt.Println("Please enter password to decrypt the cmdb file:")
-			newPassword, promptErr := encrypt.PromptPassword(false)
-			if promptErr != nil {
-				return fmt.Errorf("failed to prompt for password: %w", promptErr)
-			}
-			password = newPassword
-			prompt = true
-		} else {
-			password = passwordData.Password
+			return nil, fmt.Errorf("failed to decrypt file: %w", err)
 		}
-		// Decrypt the file content
-		t.raw, err = encrypt.Decrypt(string(t.raw), password)
+		plain, err := encrypt.DecryptWith(string(data), id)
 		if err != nil {
-			return fmt.Errorf("failed to decrypt file: %w", err)
+			return nil, fmt.Errorf("failed to decrypt file: %w", err)
 		}
+		return plain, nil
+	}
+	key, _, err := passwordKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt file: %w", err)
+	}
+	return encrypt.DecryptWithKey(string(data), key)
+}
 
-		if prompt {
-			encrypt.SavePassword(password)
-		} else {
-			// Update last access time
-			encrypt.UpdateLastAccess()
+// nextBinding returns the binding of the revision Write makes at path: the
+// next of the file loaded, or of the encrypted file at path if it is another.
+func (t *Toml) nextBinding(path string) (encrypt.Binding, error) {
+	b := t.binding
+	if path != t.path {
+		b = encrypt.Binding{}
+		if data, err := os.ReadFile(path); err == nil && encrypt.IsEncrypted(data) {
+			if b, err = encrypt.ReadBinding(data); err != nil {
+				return b, err
+			}
 		}
-
 	}
-
-	return nil
+	return nextBinding(path, b)
 }
 
 // isFileEncrypted checks if a file is encrypted
@@ -61,45 +126,294 @@ func isFileEncrypted(path string) (bool, error) {
 
 // Write edited toml tree given path.
 // if dest is not setted, overwrite it.
+//
+// What is written is encrypted as the file loaded was, whatever the path:
+// with the same password, or to the same recipients; a plaintext file written
+// over an encrypted one is encrypted with its password. WriteEncryption
+// overrides this.
 func (t *Toml) Write() error {
 	path := t.out
 	if path == "" {
 		path = t.path
 	}
 
-	toml, err := t.tree.ToTomlString()
+	if t.fields != nil {
+		old, err := lib.LoadBytes(t.raw)
+		if err != nil {
+			return err
+		}
+		if t.tree, err = t.fields.seal(t.tree, old); err != nil {
+			return fmt.Errorf("failed to encrypt fields: %w", err)
+		}
+	}
+	toml, err := t.Render()
 	if err != nil {
 		return err
 	}
 
-	// Check if the target file should be encrypted
-	shouldEncrypt, err := isFileEncrypted(path)
+	// previous is the content being replaced, kept in the history.
+	var previous []byte
+	if path == t.path {
+		if err := upgradeLock(t.path, t.lock); err != nil {
+			return err
+		}
+		current, err := t.checkUnchanged()
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(toml, t.raw) {
+			previous = current
+		}
+	} else {
+		key, err := acquireLock(path, true)
+		if err != nil {
+			return err
+		}
+		defer releaseLock(key)
+		if data, err := os.ReadFile(path); err == nil {
+			previous = data
+		}
+	}
+
+	// Check if the target file is encrypted
+	targetEncrypted, err := isFileEncrypted(path)
 	if err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("failed to check if file is encrypted: %w", err)
 	}
 
-	// If file doesn't exist, default to not encrypting
+	// If file doesn't exist, it is not
 	if os.IsNotExist(err) {
-		shouldEncrypt = false
+		targetEncrypted = false
 	}
 
 	var content []byte
-	if shouldEncrypt {
-		// Get password for encryption
-		passwordData, err := encrypt.ReadPasswordFile()
+	var binding encrypt.Binding
+	plaintext := WriteEncryption == EncryptNever
+	if !plaintext && (len(t.recipients) > 0 || t.key != nil || targetEncrypted ||
+		WriteEncryption == EncryptAlways && t.fields == nil) {
+		if binding, err = t.nextBinding(path); err != nil {
+			return err
+		}
+	}
+	switch {
+	case plaintext:
+		if content, err = t.plaintext(path, toml, targetEncrypted); err != nil {
+			return err
+		}
+	case len(t.recipients) > 0:
+		// A fresh file key is wrapped for the recipients on every write
+		encryptedContent, err := encrypt.EncryptTo(toml, t.recipients, binding)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt content: %w", err)
+		}
+		content = []byte(encryptedContent)
+	case t.key != nil || WriteEncryption == EncryptAlways && t.fields == nil && !targetEncrypted:
+		// Encrypt with the key and KDF of the file loaded, or a new password
+		if t.key == nil {
+			if err := t.newKey(); err != nil {
+				return fmt.Errorf("failed to get key for encryption: %w", err)
+			}
+		}
+		encryptedContent, err := encrypt.EncryptWithKey(toml, t.key, t.kdf, binding)
 		if err != nil {
-			return fmt.Errorf("failed to get password for encryption: %w", err)
+			return fmt.Errorf("failed to encrypt content: %w", err)
+		}
+		content = []byte(encryptedContent)
+		// The file written is unlocked as the file loaded is
+		cacheKey(fileKeyID(binding, t.kdf), t.key)
+	case targetEncrypted:
+		// Encrypt with the key and KDF of the file replaced
+		target, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		key, kdf, err := passwordKey(target)
+		if err != nil {
+			return fmt.Errorf("failed to get key for encryption: %w", err)
 		}
 
 		// Encrypt the content
-		encryptedContent, err := encrypt.Encrypt([]byte(toml), passwordData.Password)
+		encryptedContent, err := encrypt.EncryptWithKey(toml, key, kdf, binding)
 		if err != nil {
 			return fmt.Errorf("failed to encrypt content: %w", err)
 		}
 		content = []byte(encryptedContent)
-	} else {
-		content = []byte(toml)
+	default:
+		content = toml
+	}
+
+	audit, values, err := t.auditChanges(path)
+	if err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	if previous != nil && !bytes.Equal(previous, content) {
+		if err := saveVersion(path, previous); err != nil {
+			return fmt.Errorf("failed to save history: %w", err)
+		}
+	}
+	if err := WriteFileAtomic(path, content); err != nil {
+		return err
+	}
+	if len(audit) > 0 {
+		if err := appendAudit(path, AuditWrite, audit, values); err != nil {
+			return fmt.Errorf("failed to write audit log: %w", err)
+		}
+	}
+	if err := remember(path, binding, false); err != nil {
+		return fmt.Errorf("failed to remember revision: %w", err)
 	}
+	// Later writes are patched against what is now on disk.
+	t.raw, t.layout = toml, nil
+	if path == t.path {
+		t.hash = sha256.Sum256(content)
+		t.binding = binding
+		if plaintext && t.Encrypted() {
+			// The file is now in plaintext.
+			t.key, t.kdf, t.recipients = nil, nil, nil
+			if t.fields != nil {
+				t.raw = content
+				return t.load()
+			}
+		}
+	}
+	return nil
+}
+
+// Encrypted reports whether the file loaded is encrypted: with a password,
+// to recipients, or in field mode.
+func (t *Toml) Encrypted() bool {
+	return t.key != nil || len(t.recipients) > 0 || t.fields != nil
+}
 
-	return os.WriteFile(path, content, 0644)
+// adoptEncryption makes a plaintext t encrypted as src is, so that what src
+// contributes to t is not written out in plaintext.
+func (t *Toml) adoptEncryption(src *Toml) {
+	if t.Encrypted() || !src.Encrypted() {
+		return
+	}
+	switch {
+	case len(src.recipients) > 0:
+		t.recipients = src.recipients
+	case src.key != nil:
+		t.key, t.kdf = src.key, src.kdf
+	default:
+		t.fields = src.fields
+		t.tree.SetPath([]string{FieldsKey}, src.fields.meta())
+	}
+}
+
+// plaintext returns doc, to be written at path, in plaintext. Secrets of an
+// encrypted file, or written over one, are only written out in plaintext once
+// ConfirmPlaintext agrees.
+func (t *Toml) plaintext(path string, doc []byte, targetEncrypted bool) ([]byte, error) {
+	if !t.Encrypted() && !targetEncrypted {
+		return doc, nil
+	}
+	if err := confirmPlaintext(path); err != nil {
+		return nil, err
+	}
+	if t.fields == nil {
+		return doc, nil
+	}
+	if err := t.fields.unlock(); err != nil {
+		return nil, err
+	}
+	return t.fields.plaintext(doc)
+}
+
+// CheckPlaintext returns ErrPlaintext unless content of files may be written
+// in plaintext to path, or printed if path is empty, as exports do: it is
+// asked with ConfirmPlaintext if one of the files or the file at path is
+// encrypted.
+func CheckPlaintext(path string, files ...*Toml) error {
+	encrypted := false
+	if path != "" {
+		var err error
+		if encrypted, err = isFileEncrypted(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	for _, f := range files {
+		encrypted = encrypted || f.Encrypted()
+	}
+	if !encrypted {
+		return nil
+	}
+	if path == "" {
+		path = "stdout"
+	}
+	return confirmPlaintext(path)
+}
+
+// confirmPlaintext returns ErrPlaintext unless ConfirmPlaintext agrees to
+// write path in plaintext.
+func confirmPlaintext(path string) error {
+	if ConfirmPlaintext == nil {
+		return ErrPlaintext
+	}
+	ok, err := ConfirmPlaintext(path)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrPlaintext
+	}
+	return nil
+}
+
+// checkUnchanged refuses to overwrite a file that is no longer what was read.
+// It returns the current content of the file.
+func (t *Toml) checkUnchanged() ([]byte, error) {
+	current, err := os.ReadFile(t.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%s: %w (it was removed)", t.path, ErrModified)
+		}
+		return nil, err
+	}
+	if sha256.Sum256(current) != t.hash {
+		return nil, fmt.Errorf("%s: %w, re-run the command", t.path, ErrModified)
+	}
+	return current, nil
+}
+
+// WriteFileAtomic replaces path with data so that readers and crashes see
+// either the old or the new content, never a mix: data goes to a temporary
+// file in the same directory which is synced and then renamed over path. The
+// mode of path is kept.
+func WriteFileAtomic(path string, data []byte) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+
+	f, err := os.CreateTemp(dir, "."+name+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, mode); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return syncDir(dir)
 }
@@ -0,0 +1,272 @@
+package toml
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/MinseokOh/toml-cli/agent"
+	"github.com/MinseokOh/toml-cli/encrypt"
+	"github.com/stretchr/testify/require"
+)
+
+func writeSample(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "cmdb.toml")
+	require.Nil(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestWriteRefusesModifiedFile(t *testing.T) {
+	path := writeSample(t, "a = 1\n")
+	toml, err := NewToml(path)
+	require.Nil(t, err)
+	defer toml.Close()
+
+	require.Nil(t, os.WriteFile(path, []byte("a = 2\n"), 0600))
+	require.Nil(t, toml.Set("b", "", int64(3)))
+	require.ErrorIs(t, toml.Write(), ErrModified)
+
+	data, err := os.ReadFile(path)
+	require.Nil(t, err)
+	require.Equal(t, "a = 2\n", string(data))
+}
+
+func TestWriteAtomic(t *testing.T) {
+	path := writeSample(t, "a = 1\n")
+	toml, err := NewToml(path)
+	require.Nil(t, err)
+	defer toml.Close()
+
+	require.Nil(t, toml.Set("a", "", int64(2)))
+	require.Nil(t, toml.Write())
+	// The stored hash follows our own writes.
+	require.Nil(t, toml.Set("a", "", int64(3)))
+	require.Nil(t, toml.Write())
+
+	data, err := os.ReadFile(path)
+	require.Nil(t, err)
+	require.Equal(t, "a = 3\n", string(data))
+	info, err := os.Stat(path)
+	require.Nil(t, err)
+	if runtime.GOOS != "windows" {
+		require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.Nil(t, err)
+	for _, e := range entries {
+		require.NotContains(t, e.Name(), ".tmp-")
+	}
+}
+
+func TestLock(t *testing.T) {
+	path := writeSample(t, "[s]\na = 1\n")
+	first, err := NewToml(path)
+	require.Nil(t, err)
+	// Loading again in the same process shares the lock.
+	second, err := NewToml(path)
+	require.Nil(t, err)
+	second.Close()
+
+	// Another open file description, as another process would have, may read
+	// along but not write.
+	key, err := lockPath(path)
+	require.Nil(t, err)
+	f, err := os.OpenFile(key, os.O_RDWR, 0600)
+	require.Nil(t, err)
+	defer f.Close()
+	locking := runtime.GOOS == "linux" || runtime.GOOS == "darwin" || runtime.GOOS == "windows"
+	require.Nil(t, tryLock(f, false))
+	if locking {
+		timeout := LockTimeout
+		LockTimeout = 100 * time.Millisecond
+		require.NotNil(t, first.Write())
+		LockTimeout = timeout
+	}
+	require.Nil(t, unlock(f))
+
+	// Writing takes the lock exclusively until Close.
+	require.Nil(t, first.Set("s", "a", "2"))
+	require.Nil(t, first.Write())
+	if locking {
+		require.ErrorIs(t, tryLock(f, false), errLocked)
+	}
+	first.Close()
+	require.Nil(t, tryLock(f, true))
+
+	timeout := LockTimeout
+	LockTimeout = 100 * time.Millisecond
+	defer func() { LockTimeout = timeout }()
+	_, err = NewToml(path)
+	require.NotNil(t, err)
+	require.Nil(t, unlock(f))
+}
+
+func TestLockFile(t *testing.T) {
+	path := writeSample(t, "a = 1\n")
+	unlockFile, err := LockFile(path)
+	require.Nil(t, err)
+
+	key, err := lockPath(path)
+	require.Nil(t, err)
+	f, err := os.OpenFile(key, os.O_RDWR, 0600)
+	require.Nil(t, err)
+	defer f.Close()
+	if runtime.GOOS == "linux" || runtime.GOOS == "darwin" || runtime.GOOS == "windows" {
+		require.ErrorIs(t, tryLock(f, false), errLocked)
+	}
+	unlockFile()
+	require.Nil(t, tryLock(f, false))
+	require.Nil(t, unlock(f))
+}
+
+func TestWriteToRecipients(t *testing.T) {
+	t.Setenv(encrypt.IdentityEnv, filepath.Join(t.TempDir(), "identity"))
+	t.Setenv(SeenEnv, filepath.Join(t.TempDir(), "seen.json"))
+	id, err := encrypt.GenerateIdentity()
+	require.Nil(t, err)
+	require.Nil(t, encrypt.SaveIdentity(id, encrypt.IdentityPath()))
+
+	path := writeSample(t, "a = 1\n")
+	toml, err := NewToml(path)
+	require.Nil(t, err)
+	toml.SetRecipients([]encrypt.Recipient{{Name: "me", PublicKey: id.PublicKey()}})
+	require.Nil(t, toml.Write())
+	toml.Close()
+
+	data, err := os.ReadFile(path)
+	require.Nil(t, err)
+	require.True(t, encrypt.IsRecipientEncrypted(data))
+
+	toml, err = NewToml(path)
+	require.Nil(t, err)
+	defer toml.Close()
+	require.Equal(t, int64(1), toml.Get("a"))
+	require.Equal(t, []encrypt.Recipient{{Name: "me", PublicKey: id.PublicKey()}}, toml.Recipients())
+}
+
+func TestRollback(t *testing.T) {
+	t.Setenv(agent.SocketEnv, filepath.Join(t.TempDir(), "agent.sock"))
+	t.Setenv(SeenEnv, filepath.Join(t.TempDir(), "seen.json"))
+	t.Setenv(encrypt.PasswordEnv, "pw")
+
+	enc, err := encrypt.EncryptKDF([]byte("[s]\na = 1\n"), "pw", encrypt.KDFPBKDF2)
+	require.Nil(t, err)
+	path := writeSample(t, enc)
+
+	toml, err := NewToml(path)
+	require.Nil(t, err)
+	require.Nil(t, toml.Set("s", "a", int64(2)))
+	require.Nil(t, toml.Write())
+	toml.Close()
+	data, err := os.ReadFile(path)
+	require.Nil(t, err)
+	binding, err := encrypt.ReadBinding(data)
+	require.Nil(t, err)
+	require.Equal(t, uint64(2), binding.Counter)
+
+	// The older revision, or another file, is refused.
+	other, err := encrypt.EncryptKDF([]byte("a = 3\n"), "pw", encrypt.KDFPBKDF2)
+	require.Nil(t, err)
+	for _, content := range []string{enc, other} {
+		require.Nil(t, os.WriteFile(path, []byte(content), 0600))
+		_, err = NewToml(path)
+		require.ErrorIs(t, err, ErrRollback)
+	}
+
+	// So is a ciphertext without a binding, as written before them.
+	kdf, err := encrypt.PasswordKDF([]byte(enc))
+	require.Nil(t, err)
+	key, err := kdf.Derive("pw")
+	require.Nil(t, err)
+	unbound, err := encrypt.EncryptWithKey([]byte("[s]\na = 1\n"), key, kdf, encrypt.Binding{})
+	require.Nil(t, err)
+	require.Nil(t, os.WriteFile(path, []byte(unbound), 0600))
+	_, err = NewToml(path)
+	require.ErrorIs(t, err, ErrRollback)
+
+	// Allowed, writing continues after the latest revision seen.
+	require.Nil(t, os.WriteFile(path, []byte(enc), 0600))
+	AllowRollback = true
+	defer func() { AllowRollback = false }()
+	toml, err = NewToml(path)
+	require.Nil(t, err)
+	require.Nil(t, toml.Write())
+	toml.Close()
+	data, err = os.ReadFile(path)
+	require.Nil(t, err)
+	binding, err = encrypt.ReadBinding(data)
+	require.Nil(t, err)
+	require.Equal(t, uint64(3), binding.Counter)
+}
+
+func TestWriteFollowsSource(t *testing.T) {
+	t.Setenv(agent.SocketEnv, filepath.Join(t.TempDir(), "agent.sock"))
+	t.Setenv(SeenEnv, filepath.Join(t.TempDir(), "seen.json"))
+	t.Setenv(encrypt.PasswordEnv, "pw")
+	defer func() { WriteEncryption, ConfirmPlaintext = EncryptAsSource, nil }()
+
+	enc, err := encrypt.EncryptKDF([]byte(fieldsSample), "pw", encrypt.KDFPBKDF2)
+	require.Nil(t, err)
+	fields, err := EncryptFields([]byte(fieldsSample), "pw", encrypt.KDFPBKDF2, nil)
+	require.Nil(t, err)
+	write := func(source, out string) ([]byte, error) {
+		toml, err := NewToml(source)
+		require.Nil(t, err)
+		defer toml.Close()
+		toml.Out(out)
+		if err := toml.Write(); err != nil {
+			return nil, err
+		}
+		return os.ReadFile(out)
+	}
+
+	source := writeSample(t, enc)
+	out := filepath.Join(t.TempDir(), "out.toml")
+	data, err := write(source, out)
+	require.Nil(t, err)
+	plain, err := encrypt.Decrypt(string(data), "pw")
+	require.Nil(t, err)
+	require.Equal(t, fieldsSample, string(plain))
+
+	// Downgrading has to be confirmed.
+	WriteEncryption = EncryptNever
+	for _, content := range []string{enc, string(fields)} {
+		source := writeSample(t, content)
+		out := filepath.Join(t.TempDir(), "out.toml")
+		_, err = write(source, out)
+		require.ErrorIs(t, err, ErrPlaintext)
+		ConfirmPlaintext = func(string) (bool, error) { return false, nil }
+		_, err = write(source, out)
+		require.ErrorIs(t, err, ErrPlaintext)
+		ConfirmPlaintext = func(string) (bool, error) { return true, nil }
+		data, err = write(source, out)
+		require.Nil(t, err)
+		require.Equal(t, fieldsSample, string(data))
+		ConfirmPlaintext = nil
+	}
+
+	WriteEncryption = EncryptAlways
+	data, err = write(writeSample(t, fieldsSample), out)
+	require.Nil(t, err)
+	plain, err = encrypt.Decrypt(string(data), "pw")
+	require.Nil(t, err)
+	require.Equal(t, fieldsSample, string(plain))
+	WriteEncryption = EncryptAsSource
+
+	// An encrypted overlay makes the merge encrypted.
+	base, err := NewToml(writeSample(t, "a = 1\n"))
+	require.Nil(t, err)
+	defer base.Close()
+	overlay, err := NewToml(source)
+	require.Nil(t, err)
+	defer overlay.Close()
+	require.Nil(t, base.MergeWith(&overlay, MergeOptions{}))
+	base.Out(filepath.Join(t.TempDir(), "merged.toml"))
+	require.Nil(t, base.Write())
+	data, err = os.ReadFile(base.out)
+	require.Nil(t, err)
+	require.True(t, encrypt.IsEncrypted(data))
+}
@@ -0,0 +1,163 @@
+package toml
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/MinseokOh/toml-cli/agent"
+	"github.com/MinseokOh/toml-cli/encrypt"
+	lib "github.com/pelletier/go-toml"
+)
+
+// AgentTTL is how long the agent keeps the keys this process unlocks; 0 is
+// the agent's default.
+var AgentTTL time.Duration
+
+// keys holds the keys unlocked by this process, by key ID, see fileKeyID.
+var keys = make(map[string][]byte)
+
+// fileKeyID returns the ID the key of a file encrypted whole with a password
+// is kept by: the file ID of its binding, so that every file has its own
+// entry and TTL in the agent whatever its password, or for files written
+// before bindings the ID of kdf. Keys of files in field mode are kept by the
+// ID of their KDF.
+func fileKeyID(b encrypt.Binding, kdf *encrypt.KDF) string {
+	if b.ID != "" {
+		return "file:" + b.ID
+	}
+	return kdf.ID()
+}
+
+// cacheKey keeps key by id in this process and in the agent.
+func cacheKey(id string, key []byte) {
+	keys[id] = key
+	// Without an agent the password is asked again by the next command.
+	agent.Put(id, key, AgentTTL)
+}
+
+// unlockKey returns the key derived by kdf from the password of a file, kept
+// by id. It is looked up in this process, then in the agent, and only then
+// derived from the password (see encrypt.ReadPassword), which costs a key
+// derivation. check tells whether a key opens the file.
+func unlockKey(id string, kdf *encrypt.KDF, check func(key []byte) error) ([]byte, error) {
+	if key, ok := keys[id]; ok && check(key) == nil {
+		return key, nil
+	}
+	if key, err := agent.Get(id); err == nil && key != nil && check(key) == nil {
+		keys[id] = key
+		return key, nil
+	}
+
+	password, err := encrypt.ReadPassword(false)
+	if err != nil {
+		return nil, err
+	}
+	key, err := kdf.Derive(password)
+	if err != nil {
+		return nil, err
+	}
+	if err := check(key); err != nil {
+		return nil, encrypt.ErrWrongPassword
+	}
+	cacheKey(id, key)
+	return key, nil
+}
+
+// newKey makes t encrypted with a new password, see encrypt.ReadPassword,
+// and the key derived by DefaultKDF.
+func (t *Toml) newKey() error {
+	password, err := encrypt.ReadPassword(true)
+	if err != nil {
+		return err
+	}
+	kdf, err := encrypt.NewKDF(encrypt.DefaultKDF)
+	if err != nil {
+		return err
+	}
+	key, err := kdf.Derive(password)
+	if err != nil {
+		return err
+	}
+	t.key, t.kdf = key, kdf
+	return nil
+}
+
+// passwordKey returns the key and KDF of data, a password encrypted file.
+func passwordKey(data []byte) (key []byte, kdf *encrypt.KDF, err error) {
+	if kdf, err = encrypt.PasswordKDF(data); err != nil {
+		return nil, nil, err
+	}
+	binding, err := encrypt.ReadBinding(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err = unlockKey(fileKeyID(binding, kdf), kdf, func(key []byte) error {
+		_, err := encrypt.DecryptWithKey(string(data), key)
+		return err
+	})
+	return key, kdf, err
+}
+
+// keyIDs returns the IDs the key of data, a file encrypted with a password,
+// may be kept by.
+func keyIDs(data []byte) ([]string, error) {
+	if IsFieldEncrypted(data) {
+		tree, err := lib.LoadBytes(data)
+		if err != nil {
+			return nil, err
+		}
+		f, err := readFields(tree)
+		if err != nil {
+			return nil, err
+		}
+		return []string{f.kdf.ID()}, nil
+	}
+	if !encrypt.IsEncrypted(data) || encrypt.IsRecipientEncrypted(data) {
+		return nil, fmt.Errorf("not encrypted with a password")
+	}
+	kdf, err := encrypt.PasswordKDF(data)
+	if err != nil {
+		return nil, err
+	}
+	binding, err := encrypt.ReadBinding(data)
+	if err != nil {
+		return nil, err
+	}
+	// The key may also be kept by the ID of its KDF from before the file had
+	// a binding.
+	ids := []string{fileKeyID(binding, kdf)}
+	if binding.ID != "" {
+		ids = append(ids, kdf.ID())
+	}
+	return ids, nil
+}
+
+// ForgetKey makes this process and the agent forget the key of the file at
+// path, encrypted with a password. Keys of other files are kept.
+func ForgetKey(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	ids, err := keyIDs(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for _, id := range ids {
+		delete(keys, id)
+		if err := agent.Forget(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Unlock makes sure the key of a file in field mode is known, asking for the
+// password if needed. The key of an encrypted file is known once loaded.
+func (t *Toml) Unlock() error {
+	if t.fields == nil {
+		return nil
+	}
+	return t.fields.unlock()
+}
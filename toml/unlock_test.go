@@ -0,0 +1,63 @@
+package toml
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/MinseokOh/toml-cli/agent"
+	"github.com/MinseokOh/toml-cli/encrypt"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeysPerFile(t *testing.T) {
+	t.Setenv(agent.SocketEnv, filepath.Join(t.TempDir(), "agent.sock"))
+	t.Setenv(SeenEnv, filepath.Join(t.TempDir(), "seen.json"))
+	ln, err := agent.Listen(agent.SocketPath())
+	require.Nil(t, err)
+	defer ln.Close()
+	go agent.New(time.Minute).Serve(ln)
+
+	load := func(path string) error {
+		// Keys have to come from the agent.
+		keys = make(map[string][]byte)
+		toml, err := NewToml(path)
+		if err == nil {
+			toml.Close()
+		}
+		return err
+	}
+
+	paths := make(map[string]string)
+	for _, password := range []string{"personal", "team"} {
+		enc, err := encrypt.EncryptKDF([]byte("[s]\na = 1\n"), password, encrypt.KDFPBKDF2)
+		require.Nil(t, err)
+		paths[password] = writeSample(t, enc)
+		t.Setenv(encrypt.PasswordEnv, password)
+		require.Nil(t, load(paths[password]))
+	}
+	os.Unsetenv(encrypt.PasswordEnv)
+	encrypt.NoPrompt = true
+	defer func() { encrypt.NoPrompt = false }()
+
+	// Both files stay unlocked, each with its own key.
+	for password, path := range paths {
+		toml, err := NewToml(path)
+		require.Nil(t, err)
+		require.Nil(t, toml.Set("s", "a", int64(2)))
+		require.Nil(t, toml.Write())
+		toml.Close()
+		data, err := os.ReadFile(path)
+		require.Nil(t, err)
+		_, err = encrypt.Decrypt(string(data), password)
+		require.Nil(t, err, password)
+	}
+
+	require.Nil(t, ForgetKey(paths["personal"]))
+	require.ErrorIs(t, load(paths["personal"]), encrypt.ErrNoPassword)
+	require.Nil(t, load(paths["team"]))
+	n, err := agent.Status()
+	require.Nil(t, err)
+	require.Equal(t, 1, n)
+}
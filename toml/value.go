@@ -0,0 +1,201 @@
+package toml
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	lib "github.com/pelletier/go-toml"
+)
+
+// Value types understood by ParseValue.
+const (
+	TypeAuto     = "auto"
+	TypeString   = "string"
+	TypeInt      = "int"
+	TypeFloat    = "float"
+	TypeBool     = "bool"
+	TypeDate     = "date"
+	TypeDateTime = "datetime"
+	TypeTime     = "time"
+	TypeArray    = "array"
+	TypeTable    = "table"
+)
+
+var valueTypes = []string{TypeAuto, TypeString, TypeInt, TypeFloat, TypeBool, TypeDate, TypeDateTime, TypeTime, TypeArray, TypeTable}
+
+// IsValueType reports whether typ is one of the types accepted by ParseValue.
+func IsValueType(typ string) bool {
+	for _, t := range valueTypes {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// TypeOf names the type of a tree value the way ParseValue does.
+func TypeOf(v interface{}) string {
+	switch v.(type) {
+	case string:
+		return TypeString
+	case int64, uint64:
+		return TypeInt
+	case float64:
+		return TypeFloat
+	case bool:
+		return TypeBool
+	case lib.LocalDate:
+		return TypeDate
+	case lib.LocalDateTime, time.Time:
+		return TypeDateTime
+	case lib.LocalTime:
+		return TypeTime
+	case []interface{}, []*lib.Tree:
+		return TypeArray
+	case *lib.Tree:
+		return TypeTable
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// SplitTypedAttr splits an attribute spec such as port:int into the attribute
+// and its type. The suffix only counts as a type when it names one, so keys
+// like ns:host:web are left alone.
+func SplitTypedAttr(attr string) (string, string) {
+	if i := strings.LastIndexByte(attr, ':'); i > 0 && IsValueType(attr[i+1:]) {
+		return attr[:i], attr[i+1:]
+	}
+	return attr, ""
+}
+
+// ParseValue converts command line input into a TOML value of type typ.
+//
+// With TypeAuto (or an empty type) s is read as a TOML literal, so 22 becomes
+// an integer, true a boolean, 2024-01-02 a local date, ["a", "b"] an array and
+// { a = 1 } a table. Anything that is not a valid literal stays a string, and
+// so does a number, boolean or date that would not be written back as typed:
+// 0x1F, 1_000, +86138 or 1e3 are kept as the user wrote them.
+func ParseValue(s, typ string) (interface{}, error) {
+	switch typ {
+	case "", TypeAuto:
+		v, err := parseLiteral(s)
+		if err != nil {
+			return s, nil
+		}
+		switch v.(type) {
+		case string, []interface{}, []*lib.Tree, *lib.Tree:
+			return v, nil
+		}
+		if f, ok := v.(float64); ok && (math.IsInf(f, 0) || math.IsNaN(f)) {
+			return s, nil
+		}
+		if literalText(v) != s {
+			return s, nil
+		}
+		return v, nil
+	case TypeString:
+		return s, nil
+	case TypeInt:
+		if v, err := parseLiteral(s); err == nil {
+			if i, ok := v.(int64); ok {
+				return i, nil
+			}
+		}
+		return nil, fmt.Errorf("%q is not an integer", s)
+	case TypeFloat:
+		if v, err := parseLiteral(s); err == nil {
+			switch f := v.(type) {
+			case float64:
+				return f, nil
+			case int64:
+				return float64(f), nil
+			}
+		}
+		return nil, fmt.Errorf("%q is not a float", s)
+	case TypeBool:
+		v, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a boolean", s)
+		}
+		return v, nil
+	case TypeDate:
+		v, err := lib.ParseLocalDate(s)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a date (YYYY-MM-DD)", s)
+		}
+		return v, nil
+	case TypeDateTime:
+		if v, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			return v, nil
+		}
+		v, err := lib.ParseLocalDateTime(s)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a date-time", s)
+		}
+		return v, nil
+	case TypeTime:
+		v, err := lib.ParseLocalTime(s)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a time (HH:MM:SS)", s)
+		}
+		return v, nil
+	case TypeArray:
+		if strings.HasPrefix(strings.TrimSpace(s), "[") {
+			v, err := parseLiteral(s)
+			if err != nil {
+				return nil, fmt.Errorf("%q is not an array: %w", s, err)
+			}
+			switch v.(type) {
+			case []interface{}, []*lib.Tree:
+				return v, nil
+			}
+			return nil, fmt.Errorf("%q is not an array", s)
+		}
+		// Plain comma separated input is an array of strings.
+		arr := make([]interface{}, 0)
+		for _, e := range strings.Split(s, ",") {
+			if e = strings.TrimSpace(e); e != "" {
+				arr = append(arr, e)
+			}
+		}
+		return arr, nil
+	case TypeTable:
+		v, err := parseLiteral(s)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an inline table: %w", s, err)
+		}
+		if t, ok := v.(*lib.Tree); ok {
+			return t, nil
+		}
+		return nil, fmt.Errorf("%q is not an inline table", s)
+	}
+	return nil, fmt.Errorf("unknown type %q, expected one of %s", typ, strings.Join(valueTypes, ", "))
+}
+
+// literalText returns how the scalar v is written in a TOML document.
+func literalText(v interface{}) string {
+	switch n := v.(type) {
+	case lib.LocalDate:
+		return n.String()
+	case lib.LocalDateTime:
+		return n.String()
+	case lib.LocalTime:
+		return n.String()
+	}
+	return FormatValue(v)
+}
+
+// parseLiteral reads s as the right hand side of a TOML key/value pair.
+func parseLiteral(s string) (interface{}, error) {
+	if strings.ContainsAny(s, "\r\n") {
+		return nil, fmt.Errorf("multi-line input")
+	}
+	tree, err := lib.Load("v = " + s)
+	if err != nil {
+		return nil, err
+	}
+	return tree.GetPath([]string{"v"}), nil
+}
@@ -0,0 +1,71 @@
+package toml
+
+import (
+	"testing"
+
+	lib "github.com/pelletier/go-toml"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseValueAuto(t *testing.T) {
+	cases := map[string]interface{}{
+		"22":             int64(22),
+		"-1.5":           -1.5,
+		"true":           true,
+		"web01":          "web01",
+		"10.0.0.1":       "10.0.0.1",
+		`"22"`:           "22",
+		"nan":            "nan",
+		"2024-01-02":     lib.LocalDate{Year: 2024, Month: 1, Day: 2},
+		`["web","db"]`:   []interface{}{"web", "db"},
+		"0x1F":           "0x1F",
+		"1_000":          "1_000",
+		"+8613800000000": "+8613800000000",
+		"1e3":            "1e3",
+		"1.10":           "1.10",
+		"007":            "007",
+		"10:30:00":       lib.LocalTime{Hour: 10, Minute: 30},
+	}
+	for in, want := range cases {
+		v, err := ParseValue(in, TypeAuto)
+		require.Nil(t, err, in)
+		require.Equal(t, want, v, in)
+	}
+
+	v, err := ParseValue("{ agent = true, port = 22 }", "")
+	require.Nil(t, err)
+	require.Equal(t, map[string]interface{}{"agent": true, "port": int64(22)}, v.(*lib.Tree).ToMap())
+}
+
+func TestParseValueTyped(t *testing.T) {
+	v, err := ParseValue("22", TypeString)
+	require.Nil(t, err)
+	require.Equal(t, "22", v)
+
+	v, err = ParseValue("2222", TypeInt)
+	require.Nil(t, err)
+	require.Equal(t, int64(2222), v)
+
+	v, err = ParseValue("3", TypeFloat)
+	require.Nil(t, err)
+	require.Equal(t, 3.0, v)
+
+	v, err = ParseValue("web, db", TypeArray)
+	require.Nil(t, err)
+	require.Equal(t, []interface{}{"web", "db"}, v)
+
+	for typ, in := range map[string]string{TypeInt: "22a", TypeBool: "yes", TypeDate: "2024-13-01", TypeTable: "[1]", "bogus": "1"} {
+		_, err = ParseValue(in, typ)
+		require.NotNil(t, err, typ)
+	}
+}
+
+func TestSplitTypedAttr(t *testing.T) {
+	attr, typ := SplitTypedAttr("port:int")
+	require.Equal(t, "port", attr)
+	require.Equal(t, TypeInt, typ)
+
+	attr, typ = SplitTypedAttr("ns:host:web")
+	require.Equal(t, "ns:host:web", attr)
+	require.Equal(t, "", typ)
+}